@@ -1,15 +1,212 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"wallet-simulator/internal/config"
+	"wallet-simulator/internal/handlers/validation"
+	"wallet-simulator/internal/repository"
 
 	_ "github.com/lib/pq"
 )
 
+const usage = `usage:
+  cli [migrate]
+  cli dead-letters list [open|replayed|discarded]
+  cli dead-letters show <id>
+  cli dead-letters replay <id>
+  cli dead-letters discard <id>
+  cli withdrawals review
+  cli withdrawals resolve <id> requeue|fail|cancel
+  cli accounts check
+  cli ledger check`
+
 func main() {
-	Migrate()
+	if len(os.Args) < 2 || os.Args[1] == "migrate" {
+		Migrate()
+		return
+	}
+
+	switch os.Args[1] {
+	case "dead-letters":
+		DeadLetters(os.Args[2:])
+	case "withdrawals":
+		Withdrawals(os.Args[2:])
+	case "accounts":
+		Accounts(os.Args[2:])
+	case "ledger":
+		Ledger(os.Args[2:])
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// Accounts checks the materialized balances against the raw transaction
+// sums and exits non-zero when any account is out of sync.
+func Accounts(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		log.Fatal(usage)
+	}
+
+	db, err := sql.Open("postgres", config.Load().GetDSN())
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	defer db.Close()
+
+	mismatches, err := repository.NewRepository(db).CheckAccountConsistency(context.Background())
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	for _, m := range mismatches {
+		fmt.Printf("user=%d\ttotal=%d\texpected_total=%d\twithdrawable=%d\texpected_withdrawable=%d\n",
+			m.UserID, m.Total, m.ExpectedTotal, m.Withdrawable, m.ExpectedWithdrawable)
+	}
+	if len(mismatches) > 0 {
+		log.Fatalf("❌ %d account(s) out of sync", len(mismatches))
+	}
+	fmt.Println("✅ All accounts consistent")
+}
+
+// Ledger verifies that every journal entry balances and exits non-zero
+// when one does not.
+func Ledger(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		log.Fatal(usage)
+	}
+
+	db, err := sql.Open("postgres", config.Load().GetDSN())
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	defer db.Close()
+
+	imbalances, err := repository.NewRepository(db).CheckLedgerInvariant(context.Background())
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	for _, im := range imbalances {
+		fmt.Printf("journal=%d\tkind=%s\tsum=%d\n", im.JournalID, im.Kind, im.Sum)
+	}
+	if len(imbalances) > 0 {
+		log.Fatalf("❌ %d unbalanced journal(s)", len(imbalances))
+	}
+	fmt.Println("✅ All journals balance")
+}
+
+// DeadLetters lets operators inspect and recover permanently failed tasks
+// against the database configured through the usual DB_* variables.
+func DeadLetters(args []string) {
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+
+	db, err := sql.Open("postgres", config.Load().GetDSN())
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	defer db.Close()
+	repo := repository.NewRepository(db)
+
+	if args[0] == "list" {
+		status := ""
+		if len(args) > 1 {
+			status = args[1]
+		}
+		deadLetters, total, err := repo.GetDeadLetters(status, 1, 100)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		for _, dl := range deadLetters {
+			fmt.Printf("%d\t%s\t%s\t%s\tuser=%d\tattempts=%d\t%s\n",
+				dl.ID, dl.Status, dl.TaskType, dl.TaskKey, dl.UserID, dl.Attempts, dl.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Printf("%d of %d dead letter(s)\n", len(deadLetters), total)
+		return
+	}
+
+	if len(args) < 2 {
+		log.Fatal(usage)
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		log.Fatalf("❌ invalid dead letter id %q", args[1])
+	}
+
+	switch args[0] {
+	case "show":
+		dl, err := repo.GetDeadLetter(id)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		out, _ := json.MarshalIndent(dl, "", "  ")
+		fmt.Println(string(out))
+	case "replay":
+		dl, err := repo.ReplayDeadLetter(context.Background(), id)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Printf("✅ Dead letter %d replayed as withdrawal %s\n", dl.ID, *dl.ReplayKey)
+	case "discard":
+		if err := repo.DiscardDeadLetter(context.Background(), id); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Printf("✅ Dead letter %d discarded\n", id)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// Withdrawals lists the withdrawals startup recovery parked in manual_review
+// and resolves them. A requeued withdrawal is picked up by the workers on
+// their next poll.
+func Withdrawals(args []string) {
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+
+	db, err := sql.Open("postgres", config.Load().GetDSN())
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	defer db.Close()
+	repo := repository.NewRepository(db)
+
+	switch args[0] {
+	case "review":
+		withdrawals, err := repo.GetManualReviewWithdrawals(100)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		for _, w := range withdrawals {
+			fmt.Printf("%d\t%s\tuser=%d\tamount=%d\t%s\n",
+				w.ID, w.IdempotencyKey, w.UserID, -w.Amount, w.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Printf("%d withdrawal(s) in manual review\n", len(withdrawals))
+	case "resolve":
+		if len(args) < 3 {
+			log.Fatal(usage)
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatalf("❌ invalid withdrawal id %q", args[1])
+		}
+		if msg := validation.ValidateResolution(args[2]); msg != "" {
+			log.Fatalf("❌ %s", msg)
+		}
+		w, err := repo.ResolveManualReview(context.Background(), id, args[2])
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Printf("✅ Withdrawal %d is now %s\n", w.ID, w.Status)
+	default:
+		log.Fatal(usage)
+	}
 }
 
 func Migrate() {
@@ -25,13 +222,71 @@ func Migrate() {
 		panic(err)
 	}
 	_, err = db.Exec(`
+		DROP TABLE IF EXISTS fee_rules CASCADE;
+		DROP TABLE IF EXISTS withdrawal_limits CASCADE;
+		DROP TABLE IF EXISTS withdrawal_limit_tiers CASCADE;
+		DROP TABLE IF EXISTS standing_instruction_runs CASCADE;
+		DROP TABLE IF EXISTS standing_instructions CASCADE;
+		DROP TABLE IF EXISTS holds CASCADE;
+		DROP TABLE IF EXISTS release_changes CASCADE;
+		DROP TABLE IF EXISTS charge_releases CASCADE;
+		DROP TABLE IF EXISTS split_charge_legs CASCADE;
+		DROP TABLE IF EXISTS split_charges CASCADE;
+		DROP TABLE IF EXISTS postings CASCADE;
+		DROP TABLE IF EXISTS journal_entries CASCADE;
+		DROP TABLE IF EXISTS accounts CASCADE;
+		DROP TABLE IF EXISTS idempotency_keys CASCADE;
+		DROP TABLE IF EXISTS dead_letters CASCADE;
+		DROP TABLE IF EXISTS withdrawal_jobs CASCADE;
 		DROP TABLE IF EXISTS transactions CASCADE;
-		CREATE TABLE transactions (id SERIAL PRIMARY KEY, idempotency_key VARCHAR(255) UNIQUE, user_id INTEGER NOT NULL, amount BIGINT NOT NULL, "type" VARCHAR(10) NOT NULL, created_at TIMESTAMP NOT NULL, release_at TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
+		DROP TABLE IF EXISTS transfers CASCADE;
+		CREATE TABLE transfers (id SERIAL PRIMARY KEY, sender_id INTEGER NOT NULL, receiver_id INTEGER NOT NULL, amount BIGINT NOT NULL, release_at TIMESTAMP, idempotency_key VARCHAR(255) NOT NULL, created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, UNIQUE (sender_id, idempotency_key));
+		CREATE TABLE transactions (id SERIAL PRIMARY KEY, idempotency_key VARCHAR(255), user_id INTEGER NOT NULL, amount BIGINT NOT NULL, "type" VARCHAR(20) NOT NULL, created_at TIMESTAMP NOT NULL, release_at TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
 		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS status VARCHAR(20) DEFAULT 'pending';
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reference_id INTEGER REFERENCES transactions(id);
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS released_at TIMESTAMP;
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS transfer_id INTEGER REFERENCES transfers(id);
 		CREATE INDEX IF NOT EXISTS idx_user_id ON transactions(user_id);
 		CREATE INDEX IF NOT EXISTS idx_created_at ON transactions(created_at);
 		CREATE INDEX IF NOT EXISTS idx_status ON transactions(status);
 		CREATE INDEX IF NOT EXISTS idx_idempotency_key ON transactions(idempotency_key);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_user_type_key ON transactions(user_id, type, idempotency_key);
+		CREATE INDEX IF NOT EXISTS idx_transactions_unreleased ON transactions(release_at) WHERE type IN ('charge', 'transfer_in') AND released_at IS NULL;
+		CREATE INDEX IF NOT EXISTS idx_transactions_transfer_id ON transactions(transfer_id);
+		CREATE TABLE withdrawal_jobs (id SERIAL PRIMARY KEY, transaction_id INTEGER NOT NULL UNIQUE REFERENCES transactions(id), user_id INTEGER NOT NULL, amount BIGINT NOT NULL, idempotency_key VARCHAR(255) NOT NULL, status VARCHAR(20) NOT NULL DEFAULT 'queued', attempts INTEGER NOT NULL DEFAULT 0, payout_attempts INTEGER NOT NULL DEFAULT 0, run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, locked_until TIMESTAMP, last_error TEXT, bank_reference VARCHAR(255), created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
+		CREATE INDEX IF NOT EXISTS idx_withdrawal_jobs_claim ON withdrawal_jobs(status, run_at);
+		CREATE TABLE dead_letters (id SERIAL PRIMARY KEY, task_type VARCHAR(50) NOT NULL, task_key VARCHAR(255) NOT NULL, user_id INTEGER NOT NULL, payload JSONB NOT NULL, error_history JSONB NOT NULL DEFAULT '[]', attempts INTEGER NOT NULL DEFAULT 0, status VARCHAR(20) NOT NULL DEFAULT 'open', replay_key VARCHAR(255), created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
+		CREATE INDEX IF NOT EXISTS idx_dead_letters_status ON dead_letters(status);
+		CREATE TABLE idempotency_keys (id SERIAL PRIMARY KEY, idempotency_key VARCHAR(255) NOT NULL, operation VARCHAR(20) NOT NULL, user_id INTEGER NOT NULL, fingerprint VARCHAR(64) NOT NULL, status VARCHAR(20) NOT NULL DEFAULT 'in_progress', response_code INTEGER, response_body TEXT, content_type VARCHAR(100), created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_keys_scope ON idempotency_keys(user_id, operation, idempotency_key);
+		CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+		CREATE TABLE accounts (user_id INTEGER PRIMARY KEY, total BIGINT NOT NULL DEFAULT 0, withdrawable BIGINT NOT NULL DEFAULT 0, version INTEGER NOT NULL DEFAULT 0, created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
+		CREATE TABLE journal_entries (id SERIAL PRIMARY KEY, kind VARCHAR(30) NOT NULL, transaction_id INTEGER REFERENCES transactions(id), created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+		CREATE INDEX IF NOT EXISTS idx_journal_entries_transaction_id ON journal_entries(transaction_id);
+		CREATE TABLE postings (id SERIAL PRIMARY KEY, journal_id INTEGER NOT NULL REFERENCES journal_entries(id), account VARCHAR(64) NOT NULL, amount BIGINT NOT NULL);
+		CREATE INDEX IF NOT EXISTS idx_postings_journal_id ON postings(journal_id);
+		CREATE INDEX IF NOT EXISTS idx_postings_account ON postings(account);
+		CREATE TABLE split_charges (id SERIAL PRIMARY KEY, payer_id INTEGER NOT NULL DEFAULT 0, amount BIGINT NOT NULL, idempotency_key VARCHAR(255) NOT NULL, created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, UNIQUE (payer_id, idempotency_key));
+		CREATE TABLE split_charge_legs (split_charge_id INTEGER NOT NULL REFERENCES split_charges(id), position INTEGER NOT NULL, transaction_id INTEGER NOT NULL UNIQUE REFERENCES transactions(id), user_id INTEGER NOT NULL, amount BIGINT NOT NULL, share_bps INTEGER NOT NULL DEFAULT 0, PRIMARY KEY (split_charge_id, position));
+		CREATE TABLE charge_releases (id SERIAL PRIMARY KEY, transaction_id INTEGER NOT NULL REFERENCES transactions(id), user_id INTEGER NOT NULL, amount BIGINT NOT NULL, release_at TIMESTAMP NOT NULL, released_at TIMESTAMP);
+		CREATE INDEX IF NOT EXISTS idx_charge_releases_transaction_id ON charge_releases(transaction_id);
+		CREATE INDEX IF NOT EXISTS idx_charge_releases_user_id ON charge_releases(user_id);
+		CREATE INDEX IF NOT EXISTS idx_charge_releases_unreleased ON charge_releases(release_at) WHERE released_at IS NULL;
+		CREATE TABLE release_changes (id SERIAL PRIMARY KEY, transaction_id INTEGER NOT NULL REFERENCES transactions(id), tranche_id INTEGER REFERENCES charge_releases(id), changed_by VARCHAR(255) NOT NULL, reason TEXT NOT NULL, old_release_at TIMESTAMP, new_release_at TIMESTAMP NOT NULL, created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+		CREATE INDEX IF NOT EXISTS idx_release_changes_transaction_id ON release_changes(transaction_id);
+		CREATE TABLE holds (id SERIAL PRIMARY KEY, user_id INTEGER NOT NULL, amount BIGINT NOT NULL, captured_amount BIGINT NOT NULL DEFAULT 0, status VARCHAR(20) NOT NULL DEFAULT 'active', idempotency_key VARCHAR(255) NOT NULL, expires_at TIMESTAMP NOT NULL, capture_transaction_id INTEGER REFERENCES transactions(id), created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP, UNIQUE (user_id, idempotency_key));
+		CREATE INDEX IF NOT EXISTS idx_holds_active_expiry ON holds(expires_at) WHERE status = 'active';
+		CREATE INDEX IF NOT EXISTS idx_transactions_reference_id ON transactions(reference_id) WHERE reference_id IS NOT NULL;
+		CREATE TABLE standing_instructions (id SERIAL PRIMARY KEY, user_id INTEGER NOT NULL, schedule VARCHAR(100) NOT NULL, mode VARCHAR(20) NOT NULL, amount BIGINT NOT NULL DEFAULT 0, min_threshold BIGINT NOT NULL DEFAULT 0, status VARCHAR(20) NOT NULL DEFAULT 'active', next_run_at TIMESTAMP, last_run_at TIMESTAMP, created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP, limit_rejections INTEGER NOT NULL DEFAULT 0);
+		CREATE INDEX IF NOT EXISTS idx_standing_instructions_due ON standing_instructions(next_run_at) WHERE status = 'active';
+		CREATE INDEX IF NOT EXISTS idx_standing_instructions_user ON standing_instructions(user_id);
+		CREATE TABLE standing_instruction_runs (id SERIAL PRIMARY KEY, instruction_id INTEGER NOT NULL REFERENCES standing_instructions(id), transaction_id INTEGER REFERENCES transactions(id), amount BIGINT NOT NULL DEFAULT 0, status VARCHAR(20) NOT NULL, reason TEXT, scheduled_at TIMESTAMP NOT NULL, created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+		CREATE INDEX IF NOT EXISTS idx_standing_instruction_runs_instruction ON standing_instruction_runs(instruction_id, created_at);
+		CREATE TABLE withdrawal_limit_tiers (name VARCHAR(50) PRIMARY KEY, per_transaction BIGINT, daily_amount BIGINT, monthly_amount BIGINT, daily_count INTEGER);
+		INSERT INTO withdrawal_limit_tiers (name, per_transaction, daily_amount, monthly_amount, daily_count) VALUES ('standard', 1000000, 2000000, 20000000, 20), ('verified', 10000000, 20000000, 200000000, 100);
+		CREATE TABLE withdrawal_limits (user_id INTEGER PRIMARY KEY, tier VARCHAR(50) NOT NULL DEFAULT 'standard' REFERENCES withdrawal_limit_tiers(name), per_transaction BIGINT, daily_amount BIGINT, monthly_amount BIGINT, daily_count INTEGER, updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+		CREATE INDEX IF NOT EXISTS idx_transactions_withdrawals ON transactions(user_id, created_at) WHERE type = 'withdraw';
+		CREATE TABLE fee_rules (id SERIAL PRIMARY KEY, operation VARCHAR(20) NOT NULL, min_amount BIGINT NOT NULL DEFAULT 0, flat BIGINT NOT NULL DEFAULT 0, bps INTEGER NOT NULL DEFAULT 0, min_fee BIGINT, max_fee BIGINT, UNIQUE (operation, min_amount));
 	`)
 
 	if err != nil {
@@ -10,10 +10,12 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	"wallet-simulator/internal/bank"
 	"wallet-simulator/internal/config"
 	"wallet-simulator/internal/handlers"
 	"wallet-simulator/internal/metrics"
 	"wallet-simulator/internal/repository"
+	"wallet-simulator/internal/tasks"
 	"wallet-simulator/internal/worker"
 
 	"github.com/go-chi/chi/v5"
@@ -26,6 +28,9 @@ func main() {
 	// ✅ Load configuration from .env
 	cfg := config.Load()
 	log.Println(cfg.String())
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	// ✅ Connect to database using config
 	db, err := ConnectWithRetry(cfg.GetDSN())
@@ -45,8 +50,20 @@ func main() {
 
 	repo := repository.NewRepository(db)
 
+	// ✅ Recover withdrawals stranded by a previous run
+	requeued, flagged, err := repo.RecoverPendingWithdrawals(
+		context.Background(),
+		time.Duration(cfg.Recovery.MinAgeSec)*time.Second,
+		time.Duration(cfg.Recovery.ReviewAfterHours)*time.Hour,
+	)
+	if err != nil {
+		log.Printf("⚠️ Withdrawal recovery failed: %v", err)
+	} else {
+		log.Printf("✅ Withdrawal recovery: %d re-queued, %d marked for manual review", requeued, flagged)
+	}
+
 	// ✅ Initialize Worker Pool
-	workerPool := worker.NewWorkerPool(cfg.WorkerPool.Size)
+	workerPool := worker.NewWorkerPool(cfg.WorkerPool.Size, time.Duration(cfg.WorkerPool.TaskTimeoutSec)*time.Second)
 	defer func() {
 		log.Println("🛑 Shutting down worker pool...")
 		if err := workerPool.Shutdown(10 * time.Second); err != nil {
@@ -54,6 +71,81 @@ func main() {
 		}
 	}()
 
+	// ✅ Process durable withdrawal jobs
+	gateway, err := NewBankGateway(cfg)
+	if err != nil {
+		log.Fatalf("Bank gateway setup failed: %v", err)
+	}
+	retryPolicy := worker.RetryPolicy{
+		MaxAttempts: cfg.Retry.MaxAttempts,
+		BaseDelay:   time.Duration(cfg.Retry.BaseDelayMs) * time.Millisecond,
+		MaxDelay:    time.Duration(cfg.Retry.MaxDelayMs) * time.Millisecond,
+		MaxElapsed:  time.Duration(cfg.Retry.MaxElapsedSec) * time.Second,
+	}
+	workerPool.Consume(
+		tasks.NewWithdrawalJobSource(repo, gateway, retryPolicy, time.Duration(cfg.WorkerPool.JobLeaseSec)*time.Second, cfg.WorkerPool.JobMaxClaims),
+		time.Duration(cfg.WorkerPool.PollIntervalMs)*time.Millisecond,
+	)
+
+	// ✅ Purge expired idempotency keys
+	retention := time.Duration(cfg.Idempotency.RetentionHours) * time.Hour
+	workerPool.Schedule("idempotency-purge", time.Duration(cfg.Idempotency.PurgeIntervalMin)*time.Minute, func(ctx context.Context) error {
+		purged, err := repo.PurgeIdempotencyKeys(ctx, retention)
+		if err == nil && purged > 0 {
+			log.Printf("🧹 Purged %d expired idempotency keys", purged)
+		}
+		return err
+	})
+
+	// ✅ Release matured charges, queue due scheduled withdrawals, expire holds,
+	// and audit balances and the ledger
+	workerPool.Schedule("charge-release", time.Duration(cfg.Accounts.ReleaseIntervalSec)*time.Second, func(ctx context.Context) error {
+		released, err := repo.ReleaseDueCharges(ctx, cfg.Accounts.ReleaseBatchSize)
+		if err == nil && released > 0 {
+			log.Printf("🔓 Released %d matured charges", released)
+		}
+		return err
+	})
+	workerPool.Schedule("withdrawal-schedule", time.Duration(cfg.ScheduledWithdrawals.PromoteIntervalSec)*time.Second, func(ctx context.Context) error {
+		promoted, err := repo.PromoteScheduledWithdrawals(ctx, cfg.ScheduledWithdrawals.PromoteBatchSize)
+		if promoted > 0 {
+			log.Printf("🗓️ Queued %d scheduled withdrawals", promoted)
+			workerPool.Wake()
+		}
+		return err
+	})
+	workerPool.Schedule("standing-instructions", time.Duration(cfg.StandingInstructions.IntervalSec)*time.Second, func(ctx context.Context) error {
+		withdrawn, err := repo.RunDueStandingInstructions(ctx, cfg.StandingInstructions.BatchSize, cfg.StandingInstructions.PauseAfter)
+		if withdrawn > 0 {
+			log.Printf("🔁 Queued %d withdrawals from standing instructions", withdrawn)
+			workerPool.Wake()
+		}
+		return err
+	})
+	workerPool.Schedule("hold-expiry", time.Duration(cfg.Holds.ExpiryIntervalSec)*time.Second, func(ctx context.Context) error {
+		expired, err := repo.ExpireHolds(ctx, cfg.Holds.ExpiryBatchSize)
+		if err == nil && expired > 0 {
+			log.Printf("⌛ Expired %d balance holds", expired)
+		}
+		return err
+	})
+	workerPool.Schedule("account-check", time.Duration(cfg.Accounts.CheckIntervalMin)*time.Minute, func(ctx context.Context) error {
+		mismatches, err := repo.CheckAccountConsistency(ctx)
+		for _, m := range mismatches {
+			log.Printf("🚨 Account %d out of sync: total=%d (expected %d), withdrawable=%d (expected %d)",
+				m.UserID, m.Total, m.ExpectedTotal, m.Withdrawable, m.ExpectedWithdrawable)
+		}
+		if err != nil {
+			return err
+		}
+
+		imbalances, err := repo.CheckLedgerInvariant(ctx)
+		for _, im := range imbalances {
+			log.Printf("🚨 Journal %d (%s) does not balance: off by %d", im.JournalID, im.Kind, im.Sum)
+		}
+		return err
+	})
+
 	// ✅ Initialize Metrics
 	m := metrics.New()
 
@@ -69,6 +161,7 @@ func main() {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Idempotency-Key")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Idempotency-Key, Idempotent-Replayed")
 			w.Header().Set("Access-Control-Max-Age", "86400")
 
 			if r.Method == "OPTIONS" {
@@ -93,7 +186,13 @@ func main() {
 	})
 
 	// ✅ Setup API routes
-	handlers.SetupRoutes(r, repo, workerPool)
+	handlers.SetupRoutes(r, &handlers.HandlerConfig{
+		Repo:       repo,
+		WorkerPool: workerPool,
+		AdminToken: cfg.App.AdminToken,
+		HoldTTL:    time.Duration(cfg.Holds.DefaultTTLMin) * time.Minute,
+		Metrics:    m,
+	})
 
 	// ✅ HTTP Server Configuration
 	server := &http.Server{
@@ -127,10 +226,43 @@ func main() {
 	log.Println(sep)
 	log.Println("📌 Available endpoints:")
 	log.Println("POST   /charge")
+	log.Println("POST   /charges/split")
 	log.Println("POST   /withdraw")
+	log.Println("POST   /withdraw/quote")
 	log.Println("GET    /balance")
+	log.Println("GET    /releases")
 	log.Println("GET    /transactions")
+	log.Println("GET    /transactions/{id}")
+	log.Println("POST   /transactions/{id}/refund")
+	log.Println("GET    /withdrawals/{idempotency_key}")
+	log.Println("POST   /withdrawals/{idempotency_key}/cancel")
+	log.Println("POST   /withdrawals/{idempotency_key}/reschedule")
+	log.Println("POST   /debit")
+	log.Println("POST   /transfers")
+	log.Println("POST   /holds")
+	log.Println("GET    /holds/{id}")
+	log.Println("POST   /holds/{id}/capture")
+	log.Println("POST   /holds/{id}/void")
+	log.Println("POST   /standing-instructions")
+	log.Println("GET    /standing-instructions")
+	log.Println("GET    /standing-instructions/{id}")
+	log.Println("PUT    /standing-instructions/{id}")
+	log.Println("DELETE /standing-instructions/{id}")
+	log.Println("GET    /standing-instructions/{id}/runs")
+	log.Println("GET    /withdrawal-limits")
+	log.Println("GET    /fees")
 	log.Println("GET    /health")
+	log.Println("GET    /admin/dead-letters")
+	log.Println("GET    /admin/dead-letters/{id}")
+	log.Println("POST   /admin/dead-letters/{id}/replay")
+	log.Println("POST   /admin/dead-letters/{id}/discard")
+	log.Println("GET    /admin/withdrawals/manual-review")
+	log.Println("POST   /admin/withdrawals/{id}/resolve")
+	log.Println("POST   /admin/transactions/{id}/release")
+	log.Println("POST   /admin/transactions/{id}/hold")
+	log.Println("GET    /admin/transactions/{id}/release-changes")
+	log.Println("PUT    /admin/users/{id}/withdrawal-limits")
+	log.Println("PUT    /admin/fees/{operation}")
 	log.Println(sep)
 	log.Printf("🌐 Server running on http://%s:%s\n", cfg.Server.Host, cfg.Server.Port)
 	log.Println(sep)
@@ -142,6 +274,20 @@ func main() {
 	log.Println("✅ Server stopped")
 }
 
+func NewBankGateway(cfg *config.Config) (bank.BankGateway, error) {
+	switch cfg.Bank.Gateway {
+	case "simulator":
+		return bank.NewSimulator(cfg.Bank.SimulatorFailurePct, cfg.Bank.SimulatorDeclinePct), nil
+	case "http":
+		if cfg.Bank.HTTPURL == "" {
+			return nil, fmt.Errorf("BANK_HTTP_URL is required for the http bank gateway")
+		}
+		return bank.NewHTTPGateway(cfg.Bank.HTTPURL, time.Duration(cfg.Bank.HTTPTimeoutSec)*time.Second), nil
+	default:
+		return nil, fmt.Errorf("unknown bank gateway %q", cfg.Bank.Gateway)
+	}
+}
+
 func ConnectWithRetry(dsn string) (*sql.DB, error) {
 	maxRetries := 30
 	baseDelay := time.Second // 1s
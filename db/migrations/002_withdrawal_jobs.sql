@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS withdrawal_jobs (id SERIAL PRIMARY KEY, transaction_id INTEGER NOT NULL UNIQUE REFERENCES transactions(id), user_id INTEGER NOT NULL, amount BIGINT NOT NULL, idempotency_key VARCHAR(255) NOT NULL, status VARCHAR(20) NOT NULL DEFAULT 'queued', attempts INTEGER NOT NULL DEFAULT 0, run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, locked_until TIMESTAMP, last_error TEXT, created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
+CREATE INDEX IF NOT EXISTS idx_withdrawal_jobs_claim ON withdrawal_jobs(status, run_at);
@@ -0,0 +1 @@
+ALTER TABLE withdrawal_jobs ADD COLUMN IF NOT EXISTS bank_reference VARCHAR(255);
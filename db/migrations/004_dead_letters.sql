@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS dead_letters (id SERIAL PRIMARY KEY, task_type VARCHAR(50) NOT NULL, task_key VARCHAR(255) NOT NULL, user_id INTEGER NOT NULL, payload JSONB NOT NULL, error_history JSONB NOT NULL DEFAULT '[]', attempts INTEGER NOT NULL DEFAULT 0, status VARCHAR(20) NOT NULL DEFAULT 'open', replay_key VARCHAR(255), created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_status ON dead_letters(status);
@@ -0,0 +1,6 @@
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reference_id INTEGER REFERENCES transactions(id);
+-- Withdrawals now hold their funds in every status, so give failed ones the compensating entry they never had.
+INSERT INTO transactions (user_id, amount, type, status, created_at, release_at, idempotency_key, reference_id)
+SELECT user_id, ABS(amount), 'reversal', 'completed', NOW(), NULL, idempotency_key || ':reversal', id
+FROM transactions WHERE type = 'withdraw' AND status IN ('failed', 'reversed')
+ON CONFLICT (idempotency_key) DO NOTHING;
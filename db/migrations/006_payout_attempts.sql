@@ -0,0 +1 @@
+ALTER TABLE withdrawal_jobs ADD COLUMN IF NOT EXISTS payout_attempts INTEGER NOT NULL DEFAULT 0;
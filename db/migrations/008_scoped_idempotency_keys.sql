@@ -0,0 +1,7 @@
+-- Idempotency keys are unique per user and operation rather than globally
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_idempotency_key_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_user_type_key ON transactions(user_id, type, idempotency_key);
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_idempotency_key_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_keys_scope ON idempotency_keys(user_id, operation, idempotency_key);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
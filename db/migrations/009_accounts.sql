@@ -0,0 +1,19 @@
+-- Materialized per-user balances, kept in step with every ledger insert
+CREATE TABLE IF NOT EXISTS accounts (
+    user_id INTEGER PRIMARY KEY,
+    total BIGINT NOT NULL DEFAULT 0,
+    withdrawable BIGINT NOT NULL DEFAULT 0,
+    version INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Charges count as withdrawable once released
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS released_at TIMESTAMP;
+UPDATE transactions SET released_at = release_at WHERE type = 'charge' AND released_at IS NULL AND release_at <= NOW();
+CREATE INDEX IF NOT EXISTS idx_transactions_unreleased ON transactions(release_at) WHERE type = 'charge' AND released_at IS NULL;
+
+INSERT INTO accounts (user_id, total, withdrawable, version)
+SELECT user_id, SUM(amount), COALESCE(SUM(amount) FILTER (WHERE type <> 'charge' OR released_at IS NOT NULL), 0), 1
+FROM transactions GROUP BY user_id
+ON CONFLICT (user_id) DO UPDATE SET total = EXCLUDED.total, withdrawable = EXCLUDED.withdrawable, version = accounts.version + 1, updated_at = NOW();
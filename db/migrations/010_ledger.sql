@@ -0,0 +1,44 @@
+-- Double-entry ledger: every balance change is a journal entry whose
+-- postings sum to zero
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(30) NOT NULL,
+    transaction_id INTEGER REFERENCES transactions(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_transaction_id ON journal_entries(transaction_id);
+
+CREATE TABLE IF NOT EXISTS postings (
+    id SERIAL PRIMARY KEY,
+    journal_id INTEGER NOT NULL REFERENCES journal_entries(id),
+    account VARCHAR(64) NOT NULL,
+    amount BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_postings_journal_id ON postings(journal_id);
+CREATE INDEX IF NOT EXISTS idx_postings_account ON postings(account);
+
+-- Book existing transactions
+WITH src AS (
+    SELECT id, created_at, 'charge' AS kind, 'bank_settlement' AS from_account, 'user:' || user_id AS to_account, amount
+    FROM transactions WHERE type = 'charge'
+    UNION ALL
+    SELECT id, created_at, 'withdrawal', 'user:' || user_id, 'clearing', -amount
+    FROM transactions WHERE type = 'withdraw'
+    UNION ALL
+    SELECT id, COALESCE(updated_at, created_at), 'payout_settled', 'clearing', 'bank_settlement', -amount
+    FROM transactions WHERE type = 'withdraw' AND status IN ('completed', 'reversed')
+    UNION ALL
+    SELECT r.id, r.created_at,
+        CASE WHEN w.status = 'reversed' THEN 'payout_reversed' ELSE 'payout_failed' END,
+        CASE WHEN w.status = 'reversed' THEN 'bank_settlement' ELSE 'clearing' END,
+        'user:' || r.user_id, r.amount
+    FROM transactions r JOIN transactions w ON w.id = r.reference_id WHERE r.type = 'reversal'
+), journals AS (
+    INSERT INTO journal_entries (kind, transaction_id, created_at)
+    SELECT kind, id, created_at FROM src WHERE NOT EXISTS (SELECT 1 FROM journal_entries)
+    RETURNING id, kind, transaction_id
+)
+INSERT INTO postings (journal_id, account, amount)
+SELECT j.id, s.from_account, -s.amount FROM journals j JOIN src s ON s.id = j.transaction_id AND s.kind = j.kind
+UNION ALL
+SELECT j.id, s.to_account, s.amount FROM journals j JOIN src s ON s.id = j.transaction_id AND s.kind = j.kind;
@@ -0,0 +1,20 @@
+-- Wallet-to-wallet transfers, booked as a transfer_out leg for the sender and
+-- a transfer_in leg for the receiver
+CREATE TABLE IF NOT EXISTS transfers (
+    id SERIAL PRIMARY KEY,
+    sender_id INTEGER NOT NULL,
+    receiver_id INTEGER NOT NULL,
+    amount BIGINT NOT NULL,
+    release_at TIMESTAMP,
+    idempotency_key VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (sender_id, idempotency_key)
+);
+
+ALTER TABLE transactions ALTER COLUMN "type" TYPE VARCHAR(20);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS transfer_id INTEGER REFERENCES transfers(id);
+CREATE INDEX IF NOT EXISTS idx_transactions_transfer_id ON transactions(transfer_id);
+
+-- Received transfers can be held like charges
+DROP INDEX IF EXISTS idx_transactions_unreleased;
+CREATE INDEX IF NOT EXISTS idx_transactions_unreleased ON transactions(release_at) WHERE type IN ('charge', 'transfer_in') AND released_at IS NULL;
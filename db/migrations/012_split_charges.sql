@@ -0,0 +1,17 @@
+-- Split charges: one incoming charge booked as a charge per recipient
+CREATE TABLE IF NOT EXISTS split_charges (
+    id SERIAL PRIMARY KEY,
+    amount BIGINT NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS split_charge_legs (
+    split_charge_id INTEGER NOT NULL REFERENCES split_charges(id),
+    position INTEGER NOT NULL,
+    transaction_id INTEGER NOT NULL UNIQUE REFERENCES transactions(id),
+    user_id INTEGER NOT NULL,
+    amount BIGINT NOT NULL,
+    share_bps INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (split_charge_id, position)
+);
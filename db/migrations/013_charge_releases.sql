@@ -0,0 +1,12 @@
+-- Staged release schedules: each tranche of a charge is released on its own
+CREATE TABLE IF NOT EXISTS charge_releases (
+    id SERIAL PRIMARY KEY,
+    transaction_id INTEGER NOT NULL REFERENCES transactions(id),
+    user_id INTEGER NOT NULL,
+    amount BIGINT NOT NULL,
+    release_at TIMESTAMP NOT NULL,
+    released_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_charge_releases_transaction_id ON charge_releases(transaction_id);
+CREATE INDEX IF NOT EXISTS idx_charge_releases_user_id ON charge_releases(user_id);
+CREATE INDEX IF NOT EXISTS idx_charge_releases_unreleased ON charge_releases(release_at) WHERE released_at IS NULL;
@@ -0,0 +1,12 @@
+-- Audit trail of operators moving the release time of held funds
+CREATE TABLE IF NOT EXISTS release_changes (
+    id SERIAL PRIMARY KEY,
+    transaction_id INTEGER NOT NULL REFERENCES transactions(id),
+    tranche_id INTEGER REFERENCES charge_releases(id),
+    changed_by VARCHAR(255) NOT NULL,
+    reason TEXT NOT NULL,
+    old_release_at TIMESTAMP,
+    new_release_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_release_changes_transaction_id ON release_changes(transaction_id);
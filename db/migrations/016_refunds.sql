@@ -0,0 +1,3 @@
+-- Refunds reference the charge they give back, like reversals reference
+-- their withdrawal
+CREATE INDEX IF NOT EXISTS idx_transactions_reference_id ON transactions(reference_id) WHERE reference_id IS NOT NULL;
@@ -0,0 +1,31 @@
+-- Standing instructions: recurring payouts on a cron schedule, either a
+-- fixed amount or a sweep of the whole withdrawable balance
+CREATE TABLE IF NOT EXISTS standing_instructions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    schedule VARCHAR(100) NOT NULL,
+    mode VARCHAR(20) NOT NULL,
+    amount BIGINT NOT NULL DEFAULT 0,
+    min_threshold BIGINT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    next_run_at TIMESTAMP,
+    last_run_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_standing_instructions_due ON standing_instructions(next_run_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_standing_instructions_user ON standing_instructions(user_id);
+
+-- One row per run: the withdrawal it generated, or why it was skipped or
+-- failed
+CREATE TABLE IF NOT EXISTS standing_instruction_runs (
+    id SERIAL PRIMARY KEY,
+    instruction_id INTEGER NOT NULL REFERENCES standing_instructions(id),
+    transaction_id INTEGER REFERENCES transactions(id),
+    amount BIGINT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL,
+    reason TEXT,
+    scheduled_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_standing_instruction_runs_instruction ON standing_instruction_runs(instruction_id, created_at);
@@ -0,0 +1,28 @@
+-- Withdrawal velocity limits: each tier sets the defaults, NULL meaning no
+-- limit
+CREATE TABLE IF NOT EXISTS withdrawal_limit_tiers (
+    name VARCHAR(50) PRIMARY KEY,
+    per_transaction BIGINT,
+    daily_amount BIGINT,
+    monthly_amount BIGINT,
+    daily_count INTEGER
+);
+INSERT INTO withdrawal_limit_tiers (name, per_transaction, daily_amount, monthly_amount, daily_count) VALUES
+    ('standard', 1000000, 2000000, 20000000, 20),
+    ('verified', 10000000, 20000000, 200000000, 100)
+ON CONFLICT (name) DO NOTHING;
+
+-- A user's tier and per-user overrides; a NULL override falls back to the
+-- tier
+CREATE TABLE IF NOT EXISTS withdrawal_limits (
+    user_id INTEGER PRIMARY KEY,
+    tier VARCHAR(50) NOT NULL DEFAULT 'standard' REFERENCES withdrawal_limit_tiers(name),
+    per_transaction BIGINT,
+    daily_amount BIGINT,
+    monthly_amount BIGINT,
+    daily_count INTEGER,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Usage is summed over a user's recent withdrawals
+CREATE INDEX IF NOT EXISTS idx_transactions_withdrawals ON transactions(user_id, created_at) WHERE type = 'withdraw';
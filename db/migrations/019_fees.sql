@@ -0,0 +1,12 @@
+-- Fee schedule: tiers per operation (withdraw or charge), each applying
+-- from min_amount up to the next tier. Without rules an operation is free
+CREATE TABLE IF NOT EXISTS fee_rules (
+    id SERIAL PRIMARY KEY,
+    operation VARCHAR(20) NOT NULL,
+    min_amount BIGINT NOT NULL DEFAULT 0,
+    flat BIGINT NOT NULL DEFAULT 0,
+    bps INTEGER NOT NULL DEFAULT 0,
+    min_fee BIGINT,
+    max_fee BIGINT,
+    UNIQUE (operation, min_amount)
+);
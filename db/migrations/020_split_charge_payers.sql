@@ -0,0 +1,5 @@
+-- Split charge idempotency keys are unique per payer rather than globally.
+-- Splits booked before this keep payer 0, the old global scope
+ALTER TABLE split_charges ADD COLUMN IF NOT EXISTS payer_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE split_charges DROP CONSTRAINT IF EXISTS split_charges_idempotency_key_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_split_charges_payer_key ON split_charges(payer_id, idempotency_key);
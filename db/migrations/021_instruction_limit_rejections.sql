@@ -0,0 +1,3 @@
+-- Consecutive runs of a standing instruction refused by withdrawal limits;
+-- the instruction is paused once this reaches the configured cap
+ALTER TABLE standing_instructions ADD COLUMN IF NOT EXISTS limit_rejections INTEGER NOT NULL DEFAULT 0;
@@ -5,23 +5,61 @@ INSERT INTO transactions (user_id, amount, type, status, idempotency_key, create
 VALUES 
 (1, 100000, 'charge', 'completed', 'seed_charge_1_001', NOW(), NOW(), NOW() + INTERVAL '3 hours'),
 (1, 50000, 'charge', 'completed', 'seed_charge_1_002', NOW(), NOW(), NOW() + INTERVAL '3 hours'),
-(1, 30000, 'withdraw', 'completed', 'seed_withdraw_1_001', NOW(), NOW(), NULL),
-(1, 10000, 'withdraw', 'pending', 'seed_withdraw_1_002', NOW(), NOW(), NOW() + INTERVAL '2 hours')
-ON CONFLICT (idempotency_key) DO NOTHING;
+(1, -30000, 'withdraw', 'completed', 'seed_withdraw_1_001', NOW(), NOW(), NULL),
+(1, -10000, 'withdraw', 'pending', 'seed_withdraw_1_002', NOW(), NOW(), NOW() + INTERVAL '2 hours')
+ON CONFLICT (user_id, type, idempotency_key) DO NOTHING;
 
 -- Transactions For User 2
 INSERT INTO transactions (user_id, amount, type, status, idempotency_key, created_at, updated_at, release_at)
 VALUES
 (2, 200000, 'charge', 'completed', 'seed_charge_2_001', NOW(), NOW(), NOW() + INTERVAL '3 hours'),
 (2, 75000, 'charge', 'completed', 'seed_charge_2_002', NOW(), NOW(), NOW() + INTERVAL '3 hours'),
-(2, 40000, 'withdraw', 'completed', 'seed_withdraw_2_001', NOW(), NOW(), NULL),
-(2, 25000, 'withdraw', 'failed', 'seed_withdraw_2_002', NOW(), NOW(), NULL)
-ON CONFLICT (idempotency_key) DO NOTHING;
+(2, -40000, 'withdraw', 'completed', 'seed_withdraw_2_001', NOW(), NOW(), NULL),
+(2, -25000, 'withdraw', 'failed', 'seed_withdraw_2_002', NOW(), NOW(), NULL)
+ON CONFLICT (user_id, type, idempotency_key) DO NOTHING;
+
+-- Compensating entry for the failed withdrawal of User 2
+INSERT INTO transactions (user_id, amount, type, status, idempotency_key, created_at, updated_at, release_at, reference_id)
+SELECT user_id, 25000, 'reversal', 'completed', 'seed_withdraw_2_002:reversal', NOW(), NOW(), NULL, id
+FROM transactions WHERE user_id = 2 AND type = 'withdraw' AND idempotency_key = 'seed_withdraw_2_002'
+ON CONFLICT (user_id, type, idempotency_key) DO NOTHING;
 
 -- Transactions For User 3
 INSERT INTO transactions (user_id, amount, type, status, idempotency_key, created_at, updated_at, release_at)
 VALUES
 (3, 150000, 'charge', 'completed', 'seed_charge_3_001', NOW(), NOW(), NOW() + INTERVAL '3 hours'),
-(3, 60000, 'withdraw', 'completed', 'seed_withdraw_3_001', NOW(), NOW(), NULL),
-(3, 20000, 'withdraw', 'pending', 'seed_withdraw_3_002', NOW(), NOW(), NOW() + INTERVAL '2 hours')
-ON CONFLICT (idempotency_key) DO NOTHING;
+(3, -60000, 'withdraw', 'completed', 'seed_withdraw_3_001', NOW(), NOW(), NULL),
+(3, -20000, 'withdraw', 'pending', 'seed_withdraw_3_002', NOW(), NOW(), NOW() + INTERVAL '2 hours')
+ON CONFLICT (user_id, type, idempotency_key) DO NOTHING;
+
+-- Rebuild the materialized balances of the seeded users
+INSERT INTO accounts (user_id, total, withdrawable, version)
+SELECT user_id, SUM(amount), COALESCE(SUM(amount) FILTER (WHERE type NOT IN ('charge', 'transfer_in') OR released_at IS NOT NULL), 0), 1
+FROM transactions GROUP BY user_id
+ON CONFLICT (user_id) DO UPDATE SET total = EXCLUDED.total, withdrawable = EXCLUDED.withdrawable, version = accounts.version + 1, updated_at = NOW();
+
+-- Book the seeded transactions in the ledger
+WITH src AS (
+    SELECT id, created_at, 'charge' AS kind, 'bank_settlement' AS from_account, 'user:' || user_id AS to_account, amount
+    FROM transactions WHERE type = 'charge'
+    UNION ALL
+    SELECT id, created_at, 'withdrawal', 'user:' || user_id, 'clearing', -amount
+    FROM transactions WHERE type = 'withdraw'
+    UNION ALL
+    SELECT id, COALESCE(updated_at, created_at), 'payout_settled', 'clearing', 'bank_settlement', -amount
+    FROM transactions WHERE type = 'withdraw' AND status IN ('completed', 'reversed')
+    UNION ALL
+    SELECT r.id, r.created_at,
+        CASE WHEN w.status = 'reversed' THEN 'payout_reversed' ELSE 'payout_failed' END,
+        CASE WHEN w.status = 'reversed' THEN 'bank_settlement' ELSE 'clearing' END,
+        'user:' || r.user_id, r.amount
+    FROM transactions r JOIN transactions w ON w.id = r.reference_id WHERE r.type = 'reversal'
+), journals AS (
+    INSERT INTO journal_entries (kind, transaction_id, created_at)
+    SELECT kind, id, created_at FROM src WHERE NOT EXISTS (SELECT 1 FROM journal_entries)
+    RETURNING id, kind, transaction_id
+)
+INSERT INTO postings (journal_id, account, amount)
+SELECT j.id, s.from_account, -s.amount FROM journals j JOIN src s ON s.id = j.transaction_id AND s.kind = j.kind
+UNION ALL
+SELECT j.id, s.to_account, s.amount FROM journals j JOIN src s ON s.id = j.transaction_id AND s.kind = j.kind;
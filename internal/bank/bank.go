@@ -0,0 +1,65 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type PayoutRequest struct {
+	IdempotencyKey string `json:"idempotency_key"`
+	UserID         int    `json:"user_id"`
+	Amount         int64  `json:"amount"`
+	Attempt        int    `json:"attempt"`
+}
+
+type PayoutResult struct {
+	ReferenceID string `json:"reference_id"`
+}
+
+// BankGateway sends payouts to the bank. Implementations must treat
+// IdempotencyKey as the payout identity so a retried request never pays twice.
+type BankGateway interface {
+	Payout(ctx context.Context, req PayoutRequest) (PayoutResult, error)
+}
+
+var (
+	ErrBankUnavailable = errors.New("bank unavailable")
+	ErrPayoutDeclined  = errors.New("payout declined")
+)
+
+// PayoutError classifies a failed payout as retryable or permanent.
+type PayoutError struct {
+	Code      string
+	Retryable bool
+	Err       error
+}
+
+func (e *PayoutError) Error() string {
+	return fmt.Sprintf("bank payout %s: %v", e.Code, e.Err)
+}
+
+func (e *PayoutError) Unwrap() error {
+	return e.Err
+}
+
+func Retryable(code string, err error) error {
+	return &PayoutError{Code: code, Retryable: true, Err: err}
+}
+
+func Permanent(code string, err error) error {
+	return &PayoutError{Code: code, Retryable: false, Err: err}
+}
+
+// IsRetryable reports whether a payout error is worth another attempt.
+// Unclassified errors are assumed transient; cancellations are not.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var payoutErr *PayoutError
+	if errors.As(err, &payoutErr) {
+		return payoutErr.Retryable
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package bank_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"wallet-simulator/internal/bank"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulatorIsDeterministic(t *testing.T) {
+	sim := bank.NewSimulator(30, 10)
+
+	for i := 0; i < 50; i++ {
+		req := bank.PayoutRequest{IdempotencyKey: "withdraw-" + string(rune('a'+i%26)), Attempt: i % 3}
+		first, firstErr := sim.Payout(context.Background(), req)
+		second, secondErr := sim.Payout(context.Background(), req)
+		assert.Equal(t, first, second)
+		assert.Equal(t, firstErr, secondErr)
+	}
+}
+
+func TestSimulatorNeverFailsAtZeroPercent(t *testing.T) {
+	sim := bank.NewSimulator(0, 0)
+
+	result, err := sim.Payout(context.Background(), bank.PayoutRequest{IdempotencyKey: "withdraw-001", Attempt: 1})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.ReferenceID)
+}
+
+func TestHTTPGatewayClassifiesResponses(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "withdraw-001", r.Header.Get("Idempotency-Key"))
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			json.NewEncoder(w).Encode(map[string]string{"reference_id": "BANK-1"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"error": "nope"})
+	}))
+	defer server.Close()
+
+	gateway := bank.NewHTTPGateway(server.URL, time.Second)
+	req := bank.PayoutRequest{IdempotencyKey: "withdraw-001", UserID: 1, Amount: 500, Attempt: 1}
+
+	result, err := gateway.Payout(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "BANK-1", result.ReferenceID)
+
+	status = http.StatusServiceUnavailable
+	_, err = gateway.Payout(context.Background(), req)
+	assert.True(t, bank.IsRetryable(err))
+
+	status = http.StatusUnprocessableEntity
+	_, err = gateway.Payout(context.Background(), req)
+	assert.False(t, bank.IsRetryable(err))
+	var payoutErr *bank.PayoutError
+	assert.True(t, errors.As(err, &payoutErr))
+	assert.Equal(t, "http_422", payoutErr.Code)
+}
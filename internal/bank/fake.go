@@ -0,0 +1,43 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+type ScriptStep struct {
+	Result PayoutResult
+	Err    error
+}
+
+// ScriptedGateway replays a fixed sequence of outcomes and records every
+// request it receives. Intended for tests.
+type ScriptedGateway struct {
+	mu    sync.Mutex
+	steps []ScriptStep
+	calls []PayoutRequest
+}
+
+func NewScriptedGateway(steps ...ScriptStep) *ScriptedGateway {
+	return &ScriptedGateway{steps: steps}
+}
+
+func (g *ScriptedGateway) Payout(ctx context.Context, req PayoutRequest) (PayoutResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.calls = append(g.calls, req)
+	if len(g.steps) == 0 {
+		return PayoutResult{}, Permanent("script_exhausted", errors.New("no scripted payout outcome left"))
+	}
+	step := g.steps[0]
+	g.steps = g.steps[1:]
+	return step.Result, step.Err
+}
+
+func (g *ScriptedGateway) Calls() []PayoutRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]PayoutRequest(nil), g.calls...)
+}
@@ -0,0 +1,74 @@
+package bank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTPGateway posts payouts as JSON to a payout provider endpoint.
+// 408, 429 and 5xx responses and transport errors are retryable; any other
+// non-2xx response is a permanent rejection.
+type HTTPGateway struct {
+	endpoint string
+	client   *http.Client
+}
+
+func NewHTTPGateway(endpoint string, timeout time.Duration) *HTTPGateway {
+	return &HTTPGateway{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+func (g *HTTPGateway) Payout(ctx context.Context, req PayoutRequest) (PayoutResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return PayoutResult{}, Permanent("encode", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return PayoutResult{}, Permanent("request", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Idempotency-Key", req.IdempotencyKey)
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return PayoutResult{}, ctx.Err()
+		}
+		return PayoutResult{}, Retryable("network", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		var result PayoutResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.ReferenceID == "" {
+			// Safe to retry: the provider deduplicates on the idempotency key.
+			return PayoutResult{}, Retryable("invalid_response", errors.New("missing reference_id in bank response"))
+		}
+		return result, nil
+	}
+
+	var errBody struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&errBody)
+	cause := fmt.Errorf("bank responded %d: %s", resp.StatusCode, errBody.Error)
+	code := fmt.Sprintf("http_%d", resp.StatusCode)
+
+	switch {
+	case resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return PayoutResult{}, Retryable(code, cause)
+	default:
+		return PayoutResult{}, Permanent(code, cause)
+	}
+}
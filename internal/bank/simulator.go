@@ -0,0 +1,42 @@
+package bank
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+)
+
+// Simulator is a deterministic stand-in for a real bank: the outcome of a
+// payout depends only on its idempotency key and attempt number, so a given
+// withdrawal behaves the same way on every run.
+type Simulator struct {
+	failurePercent int // chance of a retryable outage per attempt
+	declinePercent int // chance the payout is permanently declined
+}
+
+func NewSimulator(failurePercent, declinePercent int) *Simulator {
+	return &Simulator{failurePercent: failurePercent, declinePercent: declinePercent}
+}
+
+func (s *Simulator) Payout(ctx context.Context, req PayoutRequest) (PayoutResult, error) {
+	if err := ctx.Err(); err != nil {
+		return PayoutResult{}, err
+	}
+
+	if bucket(req.IdempotencyKey) < s.declinePercent {
+		return PayoutResult{}, Permanent("declined", ErrPayoutDeclined)
+	}
+	if bucket(fmt.Sprintf("%s#%d", req.IdempotencyKey, req.Attempt)) < s.failurePercent {
+		return PayoutResult{}, Retryable("unavailable", ErrBankUnavailable)
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(req.IdempotencyKey))
+	return PayoutResult{ReferenceID: fmt.Sprintf("SIM-%016x", h.Sum64())}, nil
+}
+
+func bucket(s string) int {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return int(h.Sum32() % 100)
+}
@@ -23,8 +23,68 @@ type Config struct {
 
 	// Worker Pool
 	WorkerPool struct {
-		Size      int
-		QueueSize int
+		Size           int
+		QueueSize      int
+		PollIntervalMs int
+		JobLeaseSec    int // must exceed the per-task timeout
+		TaskTimeoutSec int // must exceed Retry.MaxElapsedSec
+		JobMaxClaims   int // claims before a withdrawal is failed and dead-lettered
+	}
+
+	// Bank payout gateway
+	Bank struct {
+		Gateway             string // "simulator" or "http"
+		HTTPURL             string
+		HTTPTimeoutSec      int
+		SimulatorFailurePct int
+		SimulatorDeclinePct int
+	}
+
+	// Retry policy for worker tasks
+	Retry struct {
+		MaxAttempts   int
+		BaseDelayMs   int
+		MaxDelayMs    int
+		MaxElapsedSec int // keep below the per-task timeout
+	}
+
+	// Startup recovery of pending withdrawals
+	Recovery struct {
+		MinAgeSec        int
+		ReviewAfterHours int
+	}
+
+	// Idempotency key retention
+	Idempotency struct {
+		RetentionHours   int
+		PurgeIntervalMin int
+	}
+
+	// Materialized account balances
+	Accounts struct {
+		ReleaseIntervalSec int
+		ReleaseBatchSize   int
+		CheckIntervalMin   int
+	}
+
+	// Balance holds (authorizations)
+	Holds struct {
+		DefaultTTLMin     int
+		ExpiryIntervalSec int
+		ExpiryBatchSize   int
+	}
+
+	// Scheduled (future-dated) withdrawals
+	ScheduledWithdrawals struct {
+		PromoteIntervalSec int
+		PromoteBatchSize   int
+	}
+
+	// Standing instructions (recurring payouts)
+	StandingInstructions struct {
+		IntervalSec int
+		BatchSize   int
+		PauseAfter  int // consecutive limit rejections before an instruction is paused
 	}
 
 	// Server
@@ -38,8 +98,9 @@ type Config struct {
 
 	// App
 	App struct {
-		Env      string
-		LogLevel string
+		Env        string
+		LogLevel   string
+		AdminToken string
 	}
 }
 
@@ -60,6 +121,50 @@ func Load() *Config {
 	// Worker Pool
 	cfg.WorkerPool.Size = getEnvInt("WORKER_POOL_SIZE", 50)
 	cfg.WorkerPool.QueueSize = getEnvInt("WORKER_QUEUE_BUFFER", 100)
+	cfg.WorkerPool.PollIntervalMs = getEnvInt("WORKER_POLL_INTERVAL_MS", 1000)
+	cfg.WorkerPool.JobLeaseSec = getEnvInt("WITHDRAWAL_JOB_LEASE_SECONDS", 60)
+	cfg.WorkerPool.TaskTimeoutSec = getEnvInt("WORKER_TASK_TIMEOUT_SECONDS", 30)
+	cfg.WorkerPool.JobMaxClaims = getEnvInt("WITHDRAWAL_JOB_MAX_CLAIMS", 5)
+
+	// Bank
+	cfg.Bank.Gateway = getEnv("BANK_GATEWAY", "simulator")
+	cfg.Bank.HTTPURL = getEnv("BANK_HTTP_URL", "")
+	cfg.Bank.HTTPTimeoutSec = getEnvInt("BANK_HTTP_TIMEOUT_SECONDS", 10)
+	cfg.Bank.SimulatorFailurePct = getEnvInt("BANK_SIMULATOR_FAILURE_PERCENT", 30)
+	cfg.Bank.SimulatorDeclinePct = getEnvInt("BANK_SIMULATOR_DECLINE_PERCENT", 0)
+
+	// Retry
+	cfg.Retry.MaxAttempts = getEnvInt("RETRY_MAX_ATTEMPTS", 3)
+	cfg.Retry.BaseDelayMs = getEnvInt("RETRY_BASE_DELAY_MS", 1000)
+	cfg.Retry.MaxDelayMs = getEnvInt("RETRY_MAX_DELAY_MS", 10000)
+	cfg.Retry.MaxElapsedSec = getEnvInt("RETRY_MAX_ELAPSED_SECONDS", 25)
+
+	// Recovery
+	cfg.Recovery.MinAgeSec = getEnvInt("RECOVERY_MIN_AGE_SECONDS", 60)
+	cfg.Recovery.ReviewAfterHours = getEnvInt("RECOVERY_REVIEW_AFTER_HOURS", 24)
+
+	// Idempotency
+	cfg.Idempotency.RetentionHours = getEnvInt("IDEMPOTENCY_RETENTION_HOURS", 24)
+	cfg.Idempotency.PurgeIntervalMin = getEnvInt("IDEMPOTENCY_PURGE_INTERVAL_MINUTES", 60)
+
+	// Accounts
+	cfg.Accounts.ReleaseIntervalSec = getEnvInt("RELEASE_INTERVAL_SECONDS", 10)
+	cfg.Accounts.ReleaseBatchSize = getEnvInt("RELEASE_BATCH_SIZE", 500)
+	cfg.Accounts.CheckIntervalMin = getEnvInt("ACCOUNT_CHECK_INTERVAL_MINUTES", 60)
+
+	// Holds
+	cfg.Holds.DefaultTTLMin = getEnvInt("HOLD_DEFAULT_TTL_MINUTES", 10080)
+	cfg.Holds.ExpiryIntervalSec = getEnvInt("HOLD_EXPIRY_INTERVAL_SECONDS", 30)
+	cfg.Holds.ExpiryBatchSize = getEnvInt("HOLD_EXPIRY_BATCH_SIZE", 500)
+
+	// Scheduled Withdrawals
+	cfg.ScheduledWithdrawals.PromoteIntervalSec = getEnvInt("SCHEDULED_WITHDRAWAL_INTERVAL_SECONDS", 10)
+	cfg.ScheduledWithdrawals.PromoteBatchSize = getEnvInt("SCHEDULED_WITHDRAWAL_BATCH_SIZE", 500)
+
+	// Standing Instructions
+	cfg.StandingInstructions.IntervalSec = getEnvInt("STANDING_INSTRUCTION_INTERVAL_SECONDS", 60)
+	cfg.StandingInstructions.BatchSize = getEnvInt("STANDING_INSTRUCTION_BATCH_SIZE", 100)
+	cfg.StandingInstructions.PauseAfter = getEnvInt("STANDING_INSTRUCTION_PAUSE_AFTER_REJECTIONS", 3)
 
 	// Server
 	cfg.Server.Host = getEnv("SERVER_HOST", "0.0.0.0")
@@ -71,10 +176,29 @@ func Load() *Config {
 	// App
 	cfg.App.Env = getEnv("APP_ENV", "development")
 	cfg.App.LogLevel = getEnv("LOG_LEVEL", "debug")
+	cfg.App.AdminToken = getEnv("ADMIN_TOKEN", "")
 
 	return cfg
 }
 
+// Validate checks settings that only make sense together. A task has to
+// outlive its whole retry budget, and a job lease has to outlive the task,
+// or a second worker claims the job while the first is still paying it out.
+func (c *Config) Validate() error {
+	if c.WorkerPool.TaskTimeoutSec <= c.Retry.MaxElapsedSec {
+		return fmt.Errorf("WORKER_TASK_TIMEOUT_SECONDS (%d) must be longer than RETRY_MAX_ELAPSED_SECONDS (%d)",
+			c.WorkerPool.TaskTimeoutSec, c.Retry.MaxElapsedSec)
+	}
+	if c.WorkerPool.TaskTimeoutSec >= c.WorkerPool.JobLeaseSec {
+		return fmt.Errorf("WORKER_TASK_TIMEOUT_SECONDS (%d) must be shorter than WITHDRAWAL_JOB_LEASE_SECONDS (%d)",
+			c.WorkerPool.TaskTimeoutSec, c.WorkerPool.JobLeaseSec)
+	}
+	if c.WorkerPool.JobMaxClaims < 1 {
+		return fmt.Errorf("WITHDRAWAL_JOB_MAX_CLAIMS (%d) must be at least 1", c.WorkerPool.JobMaxClaims)
+	}
+	return nil
+}
+
 func (c *Config) GetDSN() string {
 	return fmt.Sprintf(
 		"postgres://%s:%s@%s:%s/%s?sslmode=%s",
@@ -95,7 +219,22 @@ func (c *Config) String() string {
 	sb.WriteString(fmt.Sprintf("Database: %s:%s/%s\n", c.DB.Host, c.DB.Port, c.DB.Name))
 	sb.WriteString(fmt.Sprintf("Connection Pool: Max=%d, Idle=%d, Lifetime=%dm\n",
 		c.DB.MaxOpenConns, c.DB.MaxIdleConns, c.DB.ConnMaxLifetimeMin))
-	sb.WriteString(fmt.Sprintf("Worker Pool: Size=%d, Queue=%d\n", c.WorkerPool.Size, c.WorkerPool.QueueSize))
+	sb.WriteString(fmt.Sprintf("Worker Pool: Size=%d, Queue=%d, Poll=%dms, Lease=%ds, Timeout=%ds, MaxClaims=%d\n",
+		c.WorkerPool.Size, c.WorkerPool.QueueSize, c.WorkerPool.PollIntervalMs, c.WorkerPool.JobLeaseSec,
+		c.WorkerPool.TaskTimeoutSec, c.WorkerPool.JobMaxClaims))
+	sb.WriteString(fmt.Sprintf("Bank Gateway: %s\n", c.Bank.Gateway))
+	sb.WriteString(fmt.Sprintf("Retry: Attempts=%d, Base=%dms, Max=%dms, Elapsed=%ds\n",
+		c.Retry.MaxAttempts, c.Retry.BaseDelayMs, c.Retry.MaxDelayMs, c.Retry.MaxElapsedSec))
+	sb.WriteString(fmt.Sprintf("Recovery: MinAge=%ds, ReviewAfter=%dh\n", c.Recovery.MinAgeSec, c.Recovery.ReviewAfterHours))
+	sb.WriteString(fmt.Sprintf("Idempotency: Retention=%dh, Purge=%dm\n", c.Idempotency.RetentionHours, c.Idempotency.PurgeIntervalMin))
+	sb.WriteString(fmt.Sprintf("Accounts: Release=%ds (batch %d), Check=%dm\n",
+		c.Accounts.ReleaseIntervalSec, c.Accounts.ReleaseBatchSize, c.Accounts.CheckIntervalMin))
+	sb.WriteString(fmt.Sprintf("Holds: TTL=%dm, Expiry=%ds (batch %d)\n",
+		c.Holds.DefaultTTLMin, c.Holds.ExpiryIntervalSec, c.Holds.ExpiryBatchSize))
+	sb.WriteString(fmt.Sprintf("Scheduled Withdrawals: Promote=%ds (batch %d)\n",
+		c.ScheduledWithdrawals.PromoteIntervalSec, c.ScheduledWithdrawals.PromoteBatchSize))
+	sb.WriteString(fmt.Sprintf("Standing Instructions: Run=%ds (batch %d), PauseAfter=%d\n",
+		c.StandingInstructions.IntervalSec, c.StandingInstructions.BatchSize, c.StandingInstructions.PauseAfter))
 	sb.WriteString(fmt.Sprintf("Server: %s:%s\n", c.Server.Host, c.Server.Port))
 	sb.WriteString(fmt.Sprintf("App Environment: %s (Log: %s)\n", c.App.Env, c.App.LogLevel))
 	sb.WriteString("==================================================\n")
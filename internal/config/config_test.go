@@ -0,0 +1,33 @@
+package config_test
+
+import (
+	"testing"
+	"wallet-simulator/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() *config.Config {
+		cfg := &config.Config{}
+		cfg.WorkerPool.TaskTimeoutSec = 30
+		cfg.WorkerPool.JobLeaseSec = 60
+		cfg.WorkerPool.JobMaxClaims = 5
+		cfg.Retry.MaxElapsedSec = 25
+		return cfg
+	}
+
+	assert.NoError(t, valid().Validate())
+
+	cfg := valid()
+	cfg.Retry.MaxElapsedSec = 30
+	assert.ErrorContains(t, cfg.Validate(), "RETRY_MAX_ELAPSED_SECONDS")
+
+	cfg = valid()
+	cfg.WorkerPool.JobLeaseSec = 30
+	assert.ErrorContains(t, cfg.Validate(), "WITHDRAWAL_JOB_LEASE_SECONDS")
+
+	cfg = valid()
+	cfg.WorkerPool.JobMaxClaims = 0
+	assert.ErrorContains(t, cfg.Validate(), "WITHDRAWAL_JOB_MAX_CLAIMS")
+}
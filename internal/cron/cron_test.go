@@ -0,0 +1,47 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+	"wallet-simulator/internal/cron"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2030, 1, 2, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		spec string
+		want time.Time
+	}{
+		{"every minute", "* * * * *", time.Date(2030, 1, 2, 10, 31, 0, 0, time.UTC)},
+		{"every Monday at 09:00", "0 9 * * 1", time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)},
+		{"Sunday written as 7", "0 0 * * 7", time.Date(2030, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{"first of the month", "0 0 1 * *", time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"every 15 minutes", "*/15 * * * *", time.Date(2030, 1, 2, 10, 45, 0, 0, time.UTC)},
+		{"weekday range and list", "0 8,17 * * 1-5", time.Date(2030, 1, 2, 17, 0, 0, 0, time.UTC)},
+		{"day of month or day of week", "0 0 15 * 5", time.Date(2030, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", time.Date(2032, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := cron.Parse(tt.spec)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, s.Next(from))
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := cron.Parse(spec)
+		assert.ErrorIs(t, err, cron.ErrInvalidSchedule, spec)
+	}
+
+	_, err := cron.Parse("0 0 30 2 *")
+	assert.ErrorIs(t, err, cron.ErrNeverRuns)
+}
@@ -0,0 +1,113 @@
+// Package fees works out the fee on an amount from a tiered fee schedule.
+// Each tier charges a flat fee plus a percentage in basis points, optionally
+// held between a minimum and a maximum.
+package fees
+
+import (
+	"errors"
+	"sort"
+)
+
+// TotalBps is 100% in basis points.
+const TotalBps = 10000
+
+var (
+	ErrInvalidRule   = errors.New("fees, min_amount and bps must not be negative, and bps may be at most 10000")
+	ErrInvalidCaps   = errors.New("min_fee cannot be above max_fee")
+	ErrDuplicateTier = errors.New("two rules start at the same min_amount")
+)
+
+// Rule is one tier of a schedule. It applies to amounts from MinAmount up to
+// the MinAmount of the next tier.
+type Rule struct {
+	MinAmount int64
+	Flat      int64
+	Bps       int
+	MinFee    *int64
+	MaxFee    *int64
+}
+
+// Validate checks that rules form a schedule Calculate can use.
+func Validate(rules []Rule) error {
+	seen := make(map[int64]bool, len(rules))
+	for _, r := range rules {
+		if r.MinAmount < 0 || r.Flat < 0 || r.Bps < 0 || r.Bps > TotalBps ||
+			(r.MinFee != nil && *r.MinFee < 0) || (r.MaxFee != nil && *r.MaxFee < 0) {
+			return ErrInvalidRule
+		}
+		if r.MinFee != nil && r.MaxFee != nil && *r.MinFee > *r.MaxFee {
+			return ErrInvalidCaps
+		}
+		if seen[r.MinAmount] {
+			return ErrDuplicateTier
+		}
+		seen[r.MinAmount] = true
+	}
+	return nil
+}
+
+// Calculate returns the fee on amount under the tier it falls in. The
+// percentage is rounded half up, and an amount below every tier is free.
+func Calculate(rules []Rule, amount int64) int64 {
+	sorted := make([]Rule, len(rules))
+	copy(sorted, rules)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinAmount < sorted[j].MinAmount })
+
+	var tier *Rule
+	for i := range sorted {
+		if sorted[i].MinAmount > amount {
+			break
+		}
+		tier = &sorted[i]
+	}
+	if tier == nil {
+		return 0
+	}
+
+	// Split amount so amount*bps cannot overflow.
+	bps := int64(tier.Bps)
+	fee := tier.Flat + amount/TotalBps*bps + (amount%TotalBps*bps+TotalBps/2)/TotalBps
+	if tier.MinFee != nil && fee < *tier.MinFee {
+		fee = *tier.MinFee
+	}
+	if tier.MaxFee != nil && fee > *tier.MaxFee {
+		fee = *tier.MaxFee
+	}
+	return fee
+}
+
+// MaxAmount returns the largest amount that still fits in budget once its
+// fee is added, or 0 when nothing does. A lower tier can charge more than a
+// higher one, so amount plus fee only grows with amount inside a tier; each
+// tier is searched on its own, highest first.
+func MaxAmount(rules []Rule, budget int64) int64 {
+	if budget <= 0 {
+		return 0
+	}
+	starts := []int64{0} // amounts below the first tier are free
+	for _, r := range rules {
+		starts = append(starts, r.MinAmount)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	fits := func(amount int64) bool { return Calculate(rules, amount) <= budget-amount }
+	for i := len(starts) - 1; i >= 0; i-- {
+		lo, hi := starts[i], budget
+		if i+1 < len(starts) && starts[i+1]-1 < hi {
+			hi = starts[i+1] - 1
+		}
+		if lo > hi || !fits(lo) {
+			continue
+		}
+		for lo < hi {
+			mid := lo + (hi-lo+1)/2
+			if fits(mid) {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+		return lo
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+package fees_test
+
+import (
+	"math"
+	"testing"
+	"wallet-simulator/internal/fees"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ptr(v int64) *int64 { return &v }
+
+func TestCalculate(t *testing.T) {
+	schedule := []fees.Rule{
+		{MinAmount: 100000, Bps: 50, MaxFee: ptr(2000)},
+		{MinAmount: 1000, Flat: 100, Bps: 150, MinFee: ptr(150)},
+	}
+
+	tests := []struct {
+		name   string
+		rules  []fees.Rule
+		amount int64
+		want   int64
+	}{
+		{"below every tier", schedule, 999, 0},
+		{"minimum fee", schedule, 1000, 150},
+		{"flat plus bps", schedule, 10000, 250},
+		{"bps rounded half up", schedule, 10034, 251},
+		{"next tier", schedule, 100000, 500},
+		{"maximum fee", schedule, 1000000, 2000},
+		{"no schedule", nil, 5000, 0},
+		{"no overflow", []fees.Rule{{Bps: 10000}}, math.MaxInt64, math.MaxInt64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, fees.Calculate(tt.rules, tt.amount))
+		})
+	}
+}
+
+func TestMaxAmount(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  []fees.Rule
+		budget int64
+		want   int64
+	}{
+		{"no schedule", nil, 1000, 1000},
+		{"flat plus bps", []fees.Rule{{Flat: 10, Bps: 200}}, 1030, 1000},
+		{"bps rounding", []fees.Rule{{Flat: 10, Bps: 200}}, 1031, 1001},
+		{"lower tier charges more", []fees.Rule{{Flat: 50}, {MinAmount: 1000, Flat: 10}}, 1005, 955},
+		{"higher tier fits", []fees.Rule{{Flat: 50}, {MinAmount: 1000, Flat: 10}}, 1010, 1000},
+		{"free below the first tier", []fees.Rule{{MinAmount: 1000, MinFee: ptr(100)}}, 1050, 999},
+		{"fee larger than budget", []fees.Rule{{Flat: 100}}, 100, 0},
+		{"empty budget", []fees.Rule{{Flat: 1}}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fees.MaxAmount(tt.rules, tt.budget)
+			assert.Equal(t, tt.want, got)
+			if got > 0 {
+				assert.LessOrEqual(t, got+fees.Calculate(tt.rules, got), tt.budget)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, fees.Validate([]fees.Rule{{Flat: 100}, {MinAmount: 1000, Bps: 100, MinFee: ptr(10), MaxFee: ptr(10)}}))
+	assert.ErrorIs(t, fees.Validate([]fees.Rule{{Bps: 10001}}), fees.ErrInvalidRule)
+	assert.ErrorIs(t, fees.Validate([]fees.Rule{{Flat: -1}}), fees.ErrInvalidRule)
+	assert.ErrorIs(t, fees.Validate([]fees.Rule{{MinFee: ptr(20), MaxFee: ptr(10)}}), fees.ErrInvalidCaps)
+	assert.ErrorIs(t, fees.Validate([]fees.Rule{{Flat: 1}, {Flat: 2}}), fees.ErrDuplicateTier)
+}
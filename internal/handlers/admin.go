@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"wallet-simulator/internal/handlers/validation"
+	"wallet-simulator/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func ListDeadLettersHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "", models.DeadLetterOpen, models.DeadLetterReplayed, models.DeadLetterDiscarded:
+		default:
+			http.Error(w, models.ErrInvalidDeadLetterStatus.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 1 {
+			page = 1
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit == 0 {
+			limit = 10
+		}
+
+		deadLetters, total, err := cfg.Repo.GetDeadLetters(status, page, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.DeadLettersResponse{
+			DeadLetters: deadLetters,
+			Total:       total,
+			Page:        page,
+			Limit:       limit,
+		})
+	}
+}
+
+func GetDeadLetterHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		dl, err := cfg.Repo.GetDeadLetter(id)
+		if err != nil {
+			writeDeadLetterError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(dl)
+	}
+}
+
+func ReplayDeadLetterHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		dl, err := cfg.Repo.ReplayDeadLetter(r.Context(), id)
+		if err != nil {
+			writeDeadLetterError(w, err)
+			return
+		}
+		cfg.WorkerPool.Wake()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(dl)
+	}
+}
+
+func DiscardDeadLetterHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		if err := cfg.Repo.DiscardDeadLetter(r.Context(), id); err != nil {
+			writeDeadLetterError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "dead letter discarded", "id": id})
+	}
+}
+
+func writeDeadLetterError(w http.ResponseWriter, err error) {
+	if errors.Is(err, models.ErrWithdrawalLimitExceeded) {
+		writeLimitError(w, err)
+		return
+	}
+	switch err {
+	case models.ErrDeadLetterNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case models.ErrDeadLetterClosed, models.ErrDuplicateRequest:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case models.ErrUnsupportedDeadLetter:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case models.ErrInsufficientBalance:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ListManualReviewHandler lists withdrawals parked in manual_review by
+// startup recovery.
+func ListManualReviewHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit == 0 {
+			limit = 100
+		}
+
+		withdrawals, err := cfg.Repo.GetManualReviewWithdrawals(limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(withdrawals)
+	}
+}
+
+// ResolveWithdrawalHandler settles a withdrawal in manual_review: requeue
+// sends it to the bank again, fail or cancel gives the funds back.
+func ResolveWithdrawalHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		var req models.ResolveWithdrawalRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		validationErrorAction := validation.ValidateResolution(req.Action)
+		if validationErrorAction != "" {
+			http.Error(w, validationErrorAction, http.StatusUnprocessableEntity)
+			return
+		}
+
+		withdrawal, err := cfg.Repo.ResolveManualReview(r.Context(), id, req.Action)
+		if err != nil {
+			switch err {
+			case models.ErrTransactionNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case models.ErrNotInManualReview:
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		if req.Action == models.ResolveRequeue {
+			cfg.WorkerPool.Wake()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(withdrawal)
+	}
+}
+
+// ReleaseEarlyHandler makes a held charge or received transfer withdrawable
+// now.
+func ReleaseEarlyHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		var req models.ReleaseChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		changeReleaseAt(cfg, w, r, id, time.Now(), req)
+	}
+}
+
+// ExtendHoldHandler keeps a held charge or received transfer locked until
+// release_at.
+func ExtendHoldHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		var req models.ReleaseChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		validationErrorReleaseAt := validation.ValidateReleaseAt(req.ReleaseAt)
+		if validationErrorReleaseAt != "" {
+			http.Error(w, validationErrorReleaseAt, http.StatusUnprocessableEntity)
+			return
+		}
+
+		changeReleaseAt(cfg, w, r, id, *req.ReleaseAt, req)
+	}
+}
+
+func changeReleaseAt(cfg *HandlerConfig, w http.ResponseWriter, r *http.Request, id int, releaseAt time.Time, req models.ReleaseChangeRequest) {
+	validationErrorChangedBy := validation.ValidateChangedBy(req.ChangedBy)
+	if validationErrorChangedBy != "" {
+		http.Error(w, validationErrorChangedBy, http.StatusUnprocessableEntity)
+		return
+	}
+
+	validationErrorReason := validation.ValidateReason(req.Reason)
+	if validationErrorReason != "" {
+		http.Error(w, validationErrorReason, http.StatusUnprocessableEntity)
+		return
+	}
+
+	changes, err := cfg.Repo.ChangeReleaseAt(r.Context(), id, releaseAt, req.ChangedBy, req.Reason)
+	if err != nil {
+		switch err {
+		case models.ErrTransactionNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case models.ErrAlreadyReleased:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case models.ErrNotHeld:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+func GetReleaseChangesHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		changes, err := cfg.Repo.GetReleaseChanges(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(changes)
+	}
+}
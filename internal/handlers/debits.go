@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"wallet-simulator/internal/handlers/validation"
+	"wallet-simulator/internal/models"
+)
+
+// DebitHandler spends wallet funds on an in-app purchase. The debit settles
+// synchronously, so unlike a withdrawal it is completed when this returns.
+func DebitHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.DebitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key, err := idempotencyKey(r, req.IdempotencyKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		req.IdempotencyKey = key
+
+		validationErrorAmount := validation.ValidateAmount(req.Amount)
+		if validationErrorAmount != "" {
+			http.Error(w, validationErrorAmount, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorUserID := validation.ValidateUserID(req.UserID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorIdempotencyKey := validation.ValidateIdempotencyKey(req.IdempotencyKey)
+		if validationErrorIdempotencyKey != "" {
+			http.Error(w, validationErrorIdempotencyKey, http.StatusUnprocessableEntity)
+			return
+		}
+
+		serveIdempotent(cfg, w, r, operationDebit, req.IdempotencyKey, req.UserID, req, func(w http.ResponseWriter) {
+			txID, err := cfg.Repo.Debit(r.Context(), req.UserID, req.Amount, req.IdempotencyKey)
+			if err != nil {
+				switch err {
+				case models.ErrDuplicateRequest:
+					recordDebit(cfg, "duplicate", 0)
+					http.Error(w, err.Error(), http.StatusConflict)
+				case models.ErrInsufficientBalance:
+					recordDebit(cfg, "insufficient_balance", 0)
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				default:
+					recordDebit(cfg, "error", 0)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+			recordDebit(cfg, models.StatusCompleted, req.Amount)
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(models.DebitResponse{
+				Message:        "debit completed",
+				IdempotencyKey: req.IdempotencyKey,
+				TransactionID:  txID,
+				Status:         models.StatusCompleted,
+			})
+		})
+	}
+}
+
+// recordDebit counts a debit by result and, for completed ones, observes
+// the amount.
+func recordDebit(cfg *HandlerConfig, result string, amount int64) {
+	if cfg.Metrics == nil {
+		return
+	}
+	cfg.Metrics.DebitCount.WithLabelValues(result).Inc()
+	if amount > 0 {
+		cfg.Metrics.DebitAmount.WithLabelValues(operationDebit).Observe(float64(amount))
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"wallet-simulator/internal/handlers/validation"
+	"wallet-simulator/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// QuoteWithdrawalHandler returns the fee a withdrawal of amount would pay
+// and the total it would take from the balance.
+func QuoteWithdrawalHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.WithdrawQuoteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		validationErrorAmount := validation.ValidateAmount(req.Amount)
+		if validationErrorAmount != "" {
+			http.Error(w, validationErrorAmount, http.StatusUnprocessableEntity)
+			return
+		}
+
+		quote, err := cfg.Repo.QuoteFee(models.FeeWithdraw, req.Amount)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(quote)
+	}
+}
+
+func GetFeeScheduleHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rules, err := cfg.Repo.GetFeeSchedule()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	}
+}
+
+// SetFeeScheduleHandler replaces the fee schedule of withdrawals or
+// charges. It applies to requests from then on.
+func SetFeeScheduleHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		operation := chi.URLParam(r, "operation")
+
+		var req models.FeeScheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		validationErrorSchedule := validation.ValidateFeeSchedule(operation, req.Rules)
+		if validationErrorSchedule != "" {
+			http.Error(w, validationErrorSchedule, http.StatusUnprocessableEntity)
+			return
+		}
+
+		rules, err := cfg.Repo.SetFeeSchedule(r.Context(), operation, req.Rules)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	}
+}
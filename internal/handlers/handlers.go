@@ -2,13 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 	"wallet-simulator/internal/handlers/validation"
+	"wallet-simulator/internal/metrics"
 	"wallet-simulator/internal/models"
 	"wallet-simulator/internal/repository"
-	"wallet-simulator/internal/tasks"
+	"wallet-simulator/internal/split"
 	"wallet-simulator/internal/worker"
 
 	"github.com/go-chi/chi/v5"
@@ -17,19 +19,54 @@ import (
 type HandlerConfig struct {
 	Repo       *repository.Repository
 	WorkerPool *worker.WorkerPool
+	AdminToken string
+	HoldTTL    time.Duration    // expiry of holds placed without expires_at
+	Metrics    *metrics.Metrics // optional; business metrics are skipped when nil
 }
 
-func SetupRoutes(r chi.Router, repo *repository.Repository, pool *worker.WorkerPool) {
-	config := &HandlerConfig{
-		Repo:       repo,
-		WorkerPool: pool,
-	}
-
+func SetupRoutes(r chi.Router, config *HandlerConfig) {
 	r.Post("/charge", ChargeHandler(config))
+	r.Post("/charges/split", SplitChargeHandler(config))
 	r.Get("/transactions", GetTransactionsHandler(config))
+	r.Get("/transactions/{id}", GetTransactionHandler(config))
+	r.Post("/transactions/{id}/refund", RefundChargeHandler(config))
 	r.Get("/balance", GetBalanceHandler(config))
+	r.Get("/releases", GetReleaseTimelineHandler(config))
 	r.Post("/withdraw", WithdrawHandler(config))
+	r.Post("/withdraw/quote", QuoteWithdrawalHandler(config))
+	r.Get("/withdrawals/{idempotency_key}", GetWithdrawalHandler(config))
+	r.Post("/withdrawals/{idempotency_key}/cancel", CancelWithdrawalHandler(config))
+	r.Post("/withdrawals/{idempotency_key}/reschedule", RescheduleWithdrawalHandler(config))
+	r.Post("/debit", DebitHandler(config))
+	r.Post("/transfers", TransferHandler(config))
+	r.Post("/holds", PlaceHoldHandler(config))
+	r.Get("/holds/{id}", GetHoldHandler(config))
+	r.Post("/holds/{id}/capture", CaptureHoldHandler(config))
+	r.Post("/holds/{id}/void", VoidHoldHandler(config))
+	r.Post("/standing-instructions", CreateStandingInstructionHandler(config))
+	r.Get("/standing-instructions", ListStandingInstructionsHandler(config))
+	r.Get("/standing-instructions/{id}", GetStandingInstructionHandler(config))
+	r.Put("/standing-instructions/{id}", UpdateStandingInstructionHandler(config))
+	r.Delete("/standing-instructions/{id}", CancelStandingInstructionHandler(config))
+	r.Get("/standing-instructions/{id}/runs", GetStandingInstructionRunsHandler(config))
+	r.Get("/withdrawal-limits", GetWithdrawalLimitsHandler(config))
+	r.Get("/fees", GetFeeScheduleHandler(config))
 	r.Get("/health", HealthHandler(config))
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(AdminAuth(config.AdminToken))
+		r.Get("/dead-letters", ListDeadLettersHandler(config))
+		r.Get("/dead-letters/{id}", GetDeadLetterHandler(config))
+		r.Post("/dead-letters/{id}/replay", ReplayDeadLetterHandler(config))
+		r.Post("/dead-letters/{id}/discard", DiscardDeadLetterHandler(config))
+		r.Get("/withdrawals/manual-review", ListManualReviewHandler(config))
+		r.Post("/withdrawals/{id}/resolve", ResolveWithdrawalHandler(config))
+		r.Post("/transactions/{id}/release", ReleaseEarlyHandler(config))
+		r.Post("/transactions/{id}/hold", ExtendHoldHandler(config))
+		r.Get("/transactions/{id}/release-changes", GetReleaseChangesHandler(config))
+		r.Put("/users/{id}/withdrawal-limits", SetWithdrawalLimitsHandler(config))
+		r.Put("/fees/{operation}", SetFeeScheduleHandler(config))
+	})
 }
 
 func ChargeHandler(cfg *HandlerConfig) http.HandlerFunc {
@@ -40,6 +77,13 @@ func ChargeHandler(cfg *HandlerConfig) http.HandlerFunc {
 			return
 		}
 
+		key, err := idempotencyKey(r, req.IdempotencyKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		req.IdempotencyKey = key
+
 		validationErrorIdempotencyKey := validation.ValidateIdempotencyKey(req.IdempotencyKey)
 		if validationErrorIdempotencyKey != "" {
 			http.Error(w, validationErrorIdempotencyKey, http.StatusUnprocessableEntity)
@@ -52,10 +96,32 @@ func ChargeHandler(cfg *HandlerConfig) http.HandlerFunc {
 			return
 		}
 
-		validationErrorReleaseAt := validation.ValidateReleaseAt(req.ReleaseAt)
-		if validationErrorReleaseAt != "" {
-			http.Error(w, validationErrorReleaseAt, http.StatusUnprocessableEntity)
-			return
+		var releases []split.Release
+		if req.ReleaseSchedule != nil {
+			if req.ReleaseAt != nil {
+				http.Error(w, models.ErrReleaseAtWithSchedule.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+
+			for _, releaseAt := range scheduleReleaseTimes(req.ReleaseSchedule) {
+				validationErrorReleaseAt := validation.ValidateReleaseAt(releaseAt)
+				if validationErrorReleaseAt != "" {
+					http.Error(w, validationErrorReleaseAt, http.StatusUnprocessableEntity)
+					return
+				}
+			}
+
+			releases, err = releasePlan(req.Amount, req.ReleaseSchedule, time.Now())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+		} else {
+			validationErrorReleaseAt := validation.ValidateReleaseAt(req.ReleaseAt)
+			if validationErrorReleaseAt != "" {
+				http.Error(w, validationErrorReleaseAt, http.StatusUnprocessableEntity)
+				return
+			}
 		}
 
 		validationErrorUserID := validation.ValidateUserID(req.UserID)
@@ -64,18 +130,98 @@ func ChargeHandler(cfg *HandlerConfig) http.HandlerFunc {
 			return
 		}
 
-		err := cfg.Repo.Charge(req.UserID, req.Amount, req.ReleaseAt, req.IdempotencyKey)
-		if err != nil {
-			if err == models.ErrDuplicateRequest {
-				http.Error(w, err.Error(), http.StatusConflict)
+		serveIdempotent(cfg, w, r, operationCharge, req.IdempotencyKey, req.UserID, req, func(w http.ResponseWriter) {
+			var err error
+			if releases != nil {
+				err = cfg.Repo.ChargeWithSchedule(r.Context(), req.UserID, req.Amount, releases, req.IdempotencyKey)
 			} else {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				err = cfg.Repo.Charge(req.UserID, req.Amount, req.ReleaseAt, req.IdempotencyKey)
+			}
+			if err != nil {
+				if err == models.ErrDuplicateRequest {
+					http.Error(w, err.Error(), http.StatusConflict)
+				} else {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
 			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "charged", "idempotency_key": req.IdempotencyKey})
+		})
+	}
+}
+
+func SplitChargeHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.SplitChargeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "charged", "idempotency_key": req.IdempotencyKey})
+		key, err := idempotencyKey(r, req.IdempotencyKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		req.IdempotencyKey = key
+
+		validationErrorIdempotencyKey := validation.ValidateIdempotencyKey(req.IdempotencyKey)
+		if validationErrorIdempotencyKey != "" {
+			http.Error(w, validationErrorIdempotencyKey, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorPayerID := validation.ValidatePayerID(req.PayerID)
+		if validationErrorPayerID != "" {
+			http.Error(w, validationErrorPayerID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorAmount := validation.ValidateAmount(req.Amount)
+		if validationErrorAmount != "" {
+			http.Error(w, validationErrorAmount, http.StatusUnprocessableEntity)
+			return
+		}
+
+		for _, rcpt := range req.Recipients {
+			validationErrorUserID := validation.ValidateUserID(rcpt.UserID)
+			if validationErrorUserID != "" {
+				http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+				return
+			}
+
+			if rcpt.ReleaseAt != nil {
+				validationErrorReleaseAt := validation.ValidateReleaseAt(rcpt.ReleaseAt)
+				if validationErrorReleaseAt != "" {
+					http.Error(w, validationErrorReleaseAt, http.StatusUnprocessableEntity)
+					return
+				}
+			}
+		}
+
+		validationErrorSplit := validation.ValidateSplit(req.Amount, req.Recipients)
+		if validationErrorSplit != "" {
+			http.Error(w, validationErrorSplit, http.StatusUnprocessableEntity)
+			return
+		}
+
+		serveIdempotent(cfg, w, r, operationSplitCharge, req.IdempotencyKey, req.PayerID, req, func(w http.ResponseWriter) {
+			splitCharge, err := cfg.Repo.SplitCharge(r.Context(), req.PayerID, req.Amount, req.Recipients, req.IdempotencyKey)
+			if err != nil {
+				switch err {
+				case models.ErrDuplicateRequest:
+					http.Error(w, err.Error(), http.StatusConflict)
+				default:
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(splitCharge)
+		})
 	}
 }
 
@@ -114,6 +260,37 @@ func GetTransactionsHandler(cfg *HandlerConfig) http.HandlerFunc {
 	}
 }
 
+func GetTransactionHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+
+		validationErrorUserID := validation.ValidateUserID(userID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, models.ErrTransactionNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		transaction, err := cfg.Repo.GetTransaction(id, userID)
+		if err != nil {
+			if err == models.ErrTransactionNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(transaction)
+	}
+}
+
 func GetBalanceHandler(cfg *HandlerConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
@@ -149,6 +326,13 @@ func WithdrawHandler(cfg *HandlerConfig) http.HandlerFunc {
 			return
 		}
 
+		key, err := idempotencyKey(r, req.IdempotencyKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		req.IdempotencyKey = key
+
 		validationErrorAmount := validation.ValidateAmount(req.Amount)
 		if validationErrorAmount != "" {
 			http.Error(w, validationErrorAmount, http.StatusUnprocessableEntity)
@@ -167,30 +351,224 @@ func WithdrawHandler(cfg *HandlerConfig) http.HandlerFunc {
 			return
 		}
 
-		err := cfg.Repo.Withdraw(r.Context(), req.UserID, req.Amount, req.IdempotencyKey)
+		if req.ExecuteAt != nil {
+			validationErrorExecuteAt := validation.ValidateExecuteAt(req.ExecuteAt)
+			if validationErrorExecuteAt != "" {
+				http.Error(w, validationErrorExecuteAt, http.StatusUnprocessableEntity)
+				return
+			}
+		}
+
+		serveIdempotent(cfg, w, r, operationWithdraw, req.IdempotencyKey, req.UserID, req, func(w http.ResponseWriter) {
+			var err error
+			if req.ExecuteAt != nil {
+				err = cfg.Repo.ScheduleWithdrawal(r.Context(), req.UserID, req.Amount, *req.ExecuteAt, req.IdempotencyKey)
+			} else {
+				err = cfg.Repo.Withdraw(r.Context(), req.UserID, req.Amount, req.IdempotencyKey)
+			}
+			if err != nil {
+				if errors.Is(err, models.ErrWithdrawalLimitExceeded) {
+					writeLimitError(w, err)
+					return
+				}
+				switch err {
+				case models.ErrDuplicateRequest:
+					http.Error(w, err.Error(), http.StatusConflict)
+				case models.ErrInsufficientBalance:
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				default:
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if req.ExecuteAt != nil {
+				json.NewEncoder(w).Encode(map[string]string{
+					"message":         "withdrawal scheduled",
+					"idempotency_key": req.IdempotencyKey,
+					"status":          models.StatusScheduled,
+				})
+				return
+			}
+
+			// The payout job was committed with the withdrawal; let an idle
+			// worker claim it right away instead of waiting for the next poll.
+			cfg.WorkerPool.Wake()
+
+			json.NewEncoder(w).Encode(map[string]string{
+				"message":         "withdrawal request submitted",
+				"idempotency_key": req.IdempotencyKey,
+				"status":          "pending",
+			})
+		})
+	}
+}
+
+func GetWithdrawalHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+
+		validationErrorUserID := validation.ValidateUserID(userID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		withdrawal, err := cfg.Repo.GetWithdrawal(chi.URLParam(r, "idempotency_key"), userID)
+		if err != nil {
+			if err == models.ErrTransactionNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(withdrawal)
+	}
+}
+
+// CancelWithdrawalHandler cancels a withdrawal that has not been sent to the
+// bank yet and gives its funds back.
+func CancelWithdrawalHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.CancelWithdrawalRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		validationErrorUserID := validation.ValidateUserID(req.UserID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		withdrawal, err := cfg.Repo.CancelWithdrawal(r.Context(), chi.URLParam(r, "idempotency_key"), req.UserID)
 		if err != nil {
 			switch err {
-			case models.ErrDuplicateRequest:
+			case models.ErrTransactionNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case models.ErrPayoutAlreadySent, models.ErrWithdrawalCancelled:
 				http.Error(w, err.Error(), http.StatusConflict)
-			case models.ErrInsufficientBalance:
-				http.Error(w, err.Error(), http.StatusBadRequest)
 			default:
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
 			return
 		}
 
-		// Submit bank withdrawal task to worker pool asynchronously
-		task := tasks.NewBankWithdrawalTask(cfg.Repo, req.UserID, req.Amount, req.IdempotencyKey)
-		if err := cfg.WorkerPool.Submit(task); err != nil {
-			log.Printf("⚠️ Failed to submit withdrawal task: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(withdrawal)
+	}
+}
+
+// RescheduleWithdrawalHandler moves the payout of a scheduled withdrawal to a
+// new execute_at.
+func RescheduleWithdrawalHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.RescheduleWithdrawalRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		validationErrorUserID := validation.ValidateUserID(req.UserID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorExecuteAt := validation.ValidateExecuteAt(req.ExecuteAt)
+		if validationErrorExecuteAt != "" {
+			http.Error(w, validationErrorExecuteAt, http.StatusUnprocessableEntity)
+			return
+		}
+
+		withdrawal, err := cfg.Repo.RescheduleWithdrawal(r.Context(), chi.URLParam(r, "idempotency_key"), req.UserID, *req.ExecuteAt)
+		if err != nil {
+			switch err {
+			case models.ErrTransactionNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case models.ErrWithdrawalNotScheduled:
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"message":         "withdrawal request submitted",
-			"idempotency_key": req.IdempotencyKey,
-			"status":          "pending",
+		json.NewEncoder(w).Encode(withdrawal)
+	}
+}
+
+func TransferHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.TransferRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key, err := idempotencyKey(r, req.IdempotencyKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		req.IdempotencyKey = key
+
+		validationErrorIdempotencyKey := validation.ValidateIdempotencyKey(req.IdempotencyKey)
+		if validationErrorIdempotencyKey != "" {
+			http.Error(w, validationErrorIdempotencyKey, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorAmount := validation.ValidateAmount(req.Amount)
+		if validationErrorAmount != "" {
+			http.Error(w, validationErrorAmount, http.StatusUnprocessableEntity)
+			return
+		}
+
+		for _, userID := range []int{req.SenderID, req.ReceiverID} {
+			validationErrorUserID := validation.ValidateUserID(userID)
+			if validationErrorUserID != "" {
+				http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+				return
+			}
+		}
+
+		validationErrorParties := validation.ValidateTransferParties(req.SenderID, req.ReceiverID)
+		if validationErrorParties != "" {
+			http.Error(w, validationErrorParties, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if req.ReleaseAt != nil {
+			validationErrorReleaseAt := validation.ValidateReleaseAt(req.ReleaseAt)
+			if validationErrorReleaseAt != "" {
+				http.Error(w, validationErrorReleaseAt, http.StatusUnprocessableEntity)
+				return
+			}
+		}
+
+		serveIdempotent(cfg, w, r, operationTransfer, req.IdempotencyKey, req.SenderID, req, func(w http.ResponseWriter) {
+			transfer, err := cfg.Repo.Transfer(r.Context(), req.SenderID, req.ReceiverID, req.Amount, req.ReleaseAt, req.IdempotencyKey)
+			if err != nil {
+				switch err {
+				case models.ErrDuplicateRequest:
+					http.Error(w, err.Error(), http.StatusConflict)
+				case models.ErrInsufficientBalance:
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				default:
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(transfer)
 		})
 	}
 }
@@ -2,7 +2,9 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -69,3 +71,941 @@ func TestGetTransactionsHandler(t *testing.T) {
 		t.Errorf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
 	}
 }
+
+func TestDeadLettersRequireAdminToken(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	req := httptest.NewRequest("GET", "/admin/dead-letters", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/admin/dead-letters?status=open", nil)
+	req.Header.Set("Authorization", "Bearer "+utils.TestAdminToken)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestGetWithdrawalHandler(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	tw := time.Now()
+	repo.Charge(1, 100000, &tw, "testxyz")
+	repo.Withdraw(context.Background(), 1, 1000, "test-3")
+
+	req := httptest.NewRequest("GET", "/withdrawals/test-3?user_id=1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	var withdrawal models.TransactionDetail
+	json.NewDecoder(w.Body).Decode(&withdrawal)
+	if withdrawal.Status != models.StatusPending || withdrawal.Amount != -1000 {
+		t.Errorf("unexpected withdrawal: %+v", withdrawal)
+	}
+
+	req = httptest.NewRequest("GET", "/withdrawals/unknown?user_id=1", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestChargeHandler_ReplaysDuplicate(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	releaseAt := time.Now().Add(2 * time.Hour)
+	charge := func(amount int64) *httptest.ResponseRecorder {
+		reqBody, _ := json.Marshal(models.ChargeRequest{UserID: 1, Amount: amount, IdempotencyKey: "test-4", ReleaseAt: &releaseAt})
+		req := httptest.NewRequest("POST", "/charge", bytes.NewReader(reqBody))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := charge(1000)
+	if first.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", first.Code, first.Body.String())
+	}
+
+	retry := charge(1000)
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Errorf("expected replayed 200, got %d; resp: %s", retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected Idempotent-Replayed header on retry")
+	}
+
+	mismatch := charge(2000)
+	if mismatch.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", mismatch.Code, mismatch.Body.String())
+	}
+}
+
+func TestChargeHandler_KeysScopedPerUser(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	releaseAt := time.Now().Add(2 * time.Hour)
+	for _, userID := range []int{1, 2} {
+		reqBody, _ := json.Marshal(models.ChargeRequest{UserID: userID, Amount: 1000, IdempotencyKey: "charge-001", ReleaseAt: &releaseAt})
+		req := httptest.NewRequest("POST", "/charge", bytes.NewReader(reqBody))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("user %d: expected fresh 200, got %d; resp: %s", userID, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestWithdrawHandler_IdempotencyKeyHeader(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	tw := time.Now()
+	repo.Charge(1, 100000, &tw, "testxyz")
+
+	reqBody, _ := json.Marshal(models.WithdrawRequest{UserID: 1, Amount: 1000})
+	req := httptest.NewRequest("POST", "/withdraw", bytes.NewReader(reqBody))
+	req.Header.Set("X-Idempotency-Key", "test-5")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Idempotency-Key") != "test-5" {
+		t.Errorf("expected idempotency key echoed, got %q", w.Header().Get("X-Idempotency-Key"))
+	}
+}
+
+func TestChargeHandler_IdempotencyKeyConflict(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	releaseAt := time.Now().Add(2 * time.Hour)
+	reqBody, _ := json.Marshal(models.ChargeRequest{UserID: 1, Amount: 1000, IdempotencyKey: "body-key", ReleaseAt: &releaseAt})
+	req := httptest.NewRequest("POST", "/charge", bytes.NewReader(reqBody))
+	req.Header.Set("X-Idempotency-Key", "header-key")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTransferHandler(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	tw := time.Now()
+	repo.Charge(1, 100000, &tw, "testxyz")
+
+	reqBody, _ := json.Marshal(models.TransferRequest{SenderID: 1, ReceiverID: 2, Amount: 1000, IdempotencyKey: "test-6"})
+	req := httptest.NewRequest("POST", "/transfers", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	var transfer models.Transfer
+	json.NewDecoder(w.Body).Decode(&transfer)
+
+	for _, userID := range []int{1, 2} {
+		transactions, _, err := repo.GetTransactions(userID, 1, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transactions[0].TransferID == nil || *transactions[0].TransferID != transfer.ID {
+			t.Errorf("user %d: expected latest transaction to be a leg of transfer %d, got %+v", userID, transfer.ID, transactions[0])
+		}
+	}
+}
+
+func TestTransferHandler_RejectsSelfTransfer(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	reqBody, _ := json.Marshal(models.TransferRequest{SenderID: 1, ReceiverID: 1, Amount: 1000, IdempotencyKey: "test-7"})
+	req := httptest.NewRequest("POST", "/transfers", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSplitChargeHandler(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	release := time.Now().Add(time.Hour)
+	reqBody, _ := json.Marshal(models.SplitChargeRequest{
+		PayerID:        20,
+		Amount:         1001,
+		IdempotencyKey: "test-8",
+		Recipients: []models.SplitRecipient{
+			{UserID: 21, Amount: 1},
+			{UserID: 22, ShareBps: 5000, ReleaseAt: &release},
+			{UserID: 23, ShareBps: 5000},
+		},
+	})
+	req := httptest.NewRequest("POST", "/charges/split", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	var splitCharge models.SplitCharge
+	json.NewDecoder(w.Body).Decode(&splitCharge)
+
+	var total int64
+	for _, leg := range splitCharge.Legs {
+		total += leg.Amount
+	}
+	if total != 1001 || len(splitCharge.Legs) != 3 {
+		t.Fatalf("expected 3 legs adding up to 1001, got %+v", splitCharge.Legs)
+	}
+
+	balance, err := repo.GetWithdrawableBalance(22)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 0 {
+		t.Errorf("expected held leg to be unavailable, got withdrawable %d", balance)
+	}
+}
+
+func TestSplitChargeHandler_RejectsIncompleteShares(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	reqBody, _ := json.Marshal(models.SplitChargeRequest{
+		PayerID:        1,
+		Amount:         1000,
+		IdempotencyKey: "test-9",
+		Recipients: []models.SplitRecipient{
+			{UserID: 1, ShareBps: 6000},
+			{UserID: 2, ShareBps: 3000},
+		},
+	})
+	req := httptest.NewRequest("POST", "/charges/split", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSplitChargeHandler_RequiresPayer(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	reqBody, _ := json.Marshal(models.SplitChargeRequest{
+		Amount:         1000,
+		IdempotencyKey: "test-9",
+		Recipients:     []models.SplitRecipient{{UserID: 1, ShareBps: 10000}},
+	})
+	req := httptest.NewRequest("POST", "/charges/split", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSplitChargeHandler_KeyIsScopedToPayer(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	// Two payers using the same key each get their own split.
+	for i, payerID := range []int{123, 124} {
+		reqBody, _ := json.Marshal(models.SplitChargeRequest{
+			PayerID:        payerID,
+			Amount:         1000,
+			IdempotencyKey: "test-32",
+			Recipients:     []models.SplitRecipient{{UserID: 125 + i, ShareBps: 10000}},
+		})
+		req := httptest.NewRequest("POST", "/charges/split", bytes.NewReader(reqBody))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("payer %d: expected 200, got %d; resp: %s", payerID, w.Code, w.Body.String())
+		}
+		if w.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("payer %d: got a replayed response", payerID)
+		}
+	}
+
+	for _, userID := range []int{125, 126} {
+		balance, err := repo.GetWithdrawableBalance(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance != 1000 {
+			t.Errorf("user %d: expected withdrawable 1000, got %d", userID, balance)
+		}
+	}
+}
+
+func TestChargeHandler_ReleaseSchedule(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	later := time.Now().Add(7 * 24 * time.Hour)
+	reqBody, _ := json.Marshal(models.ChargeRequest{
+		UserID:         31,
+		Amount:         1000,
+		IdempotencyKey: "test-10",
+		ReleaseSchedule: &models.ReleaseSchedule{Tranches: []models.ReleaseTranche{
+			{ShareBps: 3000},
+			{ShareBps: 7000, ReleaseAt: &later},
+		}},
+	})
+	req := httptest.NewRequest("POST", "/charge", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	balance, err := repo.GetWithdrawableBalance(31)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 300 {
+		t.Errorf("expected 300 withdrawable, got %d", balance)
+	}
+
+	req = httptest.NewRequest("GET", "/releases?user_id=31", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var timeline models.ReleaseTimelineResponse
+	json.NewDecoder(w.Body).Decode(&timeline)
+	if timeline.Locked != 700 || len(timeline.Releases) != 1 {
+		t.Errorf("expected one 700 release, got %+v", timeline)
+	}
+}
+
+func TestChargeHandler_RejectsReleaseAtWithSchedule(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	later := time.Now().Add(time.Hour)
+	reqBody, _ := json.Marshal(models.ChargeRequest{
+		UserID:          1,
+		Amount:          1000,
+		IdempotencyKey:  "test-11",
+		ReleaseAt:       &later,
+		ReleaseSchedule: &models.ReleaseSchedule{Installments: 3, Interval: "month"},
+	})
+	req := httptest.NewRequest("POST", "/charge", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReleaseEarlyHandler(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	later := time.Now().Add(24 * time.Hour)
+	if err := repo.Charge(41, 500, &later, "test-12"); err != nil {
+		t.Fatal(err)
+	}
+	transactions, _, err := repo.GetTransactions(41, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqBody, _ := json.Marshal(models.ReleaseChangeRequest{ChangedBy: "ops@example.com", Reason: "verified merchant"})
+	req := httptest.NewRequest("POST", fmt.Sprintf("/admin/transactions/%d/release", transactions[0].ID), bytes.NewReader(reqBody))
+	req.Header.Set("Authorization", "Bearer "+utils.TestAdminToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	balance, err := repo.GetWithdrawableBalance(41)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 500 {
+		t.Errorf("expected 500 withdrawable after early release, got %d", balance)
+	}
+
+	changes, err := repo.GetReleaseChanges(transactions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].ChangedBy != "ops@example.com" {
+		t.Errorf("expected one audited change, got %+v", changes)
+	}
+}
+
+func TestExtendHoldHandler_RequiresReason(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	later := time.Now().Add(24 * time.Hour)
+	reqBody, _ := json.Marshal(models.ReleaseChangeRequest{ReleaseAt: &later, ChangedBy: "ops@example.com"})
+	req := httptest.NewRequest("POST", "/admin/transactions/1/hold", bytes.NewReader(reqBody))
+	req.Header.Set("Authorization", "Bearer "+utils.TestAdminToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestResolveWithdrawalHandler_RejectsUnknownAction(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	reqBody, _ := json.Marshal(models.ResolveWithdrawalRequest{Action: "complete"})
+	req := httptest.NewRequest("POST", "/admin/withdrawals/1/resolve", bytes.NewReader(reqBody))
+	req.Header.Set("Authorization", "Bearer "+utils.TestAdminToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReleaseEarlyHandler_RejectsBlankChangedBy(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	reqBody, _ := json.Marshal(models.ReleaseChangeRequest{ChangedBy: "  ", Reason: "verified merchant"})
+	req := httptest.NewRequest("POST", "/admin/transactions/1/release", bytes.NewReader(reqBody))
+	req.Header.Set("Authorization", "Bearer "+utils.TestAdminToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPlaceHoldHandler(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	if err := repo.Charge(51, 1000, nil, "test-13"); err != nil {
+		t.Fatal(err)
+	}
+
+	reqBody, _ := json.Marshal(models.HoldRequest{UserID: 51, Amount: 400, IdempotencyKey: "test-14"})
+	req := httptest.NewRequest("POST", "/holds", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	var hold models.Hold
+	json.NewDecoder(w.Body).Decode(&hold)
+
+	balance, err := repo.GetWithdrawableBalance(51)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 600 {
+		t.Errorf("expected 600 withdrawable while held, got %d", balance)
+	}
+
+	reqBody, _ = json.Marshal(models.VoidHoldRequest{UserID: 51})
+	req = httptest.NewRequest("POST", fmt.Sprintf("/holds/%d/void", hold.ID), bytes.NewReader(reqBody))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	balance, err = repo.GetWithdrawableBalance(51)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 1000 {
+		t.Errorf("expected 1000 withdrawable after void, got %d", balance)
+	}
+}
+
+func TestCaptureHoldHandler_RejectsNegativeAmount(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	reqBody, _ := json.Marshal(models.CaptureHoldRequest{UserID: 1, Amount: -5})
+	req := httptest.NewRequest("POST", "/holds/1/capture", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCaptureHoldHandler_RejectsUnknownTarget(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	reqBody, _ := json.Marshal(models.CaptureHoldRequest{UserID: 1, Into: "transfer"})
+	req := httptest.NewRequest("POST", "/holds/1/capture", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCaptureHoldHandler_IntoDebit(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	if err := repo.Charge(122, 1000, nil, "test-30"); err != nil {
+		t.Fatal(err)
+	}
+	hold, err := repo.PlaceHold(context.Background(), 122, 400, time.Now().Add(time.Hour), "test-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqBody, _ := json.Marshal(models.CaptureHoldRequest{UserID: 122, Amount: 300, Into: models.CaptureDebit})
+	req := httptest.NewRequest("POST", fmt.Sprintf("/holds/%d/capture", hold.ID), bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	json.NewDecoder(w.Body).Decode(&hold)
+	detail, err := repo.GetTransaction(*hold.CaptureTransactionID, 122)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Type != "debit" || detail.Status != models.StatusCompleted {
+		t.Errorf("expected a completed debit, got %s %s", detail.Status, detail.Type)
+	}
+
+	balance, err := repo.GetWithdrawableBalance(122)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 700 {
+		t.Errorf("expected 700 withdrawable after capturing 300, got %d", balance)
+	}
+}
+
+func TestDebitHandler(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	if err := repo.Charge(61, 1000, nil, "test-15"); err != nil {
+		t.Fatal(err)
+	}
+
+	reqBody, _ := json.Marshal(models.DebitRequest{UserID: 61, Amount: 250, IdempotencyKey: "test-16"})
+	req := httptest.NewRequest("POST", "/debit", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	var resp models.DebitResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Status != models.StatusCompleted {
+		t.Errorf("expected completed debit, got %q", resp.Status)
+	}
+
+	balance, err := repo.GetWithdrawableBalance(61)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 750 {
+		t.Errorf("expected 750 withdrawable after debit, got %d", balance)
+	}
+}
+
+func TestDebitHandler_RejectsZeroAmount(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	reqBody, _ := json.Marshal(models.DebitRequest{UserID: 1, Amount: 0, IdempotencyKey: "test-17"})
+	req := httptest.NewRequest("POST", "/debit", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRefundChargeHandler(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	releaseAt := time.Now().Add(24 * time.Hour)
+	if err := repo.Charge(71, 1000, &releaseAt, "test-18"); err != nil {
+		t.Fatal(err)
+	}
+	transactions, _, err := repo.GetTransactions(71, 1, 10)
+	if err != nil || len(transactions) != 1 {
+		t.Fatalf("expected the charge, got %v (%v)", transactions, err)
+	}
+
+	reqBody, _ := json.Marshal(models.RefundRequest{UserID: 71, Amount: 400, IdempotencyKey: "test-19"})
+	req := httptest.NewRequest("POST", fmt.Sprintf("/transactions/%d/refund", transactions[0].ID), bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	var refund models.Refund
+	json.NewDecoder(w.Body).Decode(&refund)
+	if refund.FromLocked != 400 || refund.Remaining != 600 {
+		t.Errorf("expected 400 refunded from locked funds with 600 left, got %+v", refund)
+	}
+
+	total, err := repo.GetTotalBalance(71)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 600 {
+		t.Errorf("expected total 600 after refund, got %d", total)
+	}
+}
+
+func TestRefundChargeHandler_RejectsNegativeAmount(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	reqBody, _ := json.Marshal(models.RefundRequest{UserID: 1, Amount: -5, IdempotencyKey: "test-20"})
+	req := httptest.NewRequest("POST", "/transactions/1/refund", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCancelWithdrawalHandler(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	if err := repo.Charge(81, 1000, nil, "test-21"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Withdraw(context.Background(), 81, 400, "test-22"); err != nil {
+		t.Fatal(err)
+	}
+
+	reqBody, _ := json.Marshal(models.CancelWithdrawalRequest{UserID: 81})
+	req := httptest.NewRequest("POST", "/withdrawals/test-22/cancel", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	balance, err := repo.GetWithdrawableBalance(81)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 1000 {
+		t.Errorf("expected 1000 withdrawable after cancel, got %d", balance)
+	}
+
+	req = httptest.NewRequest("POST", "/withdrawals/test-22/cancel", bytes.NewReader(reqBody))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 on second cancel, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWithdrawHandler_RejectsPastExecuteAt(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	executeAt := time.Now().Add(-time.Hour)
+	reqBody, _ := json.Marshal(models.WithdrawRequest{UserID: 1, Amount: 100, IdempotencyKey: "test-23", ExecuteAt: &executeAt})
+	req := httptest.NewRequest("POST", "/withdraw", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWithdrawHandler_RejectsReservedKeyPrefix(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	// hold:3 is the key a capture of hold 3 books its withdrawal under.
+	reqBody, _ := json.Marshal(models.WithdrawRequest{UserID: 1, Amount: 100, IdempotencyKey: "hold:3"})
+	req := httptest.NewRequest("POST", "/withdraw", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestScheduledWithdrawal(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	if err := repo.Charge(91, 1000, nil, "test-24"); err != nil {
+		t.Fatal(err)
+	}
+
+	executeAt := time.Now().Add(24 * time.Hour)
+	reqBody, _ := json.Marshal(models.WithdrawRequest{UserID: 91, Amount: 300, IdempotencyKey: "test-25", ExecuteAt: &executeAt})
+	req := httptest.NewRequest("POST", "/withdraw", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	balance, err := repo.GetWithdrawableBalance(91)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 700 {
+		t.Errorf("expected 700 withdrawable while scheduled, got %d", balance)
+	}
+
+	// Not due yet, so nothing is queued
+	promoted, err := repo.PromoteScheduledWithdrawals(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promoted != 0 {
+		t.Errorf("expected nothing promoted before execute_at, got %d", promoted)
+	}
+
+	executeAt = time.Now().Add(48 * time.Hour)
+	reqBody, _ = json.Marshal(models.RescheduleWithdrawalRequest{UserID: 91, ExecuteAt: &executeAt})
+	req = httptest.NewRequest("POST", "/withdrawals/test-25/reschedule", bytes.NewReader(reqBody))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	var withdrawal models.TransactionDetail
+	json.NewDecoder(w.Body).Decode(&withdrawal)
+	if withdrawal.Status != models.StatusScheduled {
+		t.Errorf("expected the withdrawal to stay scheduled, got %q", withdrawal.Status)
+	}
+}
+
+func TestCreateStandingInstructionHandler_RejectsInvalidSchedule(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	reqBody, _ := json.Marshal(models.StandingInstructionRequest{UserID: 1, Schedule: "every monday", Mode: models.InstructionSweep})
+	req := httptest.NewRequest("POST", "/standing-instructions", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStandingInstruction(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	reqBody, _ := json.Marshal(models.StandingInstructionRequest{UserID: 101, Schedule: "0 9 * * 1", Mode: models.InstructionSweep, MinThreshold: 100})
+	req := httptest.NewRequest("POST", "/standing-instructions", bytes.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	var si models.StandingInstruction
+	json.NewDecoder(w.Body).Decode(&si)
+	if si.Status != models.InstructionActive || si.NextRunAt == nil || si.NextRunAt.Weekday() != time.Monday {
+		t.Errorf("expected an active instruction due on a Monday, got %+v", si)
+	}
+
+	req = httptest.NewRequest("DELETE", fmt.Sprintf("/standing-instructions/%d?user_id=101", si.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	json.NewDecoder(w.Body).Decode(&si)
+	if si.Status != models.InstructionCancelled || si.NextRunAt != nil {
+		t.Errorf("expected a cancelled instruction with no next run, got %+v", si)
+	}
+
+	req = httptest.NewRequest("GET", "/standing-instructions?user_id=101", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var instructions []models.StandingInstruction
+	json.NewDecoder(w.Body).Decode(&instructions)
+	if len(instructions) != 1 {
+		t.Errorf("expected the cancelled instruction to stay listed, got %d", len(instructions))
+	}
+}
+
+func TestSetWithdrawalLimitsHandler_RejectsNegativeLimit(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	daily := int64(-1)
+	reqBody, _ := json.Marshal(models.WithdrawalLimitsRequest{DailyAmount: &daily})
+	req := httptest.NewRequest("PUT", "/admin/users/1/withdrawal-limits", bytes.NewReader(reqBody))
+	req.Header.Set("Authorization", "Bearer "+utils.TestAdminToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWithdrawHandler_WithdrawalLimit(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	if err := repo.Charge(111, 1000, nil, "test-26"); err != nil {
+		t.Fatal(err)
+	}
+
+	perTransaction := int64(300)
+	reqBody, _ := json.Marshal(models.WithdrawalLimitsRequest{PerTransaction: &perTransaction})
+	req := httptest.NewRequest("PUT", "/admin/users/111/withdrawal-limits", bytes.NewReader(reqBody))
+	req.Header.Set("Authorization", "Bearer "+utils.TestAdminToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	reqBody, _ = json.Marshal(models.WithdrawRequest{UserID: 111, Amount: 400, IdempotencyKey: "test-27"})
+	req = httptest.NewRequest("POST", "/withdraw", bytes.NewReader(reqBody))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d; resp: %s", w.Code, w.Body.String())
+	}
+	var errResp models.ErrorResponse
+	json.NewDecoder(w.Body).Decode(&errResp)
+	if errResp.Code != "per_transaction_limit" || errResp.Status != http.StatusForbidden {
+		t.Errorf("expected a per_transaction_limit error body, got %+v", errResp)
+	}
+
+	req = httptest.NewRequest("GET", "/withdrawal-limits?user_id=111", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp models.WithdrawalLimitsResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Limits.Tier != models.DefaultLimitTier || resp.Limits.PerTransaction == nil || *resp.Limits.PerTransaction != 300 {
+		t.Errorf("expected the standard tier with a 300 per-transaction override, got %+v", resp.Limits)
+	}
+	if resp.Usage.DailyCount != 0 {
+		t.Errorf("expected the rejected withdrawal not to count, got %d", resp.Usage.DailyCount)
+	}
+}
+
+func TestSetFeeScheduleHandler_RejectsInvalidRule(t *testing.T) {
+	r, _ := utils.SetupRouter(nil)
+
+	reqBody, _ := json.Marshal(models.FeeScheduleRequest{Rules: []models.FeeRule{{Bps: 20000}}})
+	req := httptest.NewRequest("PUT", "/admin/fees/withdraw", bytes.NewReader(reqBody))
+	req.Header.Set("Authorization", "Bearer "+utils.TestAdminToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d; resp: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWithdrawalFee(t *testing.T) {
+	repo := utils.SetupTestDB()
+	r, _ := utils.SetupRouter(repo)
+
+	if err := repo.Charge(121, 1000, nil, "test-28"); err != nil {
+		t.Fatal(err)
+	}
+
+	reqBody, _ := json.Marshal(models.FeeScheduleRequest{Rules: []models.FeeRule{{Flat: 10, Bps: 100}}})
+	req := httptest.NewRequest("PUT", "/admin/fees/withdraw", bytes.NewReader(reqBody))
+	req.Header.Set("Authorization", "Bearer "+utils.TestAdminToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d; resp: %s", w.Code, w.Body.String())
+	}
+
+	reqBody, _ = json.Marshal(models.WithdrawQuoteRequest{Amount: 500})
+	req = httptest.NewRequest("POST", "/withdraw/quote", bytes.NewReader(reqBody))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var quote models.FeeQuote
+	json.NewDecoder(w.Body).Decode(&quote)
+	if quote.Fee != 15 || quote.Total != 515 {
+		t.Fatalf("expected a fee of 15 and a total of 515, got %+v", quote)
+	}
+
+	if err := repo.Withdraw(context.Background(), 121, 500, "test-29"); err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := repo.GetWithdrawableBalance(121)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 485 {
+		t.Errorf("expected 485 withdrawable after the withdrawal and its fee, got %d", balance)
+	}
+}
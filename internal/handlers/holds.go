@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"wallet-simulator/internal/handlers/validation"
+	"wallet-simulator/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func PlaceHoldHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.HoldRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key, err := idempotencyKey(r, req.IdempotencyKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		req.IdempotencyKey = key
+
+		validationErrorIdempotencyKey := validation.ValidateIdempotencyKey(req.IdempotencyKey)
+		if validationErrorIdempotencyKey != "" {
+			http.Error(w, validationErrorIdempotencyKey, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorAmount := validation.ValidateAmount(req.Amount)
+		if validationErrorAmount != "" {
+			http.Error(w, validationErrorAmount, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorUserID := validation.ValidateUserID(req.UserID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if req.ExpiresAt != nil {
+			validationErrorExpiresAt := validation.ValidateExpiresAt(req.ExpiresAt)
+			if validationErrorExpiresAt != "" {
+				http.Error(w, validationErrorExpiresAt, http.StatusUnprocessableEntity)
+				return
+			}
+		}
+
+		serveIdempotent(cfg, w, r, operationHold, req.IdempotencyKey, req.UserID, req, func(w http.ResponseWriter) {
+			expiresAt := time.Now().Add(cfg.HoldTTL)
+			if req.ExpiresAt != nil {
+				expiresAt = *req.ExpiresAt
+			}
+
+			hold, err := cfg.Repo.PlaceHold(r.Context(), req.UserID, req.Amount, expiresAt, req.IdempotencyKey)
+			if err != nil {
+				switch err {
+				case models.ErrDuplicateRequest:
+					http.Error(w, err.Error(), http.StatusConflict)
+				case models.ErrInsufficientBalance:
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				default:
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(hold)
+		})
+	}
+}
+
+func GetHoldHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+
+		validationErrorUserID := validation.ValidateUserID(userID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		hold, err := cfg.Repo.GetHold(id, userID)
+		if err != nil {
+			writeHoldError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hold)
+	}
+}
+
+// CaptureHoldHandler turns a hold into a withdrawal, whose payout runs like
+// any other withdrawal, or into a debit.
+func CaptureHoldHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		var req models.CaptureHoldRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Into == "" {
+			req.Into = models.CaptureWithdraw
+		}
+
+		validationErrorUserID := validation.ValidateUserID(req.UserID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if req.Amount < 0 {
+			http.Error(w, models.ErrAmountMustBePositive.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorInto := validation.ValidateCaptureTarget(req.Into)
+		if validationErrorInto != "" {
+			http.Error(w, validationErrorInto, http.StatusUnprocessableEntity)
+			return
+		}
+
+		hold, err := cfg.Repo.CaptureHold(r.Context(), id, req.UserID, req.Amount, req.Into)
+		if err != nil {
+			writeHoldError(w, err)
+			return
+		}
+		if req.Into == models.CaptureWithdraw {
+			cfg.WorkerPool.Wake()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hold)
+	}
+}
+
+func VoidHoldHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		var req models.VoidHoldRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		validationErrorUserID := validation.ValidateUserID(req.UserID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		hold, err := cfg.Repo.VoidHold(r.Context(), id, req.UserID)
+		if err != nil {
+			writeHoldError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hold)
+	}
+}
+
+func writeHoldError(w http.ResponseWriter, err error) {
+	if errors.Is(err, models.ErrWithdrawalLimitExceeded) {
+		writeLimitError(w, err)
+		return
+	}
+	switch err {
+	case models.ErrHoldNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case models.ErrHoldNotActive, models.ErrDuplicateRequest:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case models.ErrCaptureExceedsHold, models.ErrCaptureBelowFee:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case models.ErrInsufficientBalance:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
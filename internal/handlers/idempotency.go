@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"wallet-simulator/internal/models"
+)
+
+// IdempotencyKeyHeader carries the idempotency key on mutating requests and
+// is echoed back on their responses.
+const IdempotencyKeyHeader = "X-Idempotency-Key"
+
+// Operations stored with each idempotency key
+const (
+	operationCharge      = "charge"
+	operationWithdraw    = "withdraw"
+	operationDebit       = "debit"
+	operationTransfer    = "transfer"
+	operationSplitCharge = "split_charge"
+	operationHold        = "hold"
+	operationRefund      = "refund"
+)
+
+// idempotencyKey returns the key from the X-Idempotency-Key header, falling
+// back to the idempotency_key body field. Sending both with different values
+// is rejected.
+func idempotencyKey(r *http.Request, bodyKey string) (string, error) {
+	headerKey := r.Header.Get(IdempotencyKeyHeader)
+	if headerKey == "" {
+		return bodyKey, nil
+	}
+	if bodyKey != "" && bodyKey != headerKey {
+		return "", models.ErrIdempotencyKeyConflict
+	}
+	return headerKey, nil
+}
+
+// fingerprint hashes the decoded request, so retries that only differ in
+// whitespace or field order still match.
+func fingerprint(req any) string {
+	payload, _ := json.Marshal(req)
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// captureWriter buffers a response so it can be stored before it is sent.
+type captureWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newCaptureWriter() *captureWriter {
+	return &captureWriter{header: http.Header{}, statusCode: http.StatusOK}
+}
+
+func (c *captureWriter) Header() http.Header         { return c.header }
+func (c *captureWriter) Write(b []byte) (int, error) { return c.body.Write(b) }
+func (c *captureWriter) WriteHeader(code int)        { c.statusCode = code }
+
+// serveIdempotent runs handle at most once per idempotency key. A retry with
+// the same payload gets the stored response replayed; reusing the key for a
+// different payload is rejected with 422, and a retry that races the original
+// request gets 409. Server errors are not stored, so the client may retry.
+func serveIdempotent(cfg *HandlerConfig, w http.ResponseWriter, r *http.Request, operation, key string, userID int, req any, handle func(w http.ResponseWriter)) {
+	w.Header().Set(IdempotencyKeyHeader, key)
+
+	rec, err := cfg.Repo.BeginIdempotentRequest(r.Context(), key, operation, userID, fingerprint(req))
+	if err != nil {
+		switch err {
+		case models.ErrIdempotencyKeyReused:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case models.ErrRequestInProgress:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if rec != nil {
+		w.Header().Set("Content-Type", rec.ContentType)
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(rec.ResponseCode)
+		w.Write([]byte(rec.ResponseBody))
+		return
+	}
+
+	cw := newCaptureWriter()
+	handle(cw)
+
+	// The outcome is already decided, so record it even if the client has
+	// gone away in the meantime.
+	ctx := context.WithoutCancel(r.Context())
+	if cw.statusCode >= http.StatusInternalServerError {
+		if err := cfg.Repo.ReleaseIdempotentRequest(ctx, key, operation, userID); err != nil {
+			log.Printf("⚠️ Failed to release idempotency key %s: %v", key, err)
+		}
+	} else if err := cfg.Repo.CompleteIdempotentRequest(ctx, key, operation, userID, cw.statusCode, cw.body.String(), cw.header.Get("Content-Type")); err != nil {
+		log.Printf("⚠️ Failed to store response for idempotency key %s: %v", key, err)
+	}
+
+	for k, v := range cw.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(cw.statusCode)
+	w.Write(cw.body.Bytes())
+}
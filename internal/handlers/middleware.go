@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strings"
 	"time"
 	"wallet-simulator/internal/metrics"
+	"wallet-simulator/internal/models"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -44,3 +47,18 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// AdminAuth protects operator endpoints with a static bearer token.
+// When no token is configured the admin API is disabled.
+func AdminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				http.Error(w, models.ErrUnauthorized.Error(), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"wallet-simulator/internal/handlers/validation"
+	"wallet-simulator/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// RefundChargeHandler gives back all or part of a charge.
+func RefundChargeHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		var req models.RefundRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key, err := idempotencyKey(r, req.IdempotencyKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		req.IdempotencyKey = key
+
+		validationErrorIdempotencyKey := validation.ValidateIdempotencyKey(req.IdempotencyKey)
+		if validationErrorIdempotencyKey != "" {
+			http.Error(w, validationErrorIdempotencyKey, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorUserID := validation.ValidateUserID(req.UserID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if req.Amount < 0 {
+			http.Error(w, models.ErrAmountMustBePositive.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		// The charge comes from the path, so it has to be part of what a
+		// retry is compared against.
+		payload := struct {
+			ChargeID int `json:"charge_id"`
+			models.RefundRequest
+		}{id, req}
+
+		serveIdempotent(cfg, w, r, operationRefund, req.IdempotencyKey, req.UserID, payload, func(w http.ResponseWriter) {
+			refund, err := cfg.Repo.RefundCharge(r.Context(), id, req.UserID, req.Amount, req.IdempotencyKey, req.AllowOverdraft)
+			if err != nil {
+				switch err {
+				case models.ErrTransactionNotFound:
+					http.Error(w, err.Error(), http.StatusNotFound)
+				case models.ErrDuplicateRequest:
+					http.Error(w, err.Error(), http.StatusConflict)
+				case models.ErrNotRefundable, models.ErrRefundExceedsCharge:
+					http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				case models.ErrRefundOverdraft:
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				default:
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(refund)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"wallet-simulator/internal/handlers/validation"
+	"wallet-simulator/internal/models"
+	"wallet-simulator/internal/split"
+)
+
+// releasePlan turns a charge's release schedule into its dated releases.
+func releasePlan(amount int64, schedule *models.ReleaseSchedule, now time.Time) ([]split.Release, error) {
+	if schedule.Installments > 0 {
+		if len(schedule.Tranches) > 0 {
+			return nil, models.ErrInvalidReleaseSchedule
+		}
+		start := now
+		if schedule.StartAt != nil {
+			start = *schedule.StartAt
+		}
+		return split.Installments(amount, schedule.Installments, schedule.Interval, start)
+	}
+
+	tranches := make([]split.Tranche, len(schedule.Tranches))
+	for i, t := range schedule.Tranches {
+		tranches[i] = split.Tranche{Bps: t.ShareBps}
+		if t.ReleaseAt != nil {
+			tranches[i].At = *t.ReleaseAt
+		}
+	}
+	return split.Schedule(amount, tranches, now)
+}
+
+// scheduleReleaseTimes returns the release times a schedule gives
+// explicitly.
+func scheduleReleaseTimes(schedule *models.ReleaseSchedule) []*time.Time {
+	var times []*time.Time
+	if schedule.StartAt != nil {
+		times = append(times, schedule.StartAt)
+	}
+	for _, t := range schedule.Tranches {
+		if t.ReleaseAt != nil {
+			times = append(times, t.ReleaseAt)
+		}
+	}
+	return times
+}
+
+func GetReleaseTimelineHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+
+		validationErrorUserID := validation.ValidateUserID(userID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		releases, err := cfg.Repo.GetReleaseTimeline(userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var locked int64
+		for _, rel := range releases {
+			locked += rel.Amount
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.ReleaseTimelineResponse{UserID: userID, Locked: locked, Releases: releases})
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"wallet-simulator/internal/handlers/validation"
+	"wallet-simulator/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// CreateStandingInstructionHandler sets up a recurring payout. The scheduler
+// turns each run into an ordinary withdrawal.
+func CreateStandingInstructionHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.StandingInstructionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		validationErrorUserID := validation.ValidateUserID(req.UserID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorSchedule := validation.ValidateSchedule(req.Schedule)
+		if validationErrorSchedule != "" {
+			http.Error(w, validationErrorSchedule, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorInstruction := validation.ValidateInstruction(req.Mode, req.Amount, req.MinThreshold)
+		if validationErrorInstruction != "" {
+			http.Error(w, validationErrorInstruction, http.StatusUnprocessableEntity)
+			return
+		}
+
+		si, err := cfg.Repo.CreateStandingInstruction(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(si)
+	}
+}
+
+func ListStandingInstructionsHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+
+		validationErrorUserID := validation.ValidateUserID(userID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		instructions, err := cfg.Repo.ListStandingInstructions(userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(instructions)
+	}
+}
+
+func GetStandingInstructionHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+
+		validationErrorUserID := validation.ValidateUserID(userID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		si, err := cfg.Repo.GetStandingInstruction(id, userID)
+		if err != nil {
+			writeInstructionError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(si)
+	}
+}
+
+// UpdateStandingInstructionHandler replaces an instruction's schedule and
+// payout, and pauses or resumes it.
+func UpdateStandingInstructionHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		var req models.StandingInstructionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Status == "" {
+			req.Status = models.InstructionActive
+		}
+
+		validationErrorUserID := validation.ValidateUserID(req.UserID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorSchedule := validation.ValidateSchedule(req.Schedule)
+		if validationErrorSchedule != "" {
+			http.Error(w, validationErrorSchedule, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorInstruction := validation.ValidateInstruction(req.Mode, req.Amount, req.MinThreshold)
+		if validationErrorInstruction != "" {
+			http.Error(w, validationErrorInstruction, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorStatus := validation.ValidateInstructionStatus(req.Status)
+		if validationErrorStatus != "" {
+			http.Error(w, validationErrorStatus, http.StatusUnprocessableEntity)
+			return
+		}
+
+		si, err := cfg.Repo.UpdateStandingInstruction(r.Context(), id, req)
+		if err != nil {
+			writeInstructionError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(si)
+	}
+}
+
+// CancelStandingInstructionHandler stops an instruction; withdrawals it has
+// already made are not affected.
+func CancelStandingInstructionHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+
+		validationErrorUserID := validation.ValidateUserID(userID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		si, err := cfg.Repo.CancelStandingInstruction(r.Context(), id, userID)
+		if err != nil {
+			writeInstructionError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(si)
+	}
+}
+
+// GetStandingInstructionRunsHandler returns the payouts an instruction has
+// generated, and the runs it skipped.
+func GetStandingInstructionRunsHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+
+		validationErrorUserID := validation.ValidateUserID(userID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		runs, err := cfg.Repo.GetStandingInstructionRuns(id, userID)
+		if err != nil {
+			writeInstructionError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(runs)
+	}
+}
+
+func writeInstructionError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrInstructionNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case models.ErrInstructionCancelled:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
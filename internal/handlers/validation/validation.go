@@ -1,17 +1,41 @@
 package validation
 
 import (
+	"strings"
 	"time"
+	"wallet-simulator/internal/cron"
+	"wallet-simulator/internal/fees"
 	"wallet-simulator/internal/models"
+	"wallet-simulator/internal/split"
 )
 
 func ValidateReleaseAt(releaseAt *time.Time) string {
+	if releaseAt == nil {
+		return models.ErrReleaseAtCannotBeEmpty.Error()
+	}
 	if releaseAt.Before(time.Now()) {
 		return models.ErrReleaseAtMustBeFuture.Error()
 	}
 	return ""
 }
 
+func ValidateExpiresAt(expiresAt *time.Time) string {
+	if !expiresAt.After(time.Now()) {
+		return models.ErrExpiresAtMustBeFuture.Error()
+	}
+	return ""
+}
+
+func ValidateExecuteAt(executeAt *time.Time) string {
+	if executeAt == nil {
+		return models.ErrMissingExecuteAt.Error()
+	}
+	if !executeAt.After(time.Now()) {
+		return models.ErrExecuteAtMustBeFuture.Error()
+	}
+	return ""
+}
+
 func ValidateAmount(amount int64) string {
 	if amount <= 0 {
 		return models.ErrAmountCannotBeZero.Error()
@@ -19,10 +43,24 @@ func ValidateAmount(amount int64) string {
 	return ""
 }
 
+// ValidatePayerID checks a split charge names its payer, whose ID its
+// idempotency key is scoped to.
+func ValidatePayerID(payerID int) string {
+	if payerID <= 0 {
+		return models.ErrMissingPayerID.Error()
+	}
+	return ""
+}
+
 func ValidateIdempotencyKey(idempotency_key string) string {
 	if idempotency_key == "" {
 		return models.ErrMissingIdempotencyKey.Error()
 	}
+	for _, prefix := range models.ReservedKeyPrefixes {
+		if strings.HasPrefix(idempotency_key, prefix) {
+			return models.ErrReservedKeyPrefix.Error()
+		}
+	}
 	return ""
 }
 
@@ -32,3 +70,118 @@ func ValidateUserID(user_id int) string {
 	}
 	return ""
 }
+
+// ValidateChangedBy only checks that the operator named themselves: admin
+// endpoints share one token, so the name cannot be verified.
+func ValidateChangedBy(changedBy string) string {
+	if strings.TrimSpace(changedBy) == "" {
+		return models.ErrMissingChangedBy.Error()
+	}
+	return ""
+}
+
+func ValidateReason(reason string) string {
+	if reason == "" {
+		return models.ErrMissingReason.Error()
+	}
+	return ""
+}
+
+func ValidateTransferParties(senderID, receiverID int) string {
+	if senderID == receiverID {
+		return models.ErrSelfTransfer.Error()
+	}
+	return ""
+}
+
+// ValidateSplit checks that the recipients' amounts and shares add up to
+// amount.
+func ValidateSplit(amount int64, recipients []models.SplitRecipient) string {
+	shares := make([]split.Share, len(recipients))
+	for i, rcpt := range recipients {
+		shares[i] = split.Share{Amount: rcpt.Amount, Bps: rcpt.ShareBps}
+	}
+	if _, err := split.Allocate(amount, shares); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func ValidateSchedule(schedule string) string {
+	if _, err := cron.Parse(schedule); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// ValidateInstruction checks a standing instruction's mode against its
+// amount: a fixed payout needs one, a sweep takes whatever is withdrawable.
+func ValidateInstruction(mode string, amount, minThreshold int64) string {
+	switch mode {
+	case models.InstructionFixed:
+		if amount <= 0 {
+			return models.ErrAmountCannotBeZero.Error()
+		}
+	case models.InstructionSweep:
+		if amount != 0 {
+			return models.ErrSweepWithAmount.Error()
+		}
+	default:
+		return models.ErrInvalidInstructionMode.Error()
+	}
+	if minThreshold < 0 {
+		return models.ErrNegativeThreshold.Error()
+	}
+	return ""
+}
+
+// ValidateWithdrawalLimits checks a user's limit overrides; the tier is
+// checked against the stored tiers.
+func ValidateWithdrawalLimits(req models.WithdrawalLimitsRequest) string {
+	for _, limit := range []*int64{req.PerTransaction, req.DailyAmount, req.MonthlyAmount} {
+		if limit != nil && *limit < 0 {
+			return models.ErrNegativeLimit.Error()
+		}
+	}
+	if req.DailyCount != nil && *req.DailyCount < 0 {
+		return models.ErrNegativeLimit.Error()
+	}
+	return ""
+}
+
+// ValidateFeeSchedule checks the rules of an operation's fee schedule.
+func ValidateFeeSchedule(operation string, rules []models.FeeRule) string {
+	if operation != models.FeeWithdraw && operation != models.FeeCharge {
+		return models.ErrUnknownFeeOperation.Error()
+	}
+	schedule := make([]fees.Rule, len(rules))
+	for i, rule := range rules {
+		schedule[i] = fees.Rule{MinAmount: rule.MinAmount, Flat: rule.Flat, Bps: rule.Bps, MinFee: rule.MinFee, MaxFee: rule.MaxFee}
+	}
+	if err := fees.Validate(schedule); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func ValidateCaptureTarget(into string) string {
+	if into != models.CaptureWithdraw && into != models.CaptureDebit {
+		return models.ErrInvalidCaptureTarget.Error()
+	}
+	return ""
+}
+
+func ValidateResolution(action string) string {
+	switch action {
+	case models.ResolveRequeue, models.ResolveFail, models.ResolveCancel:
+		return ""
+	}
+	return models.ErrInvalidResolution.Error()
+}
+
+func ValidateInstructionStatus(status string) string {
+	if status != models.InstructionActive && status != models.InstructionPaused {
+		return models.ErrInvalidInstructionState.Error()
+	}
+	return ""
+}
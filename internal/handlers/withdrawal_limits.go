@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"wallet-simulator/internal/handlers/validation"
+	"wallet-simulator/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GetWithdrawalLimitsHandler returns the user's withdrawal limits and how
+// much of them is used up.
+func GetWithdrawalLimitsHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+
+		validationErrorUserID := validation.ValidateUserID(userID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		limits, err := cfg.Repo.GetWithdrawalLimits(userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(limits)
+	}
+}
+
+// SetWithdrawalLimitsHandler moves a user to a limit tier and sets their
+// overrides.
+func SetWithdrawalLimitsHandler(cfg *HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		var req models.WithdrawalLimitsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Tier == "" {
+			req.Tier = models.DefaultLimitTier
+		}
+
+		validationErrorUserID := validation.ValidateUserID(userID)
+		if validationErrorUserID != "" {
+			http.Error(w, validationErrorUserID, http.StatusUnprocessableEntity)
+			return
+		}
+
+		validationErrorLimits := validation.ValidateWithdrawalLimits(req)
+		if validationErrorLimits != "" {
+			http.Error(w, validationErrorLimits, http.StatusUnprocessableEntity)
+			return
+		}
+
+		limits, err := cfg.Repo.SetWithdrawalLimits(r.Context(), userID, req)
+		if err != nil {
+			switch err {
+			case models.ErrUnknownLimitTier:
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(limits)
+	}
+}
+
+// writeLimitError answers a withdrawal refused by a limit with a 403 and a
+// JSON body carrying the limit's code.
+func writeLimitError(w http.ResponseWriter, err error) {
+	code := "withdrawal_limit"
+	for limit, limitCode := range models.WithdrawalLimitCodes {
+		if errors.Is(err, limit) {
+			code = limitCode
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(models.ErrorResponse{Error: err.Error(), Code: code, Status: http.StatusForbidden})
+}
@@ -0,0 +1,179 @@
+// Package ledger is the double-entry book behind every balance change. Each
+// business event is a journal entry whose postings move money between
+// accounts and always sum to zero.
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// System accounts. Customer wallets are UserAccount(id).
+const (
+	// BankSettlement is the operator's bank account: charges are funded from
+	// it and settled payouts are paid into it.
+	BankSettlement = "bank_settlement"
+	// Clearing holds withdrawals that were accepted but not yet settled by
+	// the bank.
+	Clearing = "clearing"
+	// Fees collects fee revenue.
+	Fees = "fees"
+	// Purchases collects in-app purchases paid from wallets.
+	Purchases = "purchases"
+)
+
+const userAccountPrefix = "user:"
+
+// Journal kinds
+const (
+	KindCharge          = "charge"
+	KindWithdrawal      = "withdrawal"
+	KindPayoutSettled   = "payout_settled"
+	KindPayoutFailed    = "payout_failed"
+	KindPayoutReversed  = "payout_reversed"
+	KindPayoutCancelled = "payout_cancelled"
+	KindTransfer        = "transfer"
+	KindSplitCharge     = "split_charge"
+	KindDebit           = "debit"
+	KindRefund          = "refund"
+	KindFee             = "fee"
+	KindFeeRefund       = "fee_refund"
+)
+
+var (
+	ErrUnbalancedJournal = errors.New("journal postings do not sum to zero")
+	ErrEmptyJournal      = errors.New("journal needs at least two postings")
+	ErrZeroPosting       = errors.New("posting amount cannot be zero")
+)
+
+// UserAccount is the ledger account of a customer wallet.
+func UserAccount(userID int) string {
+	return userAccountPrefix + strconv.Itoa(userID)
+}
+
+// UserID returns the customer behind a UserAccount, if account is one.
+func UserID(account string) (int, bool) {
+	if !strings.HasPrefix(account, userAccountPrefix) {
+		return 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(account, userAccountPrefix))
+	return id, err == nil
+}
+
+// Posting moves Amount into Account; negative amounts move money out.
+type Posting struct {
+	Account string `json:"account"`
+	Amount  int64  `json:"amount"`
+}
+
+// Journal is one balanced business event. TransactionID links it to the
+// user-facing transaction row it books.
+type Journal struct {
+	Kind          string    `json:"kind"`
+	TransactionID int       `json:"transaction_id"`
+	Postings      []Posting `json:"postings"`
+}
+
+// Validate enforces the double-entry invariant.
+func (j Journal) Validate() error {
+	if len(j.Postings) < 2 {
+		return ErrEmptyJournal
+	}
+	var sum int64
+	for _, p := range j.Postings {
+		if p.Amount == 0 {
+			return fmt.Errorf("%w: %s", ErrZeroPosting, p.Account)
+		}
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("%w: %s journal is off by %d", ErrUnbalancedJournal, j.Kind, sum)
+	}
+	return nil
+}
+
+// transfer builds a two-posting journal moving amount from one account to
+// another.
+func transfer(kind string, transactionID int, from, to string, amount int64) Journal {
+	return Journal{
+		Kind:          kind,
+		TransactionID: transactionID,
+		Postings: []Posting{
+			{Account: from, Amount: -amount},
+			{Account: to, Amount: amount},
+		},
+	}
+}
+
+// Charge credits a user with funds received through the bank.
+func Charge(transactionID, userID int, amount int64) Journal {
+	return transfer(KindCharge, transactionID, BankSettlement, UserAccount(userID), amount)
+}
+
+// Withdrawal moves an accepted withdrawal out of the user's wallet into
+// clearing until the bank settles it.
+func Withdrawal(transactionID, userID int, amount int64) Journal {
+	return transfer(KindWithdrawal, transactionID, UserAccount(userID), Clearing, amount)
+}
+
+// PayoutSettled records the bank paying out a cleared withdrawal.
+func PayoutSettled(transactionID int, amount int64) Journal {
+	return transfer(KindPayoutSettled, transactionID, Clearing, BankSettlement, amount)
+}
+
+// PayoutFailed returns a withdrawal that never reached the bank from
+// clearing to the user.
+func PayoutFailed(transactionID, userID int, amount int64) Journal {
+	return transfer(KindPayoutFailed, transactionID, Clearing, UserAccount(userID), amount)
+}
+
+// PayoutCancelled returns a withdrawal cancelled before it was sent to the
+// bank from clearing to the user.
+func PayoutCancelled(transactionID, userID int, amount int64) Journal {
+	return transfer(KindPayoutCancelled, transactionID, Clearing, UserAccount(userID), amount)
+}
+
+// PayoutReversed returns a settled payout that the bank sent back.
+func PayoutReversed(transactionID, userID int, amount int64) Journal {
+	return transfer(KindPayoutReversed, transactionID, BankSettlement, UserAccount(userID), amount)
+}
+
+// Transfer moves funds between two customer wallets.
+func Transfer(transactionID, senderID, receiverID int, amount int64) Journal {
+	return transfer(KindTransfer, transactionID, UserAccount(senderID), UserAccount(receiverID), amount)
+}
+
+// Debit spends wallet funds on an in-app purchase.
+func Debit(transactionID, userID int, amount int64) Journal {
+	return transfer(KindDebit, transactionID, UserAccount(userID), Purchases, amount)
+}
+
+// Refund returns charged funds to the payer.
+func Refund(transactionID, userID int, amount int64) Journal {
+	return transfer(KindRefund, transactionID, UserAccount(userID), BankSettlement, amount)
+}
+
+// Fee collects a fee from a user's wallet.
+func Fee(transactionID, userID int, amount int64) Journal {
+	return transfer(KindFee, transactionID, UserAccount(userID), Fees, amount)
+}
+
+// FeeRefund gives a collected fee back to the user.
+func FeeRefund(transactionID, userID int, amount int64) Journal {
+	return transfer(KindFeeRefund, transactionID, Fees, UserAccount(userID), amount)
+}
+
+// SplitCharge credits several users from one charge received through the
+// bank; amounts[i] goes to userIDs[i].
+func SplitCharge(transactionID int, userIDs []int, amounts []int64) Journal {
+	j := Journal{Kind: KindSplitCharge, TransactionID: transactionID}
+	var total int64
+	for i, userID := range userIDs {
+		j.Postings = append(j.Postings, Posting{Account: UserAccount(userID), Amount: amounts[i]})
+		total += amounts[i]
+	}
+	j.Postings = append([]Posting{{Account: BankSettlement, Amount: -total}}, j.Postings...)
+	return j
+}
@@ -0,0 +1,121 @@
+package ledger_test
+
+import (
+	"context"
+	"testing"
+	"wallet-simulator/internal/ledger"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalsBalance(t *testing.T) {
+	journals := []ledger.Journal{
+		ledger.Charge(1, 7, 1000),
+		ledger.Withdrawal(2, 7, 300),
+		ledger.PayoutSettled(2, 300),
+		ledger.PayoutFailed(3, 7, 300),
+		ledger.PayoutReversed(4, 7, 300),
+		ledger.Transfer(5, 7, 8, 300),
+		ledger.SplitCharge(6, []int{7, 8, 9}, []int64{800, 150, 50}),
+		ledger.Debit(7, 7, 300),
+		ledger.Refund(8, 7, 200),
+		ledger.PayoutCancelled(9, 7, 100),
+	}
+	for _, j := range journals {
+		assert.NoError(t, j.Validate(), j.Kind)
+	}
+
+	charge := ledger.Charge(1, 7, 1000)
+	assert.Equal(t, []ledger.Posting{
+		{Account: ledger.BankSettlement, Amount: -1000},
+		{Account: "user:7", Amount: 1000},
+	}, charge.Postings)
+
+	split := ledger.SplitCharge(6, []int{7, 8}, []int64{800, 200})
+	assert.Equal(t, []ledger.Posting{
+		{Account: ledger.BankSettlement, Amount: -1000},
+		{Account: "user:7", Amount: 800},
+		{Account: "user:8", Amount: 200},
+	}, split.Postings)
+}
+
+func TestJournalValidate(t *testing.T) {
+	unbalanced := ledger.Journal{Kind: "charge", Postings: []ledger.Posting{
+		{Account: ledger.BankSettlement, Amount: -1000},
+		{Account: ledger.UserAccount(7), Amount: 900},
+	}}
+	assert.ErrorIs(t, unbalanced.Validate(), ledger.ErrUnbalancedJournal)
+
+	single := ledger.Journal{Kind: "charge", Postings: []ledger.Posting{{Account: ledger.Fees, Amount: 10}}}
+	assert.ErrorIs(t, single.Validate(), ledger.ErrEmptyJournal)
+
+	zero := ledger.Journal{Kind: "charge", Postings: []ledger.Posting{
+		{Account: ledger.Fees, Amount: 0},
+		{Account: ledger.UserAccount(7), Amount: 0},
+	}}
+	assert.ErrorIs(t, zero.Validate(), ledger.ErrZeroPosting)
+}
+
+func TestUserID(t *testing.T) {
+	id, ok := ledger.UserID(ledger.UserAccount(42))
+	assert.True(t, ok)
+	assert.Equal(t, 42, id)
+
+	_, ok = ledger.UserID(ledger.Clearing)
+	assert.False(t, ok)
+}
+
+func TestPost(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO journal_entries").
+		WithArgs("withdrawal", 2, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec("INSERT INTO postings \\(journal_id, account, amount\\) VALUES \\(\\$1, \\$2, \\$3\\), \\(\\$1, \\$4, \\$5\\)").
+		WithArgs(5, "user:7", int64(-300), ledger.Clearing, int64(300)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+
+	id, err := ledger.Post(tx, ledger.Withdrawal(2, 7, 300))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, id)
+
+	_, err = ledger.Post(tx, ledger.Journal{Kind: "charge", Postings: []ledger.Posting{
+		{Account: ledger.BankSettlement, Amount: -1000},
+		{Account: ledger.UserAccount(7), Amount: 999},
+	}})
+	assert.ErrorIs(t, err, ledger.ErrUnbalancedJournal)
+	tx.Rollback()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCheckInvariant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("FROM journal_entries j LEFT JOIN postings p").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "sum"}).AddRow(9, "charge", 100))
+
+	imbalances, err := ledger.CheckInvariant(context.Background(), db)
+	assert.NoError(t, err)
+	assert.Equal(t, []ledger.Imbalance{{JournalID: 9, Kind: "charge", Sum: 100}}, imbalances)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
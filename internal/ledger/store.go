@@ -0,0 +1,79 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Post validates j and writes it with its postings inside tx, so a journal
+// is committed or rolled back together with the business change it books.
+func Post(tx *sql.Tx, j Journal) (int, error) {
+	if err := j.Validate(); err != nil {
+		return 0, err
+	}
+
+	var id int
+	err := tx.QueryRow(`
+		INSERT INTO journal_entries (kind, transaction_id, created_at) VALUES ($1, $2, $3) RETURNING id
+	`, j.Kind, j.TransactionID, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	values := make([]string, 0, len(j.Postings))
+	args := make([]any, 0, 1+2*len(j.Postings))
+	args = append(args, id)
+	for i, p := range j.Postings {
+		values = append(values, fmt.Sprintf("($1, $%d, $%d)", 2+2*i, 3+2*i))
+		args = append(args, p.Account, p.Amount)
+	}
+	_, err = tx.Exec(`INSERT INTO postings (journal_id, account, amount) VALUES `+strings.Join(values, ", "), args...)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Imbalance is a journal whose stored postings do not sum to zero.
+type Imbalance struct {
+	JournalID int    `json:"journal_id"`
+	Kind      string `json:"kind"`
+	Sum       int64  `json:"sum"`
+}
+
+// CheckInvariant returns every stored journal that violates the double-entry
+// invariant. Journals without postings count as well.
+func CheckInvariant(ctx context.Context, db *sql.DB) ([]Imbalance, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT j.id, j.kind, COALESCE(SUM(p.amount), 0)
+		FROM journal_entries j
+		LEFT JOIN postings p ON p.journal_id = j.id
+		GROUP BY j.id, j.kind
+		HAVING COALESCE(SUM(p.amount), 0) <> 0 OR COUNT(p.id) < 2
+		ORDER BY j.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imbalances := []Imbalance{}
+	for rows.Next() {
+		var im Imbalance
+		if err := rows.Scan(&im.JournalID, &im.Kind, &im.Sum); err != nil {
+			return nil, err
+		}
+		imbalances = append(imbalances, im)
+	}
+	return imbalances, rows.Err()
+}
+
+// Balance returns the sum of all postings to account.
+func Balance(ctx context.Context, db *sql.DB, account string) (int64, error) {
+	var balance int64
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount), 0) FROM postings WHERE account = $1", account).Scan(&balance)
+	return balance, err
+}
@@ -25,6 +25,8 @@ type Metrics struct {
 	// Business metrics (no per-user labels!)
 	ChargeAmount    *prometheus.HistogramVec
 	WithdrawAmount  *prometheus.HistogramVec
+	DebitAmount     *prometheus.HistogramVec
+	DebitCount      *prometheus.CounterVec
 	BalanceSnapshot *prometheus.GaugeVec
 }
 
@@ -120,6 +122,21 @@ func New() *Metrics {
 			},
 			[]string{"operation"},
 		),
+		DebitAmount: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "debit_amount",
+				Help:    "Debit amount distribution",
+				Buckets: prometheus.ExponentialBuckets(1000, 2, 10),
+			},
+			[]string{"operation"},
+		),
+		DebitCount: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "debits_total",
+				Help: "Total debit requests by outcome",
+			},
+			[]string{"result"},
+		),
 		BalanceSnapshot: promauto.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "user_balance_snapshot",
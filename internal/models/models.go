@@ -1,38 +1,492 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 )
 
 type Transaction struct {
-	ID        int        `json:"id"`
-	UserID    int        `json:"user_id"`
-	Amount    int64      `json:"amount"` // positive for charge, negative for withdraw
-	Type      string     `json:"type"`   // "charge" or "withdraw"
-	Status    string     `json:"status"` // "pending", "completed", "failed"
-	CreatedAt time.Time  `json:"created_at"`
-	ReleaseAt *time.Time `json:"release_at"` // optional for charge
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Amount      int64      `json:"amount"` // positive for charge, reversal, fee_refund and transfer_in, negative for withdraw, debit, refund, fee and transfer_out
+	Type        string     `json:"type"`   // "charge", "withdraw", "debit", "refund", "reversal", "fee", "fee_refund", "transfer_out" or "transfer_in"
+	Status      string     `json:"status"` // see the Status* constants
+	CreatedAt   time.Time  `json:"created_at"`
+	ReleaseAt   *time.Time `json:"release_at"`             // optional for charge and transfer_in
+	ReferenceID *int       `json:"reference_id,omitempty"` // withdrawal a reversal compensates, charge a refund gives back, transaction a fee is for, or fee a fee_refund gives back
+	TransferID  *int       `json:"transfer_id,omitempty"`  // transfer both legs belong to
+}
+
+// Transaction statuses. Withdrawals move pending -> processing ->
+// completed | failed, and completed -> reversed; a scheduled withdrawal
+// becomes pending at its execute_at, and one not yet sent to the bank can be
+// cancelled. Charges are completed until
+// refunded in full; debits, refunds and reversals are always completed.
+const (
+	StatusScheduled    = "scheduled"
+	StatusPending      = "pending"
+	StatusProcessing   = "processing"
+	StatusCompleted    = "completed"
+	StatusFailed       = "failed"
+	StatusReversed     = "reversed"
+	StatusManualReview = "manual_review"
+	StatusRefunded     = "refunded"
+	StatusCancelled    = "cancelled"
+)
+
+// TransactionDetail is a single transaction together with the state of its
+// payout job, for withdrawals.
+type TransactionDetail struct {
+	Transaction
+	IdempotencyKey string     `json:"idempotency_key"`
+	UpdatedAt      *time.Time `json:"updated_at"`
+	Attempts       int        `json:"attempts"`       // bank payout attempts so far
+	LastError      *string    `json:"last_error"`     // most recent payout failure
+	BankReference  *string    `json:"bank_reference"` // set once the bank accepted the payout
+}
+
+// WithdrawalJob is a durable bank payout job claimed by the worker pool.
+type WithdrawalJob struct {
+	ID             int    `json:"id"`
+	TransactionID  int    `json:"transaction_id"`
+	UserID         int    `json:"user_id"`
+	Amount         int64  `json:"amount"` // positive amount to pay out
+	IdempotencyKey string `json:"idempotency_key"`
+	Attempts       int    `json:"attempts"`
+}
+
+// Withdrawal job statuses
+const (
+	JobStatusScheduled = "scheduled" // waiting for run_at before it can be claimed
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusDone      = "done"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+// DeadLetter records a task that permanently failed, with enough context
+// for an operator to replay or discard it.
+type DeadLetter struct {
+	ID           int               `json:"id"`
+	TaskType     string            `json:"task_type"`
+	TaskKey      string            `json:"task_key"`
+	UserID       int               `json:"user_id"`
+	Payload      json.RawMessage   `json:"payload"`
+	ErrorHistory []DeadLetterError `json:"error_history"`
+	Attempts     int               `json:"attempts"`
+	Status       string            `json:"status"`
+	ReplayKey    *string           `json:"replay_key"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+type DeadLetterError struct {
+	Attempt int    `json:"attempt"`
+	Error   string `json:"error"`
+}
+
+// Dead letter statuses and task types
+const (
+	DeadLetterOpen      = "open"
+	DeadLetterReplayed  = "replayed"
+	DeadLetterDiscarded = "discarded"
+
+	TaskTypeBankWithdrawal = "bank_withdrawal"
+)
+
+// IdempotencyRecord is the stored outcome of a charge or withdraw request,
+// replayed verbatim when a client retries with the same key and payload.
+type IdempotencyRecord struct {
+	IdempotencyKey string `json:"idempotency_key"`
+	Operation      string `json:"operation"` // "charge" or "withdraw"
+	UserID         int    `json:"user_id"`
+	Fingerprint    string `json:"fingerprint"` // sha256 of the request payload
+	Status         string `json:"status"`
+	ResponseCode   int    `json:"response_code"`
+	ResponseBody   string `json:"response_body"`
+	ContentType    string `json:"content_type"`
+}
+
+// Idempotency key statuses
+const (
+	IdempotencyInProgress = "in_progress"
+	IdempotencyCompleted  = "completed"
+)
+
+// Account is a user's materialized balance, kept in step with every ledger
+// entry. Version is bumped on every change.
+type Account struct {
+	UserID       int       `json:"user_id"`
+	Total        int64     `json:"total"`
+	Withdrawable int64     `json:"withdrawable"`
+	Version      int       `json:"version"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// AccountMismatch is an account whose materialized balance disagrees with
+// the sums over its transactions.
+type AccountMismatch struct {
+	UserID               int   `json:"user_id"`
+	Total                int64 `json:"total"`
+	Withdrawable         int64 `json:"withdrawable"`
+	ExpectedTotal        int64 `json:"expected_total"`
+	ExpectedWithdrawable int64 `json:"expected_withdrawable"`
+}
+
+// Transfer moves funds from one wallet to another. The receiver's leg may be
+// held until ReleaseAt like a charge.
+type Transfer struct {
+	ID               int        `json:"id"`
+	SenderID         int        `json:"sender_id"`
+	ReceiverID       int        `json:"receiver_id"`
+	Amount           int64      `json:"amount"`
+	ReleaseAt        *time.Time `json:"release_at"`
+	IdempotencyKey   string     `json:"idempotency_key"`
+	OutTransactionID int        `json:"out_transaction_id"`
+	InTransactionID  int        `json:"in_transaction_id"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// SplitCharge is one incoming charge divided among several recipients. Each
+// leg is booked as a charge of its own, with its own release schedule.
+type SplitCharge struct {
+	ID             int        `json:"id"`
+	PayerID        int        `json:"payer_id"`
+	Amount         int64      `json:"amount"`
+	IdempotencyKey string     `json:"idempotency_key"`
+	Legs           []SplitLeg `json:"legs"`
+	CreatedAt      time.Time  `json:"created_at"`
 }
 
+// SplitLeg is one recipient's part of a split charge.
+type SplitLeg struct {
+	UserID        int        `json:"user_id"`
+	Amount        int64      `json:"amount"`    // allocated amount, remainders included
+	ShareBps      int        `json:"share_bps"` // 0 for fixed-amount legs
+	ReleaseAt     *time.Time `json:"release_at"`
+	TransactionID int        `json:"transaction_id"`
+}
+
+// ScheduledRelease is locked money due to become withdrawable: a held
+// charge or received transfer, or one tranche of a staged charge.
+type ScheduledRelease struct {
+	TransactionID int       `json:"transaction_id"`
+	TrancheID     *int      `json:"tranche_id,omitempty"`
+	Type          string    `json:"type"`
+	Amount        int64     `json:"amount"`
+	ReleaseAt     time.Time `json:"release_at"`
+}
+
+// ReleaseChange is the audit record of an operator moving the release time
+// of a held charge or received transfer, or of one tranche of a staged
+// charge. ChangedBy is the name the operator gave: admin endpoints share one
+// token, so it is not tied to who actually made the request.
+type ReleaseChange struct {
+	ID            int        `json:"id"`
+	TransactionID int        `json:"transaction_id"`
+	TrancheID     *int       `json:"tranche_id,omitempty"`
+	ChangedBy     string     `json:"changed_by"`
+	Reason        string     `json:"reason"`
+	OldReleaseAt  *time.Time `json:"old_release_at"`
+	NewReleaseAt  time.Time  `json:"new_release_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// Hold reserves part of a user's withdrawable balance until it is captured
+// into a withdrawal or debit, voided, or expires. Capturing less than Amount releases
+// the rest.
+type Hold struct {
+	ID                   int        `json:"id"`
+	UserID               int        `json:"user_id"`
+	Amount               int64      `json:"amount"`
+	CapturedAmount       int64      `json:"captured_amount"`
+	Status               string     `json:"status"`
+	IdempotencyKey       string     `json:"idempotency_key"`
+	ExpiresAt            time.Time  `json:"expires_at"`
+	CaptureTransactionID *int       `json:"capture_transaction_id,omitempty"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            *time.Time `json:"updated_at"`
+}
+
+// Hold statuses
+const (
+	HoldActive   = "active"
+	HoldCaptured = "captured"
+	HoldVoided   = "voided"
+	HoldExpired  = "expired"
+)
+
+// Ways to resolve a withdrawal in manual_review
+const (
+	ResolveRequeue = "requeue"
+	ResolveFail    = "fail"
+	ResolveCancel  = "cancel"
+)
+
+// What a hold is captured into
+const (
+	CaptureWithdraw = "withdraw"
+	CaptureDebit    = "debit"
+)
+
+// Refund gives back part or all of a charge. FromLocked is the part taken
+// from the charge's funds that were still held, FromWithdrawable the rest.
+type Refund struct {
+	ID               int       `json:"id"` // the refund transaction
+	ChargeID         int       `json:"charge_id"`
+	UserID           int       `json:"user_id"`
+	Amount           int64     `json:"amount"`
+	FromLocked       int64     `json:"from_locked"`
+	FromWithdrawable int64     `json:"from_withdrawable"`
+	FeeRefunded      int64     `json:"fee_refunded"` // charge fee given back by a full refund
+	Remaining        int64     `json:"remaining"`    // refundable amount left on the charge
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// StandingInstruction pays out to the bank on a cron schedule: a fixed
+// Amount, or the whole withdrawable balance in sweep mode. A run is skipped
+// while the withdrawable balance is below MinThreshold, and the instruction
+// is paused after too many runs in a row are refused by withdrawal limits.
+type StandingInstruction struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	Schedule        string     `json:"schedule"` // minute hour day-of-month month day-of-week, in UTC
+	Mode            string     `json:"mode"`     // see the Instruction* constants
+	Amount          int64      `json:"amount"`   // fixed mode only
+	MinThreshold    int64      `json:"min_threshold"`
+	Status          string     `json:"status"`
+	NextRunAt       *time.Time `json:"next_run_at"` // set while active
+	LastRunAt       *time.Time `json:"last_run_at"`
+	LimitRejections int        `json:"limit_rejections"` // consecutive runs refused by withdrawal limits
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       *time.Time `json:"updated_at"`
+}
+
+// Standing instruction modes and statuses
+const (
+	InstructionFixed = "fixed"
+	InstructionSweep = "sweep"
+
+	InstructionActive    = "active"
+	InstructionPaused    = "paused"
+	InstructionCancelled = "cancelled"
+)
+
+// StandingInstructionRun is one scheduled run of a standing instruction and
+// the withdrawal it generated, if any.
+type StandingInstructionRun struct {
+	ID            int       `json:"id"`
+	InstructionID int       `json:"instruction_id"`
+	TransactionID *int      `json:"transaction_id,omitempty"`
+	Amount        int64     `json:"amount"`
+	Status        string    `json:"status"`           // see the Run* constants
+	Reason        string    `json:"reason,omitempty"` // why the run was skipped or failed
+	ScheduledAt   time.Time `json:"scheduled_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Standing instruction run statuses
+const (
+	RunWithdrawn = "withdrawn"
+	RunSkipped   = "skipped"
+	RunFailed    = "failed"
+)
+
+// WithdrawalLimits are the velocity limits that apply to a user's
+// withdrawals: their tier's, with any per-user overrides on top. A nil limit
+// is unlimited.
+type WithdrawalLimits struct {
+	UserID         int    `json:"user_id"`
+	Tier           string `json:"tier"`
+	PerTransaction *int64 `json:"per_transaction"`
+	DailyAmount    *int64 `json:"daily_amount"`   // rolling 24 hours
+	MonthlyAmount  *int64 `json:"monthly_amount"` // calendar month
+	DailyCount     *int   `json:"daily_count"`    // rolling 24 hours
+}
+
+// WithdrawalUsage is what counts against the limits: every withdrawal not
+// failed, cancelled or reversed, from the time it was accepted.
+type WithdrawalUsage struct {
+	DailyAmount   int64 `json:"daily_amount"`
+	MonthlyAmount int64 `json:"monthly_amount"`
+	DailyCount    int   `json:"daily_count"`
+}
+
+// FeeRule is one tier of the fee schedule of an operation. It applies to
+// amounts from MinAmount up to the MinAmount of the next tier.
+type FeeRule struct {
+	Operation string `json:"operation"` // see the Fee* constants
+	MinAmount int64  `json:"min_amount"`
+	Flat      int64  `json:"flat"`
+	Bps       int    `json:"bps"`
+	MinFee    *int64 `json:"min_fee,omitempty"`
+	MaxFee    *int64 `json:"max_fee,omitempty"`
+}
+
+// Operations that can carry a fee
+const (
+	FeeWithdraw = "withdraw"
+	FeeCharge   = "charge"
+)
+
+// DefaultLimitTier applies to users without a withdrawal_limits row.
+const DefaultLimitTier = "standard"
+
 type Balance struct {
 	Total        int64 `json:"total"`
 	Withdrawable int64 `json:"withdrawable"`
 }
 
 type ChargeRequest struct {
+	UserID          int              `json:"user_id"`
+	Amount          int64            `json:"amount"`
+	IdempotencyKey  string           `json:"idempotency_key"`
+	ReleaseAt       *time.Time       `json:"release_at"`
+	ReleaseSchedule *ReleaseSchedule `json:"release_schedule,omitempty"` // replaces release_at
+}
+
+// ReleaseSchedule releases a charge in stages: either explicit Tranches, or
+// Installments equal parts every Interval starting at StartAt.
+type ReleaseSchedule struct {
+	Tranches     []ReleaseTranche `json:"tranches,omitempty"`
+	Installments int              `json:"installments,omitempty"`
+	Interval     string           `json:"interval,omitempty"` // "day", "week" or "month"
+	StartAt      *time.Time       `json:"start_at,omitempty"` // defaults to now
+}
+
+// ReleaseTranche releases ShareBps of the charge at ReleaseAt, or
+// immediately when ReleaseAt is empty.
+type ReleaseTranche struct {
+	ShareBps  int        `json:"share_bps"`
+	ReleaseAt *time.Time `json:"release_at"`
+}
+
+type WithdrawRequest struct {
 	UserID         int        `json:"user_id"`
 	Amount         int64      `json:"amount"`
 	IdempotencyKey string     `json:"idempotency_key"`
-	ReleaseAt      *time.Time `json:"release_at"`
+	ExecuteAt      *time.Time `json:"execute_at,omitempty"` // pay out at this time instead of right away
 }
 
-type WithdrawRequest struct {
+type DebitRequest struct {
 	UserID         int    `json:"user_id"`
 	Amount         int64  `json:"amount"`
 	IdempotencyKey string `json:"idempotency_key"`
 }
 
+type RefundRequest struct {
+	UserID         int    `json:"user_id"`
+	Amount         int64  `json:"amount"` // defaults to everything not yet refunded
+	IdempotencyKey string `json:"idempotency_key"`
+	AllowOverdraft bool   `json:"allow_overdraft"` // let the withdrawable balance go negative
+}
+
+type CancelWithdrawalRequest struct {
+	UserID int `json:"user_id"`
+}
+
+type RescheduleWithdrawalRequest struct {
+	UserID    int        `json:"user_id"`
+	ExecuteAt *time.Time `json:"execute_at"`
+}
+
+type StandingInstructionRequest struct {
+	UserID       int    `json:"user_id"`
+	Schedule     string `json:"schedule"`
+	Mode         string `json:"mode"`
+	Amount       int64  `json:"amount"`
+	MinThreshold int64  `json:"min_threshold"`
+	Status       string `json:"status"` // updates only: active or paused
+}
+
+type WithdrawQuoteRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+// FeeQuote is what an operation on Amount costs: Total is taken from the
+// balance for a withdrawal, and credited less Fee for a charge.
+type FeeQuote struct {
+	Operation string `json:"operation"`
+	Amount    int64  `json:"amount"`
+	Fee       int64  `json:"fee"`
+	Total     int64  `json:"total"`
+}
+
+type FeeScheduleRequest struct {
+	Rules []FeeRule `json:"rules"`
+}
+
+type WithdrawalLimitsResponse struct {
+	Limits WithdrawalLimits `json:"limits"`
+	Usage  WithdrawalUsage  `json:"usage"`
+}
+
+// WithdrawalLimitsRequest sets a user's tier and overrides. An omitted
+// override falls back to the tier.
+type WithdrawalLimitsRequest struct {
+	Tier           string `json:"tier"`
+	PerTransaction *int64 `json:"per_transaction"`
+	DailyAmount    *int64 `json:"daily_amount"`
+	MonthlyAmount  *int64 `json:"monthly_amount"`
+	DailyCount     *int   `json:"daily_count"`
+}
+
+type TransferRequest struct {
+	SenderID       int        `json:"sender_id"`
+	ReceiverID     int        `json:"receiver_id"`
+	Amount         int64      `json:"amount"`
+	IdempotencyKey string     `json:"idempotency_key"`
+	ReleaseAt      *time.Time `json:"release_at"` // optional hold on the received funds
+}
+
+// SplitRecipient sets either a fixed Amount or ShareBps, a share in basis
+// points of what is left after the fixed amounts.
+type SplitRecipient struct {
+	UserID    int        `json:"user_id"`
+	Amount    int64      `json:"amount,omitempty"`
+	ShareBps  int        `json:"share_bps,omitempty"`
+	ReleaseAt *time.Time `json:"release_at"` // optional hold on this leg
+}
+
+type SplitChargeRequest struct {
+	PayerID        int              `json:"payer_id"` // scopes the idempotency key
+	Amount         int64            `json:"amount"`
+	IdempotencyKey string           `json:"idempotency_key"`
+	Recipients     []SplitRecipient `json:"recipients"`
+}
+
+// ReleaseChangeRequest moves a hold. ReleaseAt is only read by the hold
+// endpoint; early release always releases now.
+type ReleaseChangeRequest struct {
+	ReleaseAt *time.Time `json:"release_at"`
+	ChangedBy string     `json:"changed_by"`
+	Reason    string     `json:"reason"`
+}
+
+type HoldRequest struct {
+	UserID         int        `json:"user_id"`
+	Amount         int64      `json:"amount"`
+	IdempotencyKey string     `json:"idempotency_key"`
+	ExpiresAt      *time.Time `json:"expires_at"` // defaults to the configured hold TTL
+}
+
+type CaptureHoldRequest struct {
+	UserID int    `json:"user_id"`
+	Amount int64  `json:"amount"` // defaults to the whole hold
+	Into   string `json:"into"`   // withdraw (default) or debit
+}
+
+type ResolveWithdrawalRequest struct {
+	Action string `json:"action"` // requeue, fail or cancel
+}
+
+type VoidHoldRequest struct {
+	UserID int `json:"user_id"`
+}
+
 type TransactionsResponse struct {
 	Transactions []Transaction `json:"transactions"`
 	Total        int           `json:"total"`
@@ -40,6 +494,19 @@ type TransactionsResponse struct {
 	Limit        int           `json:"limit"`
 }
 
+type DeadLettersResponse struct {
+	DeadLetters []DeadLetter `json:"dead_letters"`
+	Total       int          `json:"total"`
+	Page        int          `json:"page"`
+	Limit       int          `json:"limit"`
+}
+
+type ReleaseTimelineResponse struct {
+	UserID   int                `json:"user_id"`
+	Locked   int64              `json:"locked"` // sum of the releases
+	Releases []ScheduledRelease `json:"releases"`
+}
+
 type ChargeResponse struct {
 	Message        string `json:"message"`
 	IdempotencyKey string `json:"idempotency_key"`
@@ -51,6 +518,13 @@ type WithdrawResponse struct {
 	Status         string `json:"status"`
 }
 
+type DebitResponse struct {
+	Message        string `json:"message"`
+	IdempotencyKey string `json:"idempotency_key"`
+	TransactionID  int    `json:"transaction_id"`
+	Status         string `json:"status"`
+}
+
 type BalanceResponse struct {
 	Total        int64 `json:"total"`
 	Withdrawable int64 `json:"withdrawable"`
@@ -63,16 +537,26 @@ type HealthResponse struct {
 
 type ErrorResponse struct {
 	Error  string `json:"error"`
+	Code   string `json:"code,omitempty"` // stable machine-readable reason, where there is one
 	Status int    `json:"status"`
 }
 
+// ReservedKeyPrefixes start the idempotency keys of transactions the
+// service books on its own: hold captures, standing instruction runs, split
+// legs and transfer legs. Client keys may not use them, so the two never
+// collide.
+var ReservedKeyPrefixes = []string{"hold:", "standing:", "split:", "transfer:"}
+
 // Custom errors
 var (
 	ErrDuplicateRequest      = errors.New("duplicate request - idempotency key already exists")
 	ErrInsufficientBalance   = errors.New("insufficient withdrawable balance")
 	ErrBankFailed            = errors.New("bank withdrawal failed")
+	ErrTooManyClaims         = errors.New("withdrawal job claimed too many times")
 	ErrInvalidAmount         = errors.New("invalid amount")
 	ErrMissingIdempotencyKey = errors.New("missing idempotency_key")
+	ErrMissingPayerID        = errors.New("missing payer_id")
+	ErrReservedKeyPrefix     = errors.New("idempotency_key uses a reserved prefix")
 	ErrUserNotFound          = errors.New("user not found")
 	ErrAmountMustBePositive  = errors.New("amount must be positive")
 	ErrTransactionNotFound   = errors.New("Transaction Not Found")
@@ -80,6 +564,69 @@ var (
 	ErrReleaseAtCannotBeEmpty = errors.New("realease at cannot be empty")
 	ErrInvalidReleaseAtFormat = errors.New("release at has invalid format")
 	ErrReleaseAtMustBeFuture  = errors.New("release at must be in future")
+	ErrReleaseAtWithSchedule  = errors.New("release_at and release_schedule cannot both be set")
+	ErrInvalidReleaseSchedule = errors.New("release schedule takes either tranches or installments")
+	ErrNotHeld                = errors.New("only charges and received transfers can be held")
+	ErrAlreadyReleased        = errors.New("funds are already released")
+	ErrMissingChangedBy       = errors.New("missing changed_by")
+	ErrMissingReason          = errors.New("missing reason")
+
+	ErrHoldNotFound          = errors.New("hold not found")
+	ErrHoldNotActive         = errors.New("hold already captured, voided or expired")
+	ErrCaptureExceedsHold    = errors.New("capture amount exceeds the hold")
+	ErrCaptureBelowFee       = errors.New("capture amount does not cover the withdrawal fee")
+	ErrInvalidCaptureTarget  = errors.New("into must be withdraw or debit")
+	ErrExpiresAtMustBeFuture = errors.New("expires_at must be in future")
 
 	ErrAmountCannotBeZero = errors.New("amount cannot be zero")
+
+	ErrNotRefundable       = errors.New("only charges can be refunded")
+	ErrRefundExceedsCharge = errors.New("refund amount exceeds what is left of the charge")
+	ErrRefundOverdraft     = errors.New("refund would make the withdrawable balance negative")
+
+	ErrSelfTransfer = errors.New("sender and receiver must be different users")
+
+	ErrInvalidStatusTransition = errors.New("invalid withdrawal status transition")
+	ErrNotInManualReview       = errors.New("withdrawal is not in manual review")
+	ErrInvalidResolution       = errors.New("action must be requeue, fail or cancel")
+	ErrPayoutAlreadySent       = errors.New("withdrawal already sent to the bank")
+	ErrWithdrawalCancelled     = errors.New("withdrawal already cancelled")
+	ErrWithdrawalNotScheduled  = errors.New("only scheduled withdrawals can be rescheduled")
+	ErrMissingExecuteAt        = errors.New("missing execute_at")
+	ErrExecuteAtMustBeFuture   = errors.New("execute_at must be in future")
+
+	ErrInstructionNotFound     = errors.New("standing instruction not found")
+	ErrInstructionCancelled    = errors.New("standing instruction is cancelled")
+	ErrInvalidInstructionMode  = errors.New("mode must be fixed or sweep")
+	ErrInvalidInstructionState = errors.New("status must be active or paused")
+	ErrSweepWithAmount         = errors.New("a sweep pays out the whole balance and takes no amount")
+	ErrNegativeThreshold       = errors.New("min_threshold cannot be negative")
+
+	ErrWithdrawalLimitExceeded = errors.New("withdrawal limit exceeded")
+	ErrPerTransactionLimit     = fmt.Errorf("%w: per-transaction amount", ErrWithdrawalLimitExceeded)
+	ErrDailyLimit              = fmt.Errorf("%w: daily amount", ErrWithdrawalLimitExceeded)
+	ErrMonthlyLimit            = fmt.Errorf("%w: monthly amount", ErrWithdrawalLimitExceeded)
+	ErrDailyCountLimit         = fmt.Errorf("%w: daily count", ErrWithdrawalLimitExceeded)
+	ErrUnknownLimitTier        = errors.New("unknown withdrawal limit tier")
+	ErrUnknownFeeOperation     = errors.New("fees apply to withdraw or charge")
+	ErrNegativeLimit           = errors.New("limits cannot be negative")
+
+	ErrUnauthorized            = errors.New("unauthorized")
+	ErrDeadLetterNotFound      = errors.New("dead letter not found")
+	ErrDeadLetterClosed        = errors.New("dead letter already replayed or discarded")
+	ErrUnsupportedDeadLetter   = errors.New("dead letter task type cannot be replayed")
+	ErrInvalidDeadLetterStatus = errors.New("invalid dead letter status")
+
+	ErrIdempotencyKeyReused   = errors.New("idempotency key already used with a different request")
+	ErrRequestInProgress      = errors.New("a request with this idempotency key is still in progress")
+	ErrIdempotencyKeyConflict = errors.New("X-Idempotency-Key header and idempotency_key body field differ")
 )
+
+// WithdrawalLimitCodes are the error codes returned for each withdrawal
+// limit, so clients can tell them apart without parsing the message.
+var WithdrawalLimitCodes = map[error]string{
+	ErrPerTransactionLimit: "per_transaction_limit",
+	ErrDailyLimit:          "daily_limit",
+	ErrMonthlyLimit:        "monthly_limit",
+	ErrDailyCountLimit:     "daily_count_limit",
+}
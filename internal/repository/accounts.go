@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"wallet-simulator/internal/ledger"
+	"wallet-simulator/internal/models"
+)
+
+// applyBalance adds the deltas of a ledger entry to the user's materialized
+// balance in the same transaction as the entry itself.
+func applyBalance(tx *sql.Tx, userID int, total, withdrawable int64) error {
+	now := time.Now()
+	_, err := tx.Exec(`
+		INSERT INTO accounts (user_id, total, withdrawable, version, created_at, updated_at)
+		VALUES ($1, $2, $3, 1, $4, $4)
+		ON CONFLICT (user_id) DO UPDATE SET
+			total = accounts.total + EXCLUDED.total,
+			withdrawable = accounts.withdrawable + EXCLUDED.withdrawable,
+			version = accounts.version + 1,
+			updated_at = EXCLUDED.updated_at
+	`, userID, total, withdrawable, now)
+	return err
+}
+
+// GetAccount returns the user's materialized balance.
+func (r *Repository) GetAccount(userID int) (*models.Account, error) {
+	var a models.Account
+	err := r.db.QueryRow(`
+		SELECT user_id, total, withdrawable, version, updated_at FROM accounts WHERE user_id = $1
+	`, userID).Scan(&a.UserID, &a.Total, &a.Withdrawable, &a.Version, &a.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrUserNotFound
+	}
+	return &a, err
+}
+
+// ReleaseDueCharges moves up to limit charges, received transfers and
+// release tranches whose release_at has passed from the locked to the
+// withdrawable part of their accounts. Concurrent runs skip each other's
+// rows.
+func (r *Repository) ReleaseDueCharges(ctx context.Context, limit int) (int, error) {
+	released := 0
+	err := r.runTx(ctx, nil, func(tx *sql.Tx) error {
+		now := time.Now()
+		due := map[int]int64{}
+		var order []int
+		for _, query := range []string{`
+			UPDATE transactions SET released_at = $1
+			WHERE id IN (
+				SELECT id FROM transactions
+				WHERE type IN ('charge', 'transfer_in') AND released_at IS NULL AND release_at <= $1
+				ORDER BY release_at LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING user_id, amount
+		`, `
+			UPDATE charge_releases SET released_at = $1
+			WHERE id IN (
+				SELECT id FROM charge_releases
+				WHERE released_at IS NULL AND release_at <= $1
+				ORDER BY release_at LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING user_id, amount
+		`} {
+			rows, err := tx.QueryContext(ctx, query, now, limit-released)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var userID int
+				var amount int64
+				if err := rows.Scan(&userID, &amount); err != nil {
+					rows.Close()
+					return err
+				}
+				if _, ok := due[userID]; !ok {
+					order = append(order, userID)
+				}
+				due[userID] += amount
+				released++
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+
+		for _, userID := range order {
+			if err := applyBalance(tx, userID, 0, due[userID]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return released, nil
+}
+
+// CheckAccountConsistency compares every materialized balance with the raw
+// sums over the user's transactions, read from a single snapshot, and returns
+// the accounts that disagree. A staged charge is released as a whole, less
+// its tranches that are still locked, and active holds are not withdrawable.
+func (r *Repository) CheckAccountConsistency(ctx context.Context) ([]models.AccountMismatch, error) {
+	mismatches := []models.AccountMismatch{}
+	err := r.runTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT COALESCE(a.user_id, s.user_id), COALESCE(a.total, 0), COALESCE(a.withdrawable, 0),
+				COALESCE(s.total, 0), COALESCE(s.withdrawable, 0)
+			FROM accounts a
+			FULL OUTER JOIN (
+				SELECT t.user_id, SUM(t.amount) AS total,
+					COALESCE(SUM(t.amount) FILTER (WHERE t.type NOT IN ('charge', 'transfer_in') OR t.released_at IS NOT NULL), 0)
+						- COALESCE(MAX(l.locked), 0) - COALESCE(MAX(h.held), 0) AS withdrawable
+				FROM transactions t
+				LEFT JOIN (
+					SELECT user_id, SUM(amount) AS locked FROM charge_releases WHERE released_at IS NULL GROUP BY user_id
+				) l ON l.user_id = t.user_id
+				LEFT JOIN (
+					SELECT user_id, SUM(amount) AS held FROM holds WHERE status = 'active' GROUP BY user_id
+				) h ON h.user_id = t.user_id
+				GROUP BY t.user_id
+			) s ON s.user_id = a.user_id
+			WHERE COALESCE(a.total, 0) <> COALESCE(s.total, 0)
+				OR COALESCE(a.withdrawable, 0) <> COALESCE(s.withdrawable, 0)
+			ORDER BY 1
+		`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var m models.AccountMismatch
+			if err := rows.Scan(&m.UserID, &m.Total, &m.Withdrawable, &m.ExpectedTotal, &m.ExpectedWithdrawable); err != nil {
+				return err
+			}
+			mismatches = append(mismatches, m)
+		}
+		return rows.Err()
+	})
+	return mismatches, err
+}
+
+// CheckLedgerInvariant returns every journal whose postings do not sum to
+// zero.
+func (r *Repository) CheckLedgerInvariant(ctx context.Context) ([]ledger.Imbalance, error) {
+	return ledger.CheckInvariant(ctx, r.db)
+}
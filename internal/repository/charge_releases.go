@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"wallet-simulator/internal/ledger"
+	"wallet-simulator/internal/models"
+	"wallet-simulator/internal/split"
+)
+
+// ChargeWithSchedule books a charge that becomes withdrawable in stages.
+// Every release is stored as a tranche row of its own; tranches that are
+// already due are released straight away and the rest are picked up by
+// ReleaseDueCharges. The charge row itself counts as released, with
+// release_at set to its last tranche, so the locked part of the balance is
+// always the sum of its unreleased tranches.
+func (r *Repository) ChargeWithSchedule(ctx context.Context, userID int, amount int64, releases []split.Release, idempotencyKey string) error {
+	return r.runTx(ctx, nil, func(tx *sql.Tx) error {
+		var exists int
+		err := tx.QueryRow("SELECT 1 FROM transactions WHERE user_id = $1 AND type = 'charge' AND idempotency_key = $2 FOR UPDATE", userID, idempotencyKey).Scan(&exists)
+		if err == nil {
+			return models.ErrDuplicateRequest
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		now := time.Now()
+		last := now
+		for _, rel := range releases {
+			if rel.At.After(last) {
+				last = rel.At
+			}
+		}
+		txID, err := insertTransaction(tx, userID, amount, "charge", models.StatusCompleted, now, &last, &now, idempotencyKey, nil)
+		if err != nil {
+			return err
+		}
+
+		withdrawable := amount
+		for _, rel := range releases {
+			var releasedAt *time.Time
+			if !rel.At.After(now) {
+				releasedAt = &now
+			} else {
+				withdrawable -= rel.Amount
+			}
+			_, err := tx.Exec(`
+				INSERT INTO charge_releases (transaction_id, user_id, amount, release_at, released_at)
+				VALUES ($1, $2, $3, $4, $5)
+			`, txID, userID, rel.Amount, rel.At, releasedAt)
+			if err != nil {
+				return err
+			}
+		}
+		if err := applyBalance(tx, userID, amount, withdrawable); err != nil {
+			return err
+		}
+
+		if _, err := ledger.Post(tx, ledger.Charge(txID, userID, amount)); err != nil {
+			return err
+		}
+		return chargeFee(ctx, tx, userID, txID, amount, idempotencyKey)
+	})
+}
+
+// GetReleaseTimeline returns the user's locked funds in the order they are
+// due: charges and received transfers held until release_at, and the
+// unreleased tranches of staged charges.
+func (r *Repository) GetReleaseTimeline(userID int) ([]models.ScheduledRelease, error) {
+	rows, err := r.db.Query(`
+		SELECT id, NULL, type, amount, release_at FROM transactions
+		WHERE user_id = $1 AND type IN ('charge', 'transfer_in') AND released_at IS NULL
+		UNION ALL
+		SELECT c.transaction_id, c.id, t.type, c.amount, c.release_at
+		FROM charge_releases c JOIN transactions t ON t.id = c.transaction_id
+		WHERE c.user_id = $1 AND c.released_at IS NULL
+		ORDER BY 5, 1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := []models.ScheduledRelease{}
+	for rows.Next() {
+		var rel models.ScheduledRelease
+		var trancheID sql.NullInt64
+		if err := rows.Scan(&rel.TransactionID, &trancheID, &rel.Type, &rel.Amount, &rel.ReleaseAt); err != nil {
+			return nil, err
+		}
+		if trancheID.Valid {
+			id := int(trancheID.Int64)
+			rel.TrancheID = &id
+		}
+		releases = append(releases, rel)
+	}
+	return releases, rows.Err()
+}
@@ -0,0 +1,52 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"wallet-simulator/internal/models"
+	"wallet-simulator/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWithdraw_ConcurrentNoOverdraft runs against the test database and is
+// skipped when it is not reachable.
+func TestWithdraw_ConcurrentNoOverdraft(t *testing.T) {
+	repo, err := utils.OpenTestDB()
+	if err != nil {
+		t.Skipf("test database unavailable: %v", err)
+	}
+
+	userID := 42
+	released := time.Now().Add(-time.Hour)
+	assert.NoError(t, repo.Charge(userID, 1000, &released, "concurrency-charge"))
+
+	const withdrawals = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < withdrawals; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := repo.Withdraw(context.Background(), userID, 100, fmt.Sprintf("concurrency-withdraw-%d", i))
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+				return
+			}
+			assert.Equal(t, models.ErrInsufficientBalance, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, succeeded)
+
+	withdrawable, err := repo.GetWithdrawableBalance(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), withdrawable)
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"wallet-simulator/internal/models"
+)
+
+const deadLetterColumns = `id, task_type, task_key, user_id, payload, error_history, attempts, status, replay_key, created_at, updated_at`
+
+func (r *Repository) CreateDeadLetter(ctx context.Context, dl models.DeadLetter) (int, error) {
+	history, err := json.Marshal(dl.ErrorHistory)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = r.db.QueryRowContext(ctx, `
+		INSERT INTO dead_letters (task_type, task_key, user_id, payload, error_history, attempts, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
+	`, dl.TaskType, dl.TaskKey, dl.UserID, []byte(dl.Payload), history, dl.Attempts, models.DeadLetterOpen).Scan(&id)
+	return id, err
+}
+
+func (r *Repository) GetDeadLetters(status string, page, limit int) ([]models.DeadLetter, int, error) {
+	offset := (page - 1) * limit
+	rows, err := r.db.Query(`
+		SELECT `+deadLetterColumns+`
+		FROM dead_letters WHERE ($1 = '' OR status = $1)
+		ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3
+	`, status, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	deadLetters := []models.DeadLetter{}
+	for rows.Next() {
+		dl, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		deadLetters = append(deadLetters, *dl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = r.db.QueryRow("SELECT COUNT(*) FROM dead_letters WHERE ($1 = '' OR status = $1)", status).Scan(&total)
+	return deadLetters, total, err
+}
+
+func (r *Repository) GetDeadLetter(id int) (*models.DeadLetter, error) {
+	row := r.db.QueryRow(`SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = $1`, id)
+	dl, err := scanDeadLetter(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrDeadLetterNotFound
+	}
+	return dl, err
+}
+
+// ReplayDeadLetter re-submits a dead-lettered withdrawal as a new withdrawal
+// with its own idempotency key, so the failed row keeps its history and the
+// funds are checked and reserved again like any other request.
+func (r *Repository) ReplayDeadLetter(ctx context.Context, id int) (*models.DeadLetter, error) {
+	var dl *models.DeadLetter
+	err := r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		dl, err = scanDeadLetter(tx.QueryRowContext(ctx, `SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = $1 FOR UPDATE`, id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrDeadLetterNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if dl.Status != models.DeadLetterOpen {
+			return models.ErrDeadLetterClosed
+		}
+		if dl.TaskType != models.TaskTypeBankWithdrawal {
+			return models.ErrUnsupportedDeadLetter
+		}
+
+		var job models.WithdrawalJob
+		if err := json.Unmarshal(dl.Payload, &job); err != nil {
+			return err
+		}
+
+		replayKey := fmt.Sprintf("%s:replay:%d", job.IdempotencyKey, dl.ID)
+		if _, err := r.withdraw(tx, job.UserID, job.Amount, replayKey, nil); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE dead_letters SET status = $1, replay_key = $2, updated_at = NOW() WHERE id = $3
+		`, models.DeadLetterReplayed, replayKey, dl.ID)
+		if err != nil {
+			return err
+		}
+
+		dl.Status = models.DeadLetterReplayed
+		dl.ReplayKey = &replayKey
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+func (r *Repository) DiscardDeadLetter(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE dead_letters SET status = $1, updated_at = NOW() WHERE id = $2 AND status = $3
+	`, models.DeadLetterDiscarded, id, models.DeadLetterOpen)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		if _, err := r.GetDeadLetter(id); err != nil {
+			return err
+		}
+		return models.ErrDeadLetterClosed
+	}
+	return nil
+}
+
+func scanDeadLetter(row rowScanner) (*models.DeadLetter, error) {
+	var dl models.DeadLetter
+	var payload, history []byte
+	var replayKey sql.NullString
+	err := row.Scan(&dl.ID, &dl.TaskType, &dl.TaskKey, &dl.UserID, &payload, &history, &dl.Attempts, &dl.Status, &replayKey, &dl.CreatedAt, &dl.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	dl.Payload = json.RawMessage(payload)
+	if err := json.Unmarshal(history, &dl.ErrorHistory); err != nil {
+		return nil, err
+	}
+	if replayKey.Valid {
+		dl.ReplayKey = &replayKey.String
+	}
+	return &dl, nil
+}
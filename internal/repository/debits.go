@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"wallet-simulator/internal/ledger"
+	"wallet-simulator/internal/models"
+)
+
+// Debit spends amount of the user's withdrawable balance on an in-app
+// purchase. Unlike Withdraw it settles in the same transaction: there is no
+// bank payout, so the debit is completed as soon as it is booked.
+func (r *Repository) Debit(ctx context.Context, userID int, amount int64, idempotencyKey string) (int, error) {
+	var txID int
+	err := r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		txID, err = r.debit(tx, userID, amount, idempotencyKey)
+		return err
+	})
+	return txID, err
+}
+
+func (r *Repository) debit(tx *sql.Tx, userID int, amount int64, idempotencyKey string) (int, error) {
+	if err := lockUser(tx, userID); err != nil {
+		return 0, err
+	}
+
+	withdrawable, err := withdrawableBalance(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if withdrawable < amount {
+		return 0, models.ErrInsufficientBalance
+	}
+
+	var exists int
+	err = tx.QueryRow("SELECT 1 FROM transactions WHERE user_id = $1 AND type = 'debit' AND idempotency_key = $2", userID, idempotencyKey).Scan(&exists)
+	if err == nil {
+		return 0, models.ErrDuplicateRequest
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	txID, err := r.CreateTransaction(tx, userID, -amount, "debit", nil, idempotencyKey)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := ledger.Post(tx, ledger.Debit(txID, userID, amount)); err != nil {
+		return 0, err
+	}
+	return txID, nil
+}
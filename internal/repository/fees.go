@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"wallet-simulator/internal/fees"
+	"wallet-simulator/internal/ledger"
+	"wallet-simulator/internal/models"
+)
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// QuoteFee returns what operation on amount costs under the current fee
+// schedule.
+func (r *Repository) QuoteFee(operation string, amount int64) (*models.FeeQuote, error) {
+	fee, err := quoteFee(r.db, operation, amount)
+	if err != nil {
+		return nil, err
+	}
+	total := amount + fee
+	if operation == models.FeeCharge {
+		total = amount - fee
+	}
+	return &models.FeeQuote{Operation: operation, Amount: amount, Fee: fee, Total: total}, nil
+}
+
+// GetFeeSchedule returns the fee rules of every operation.
+func (r *Repository) GetFeeSchedule() ([]models.FeeRule, error) {
+	rows, err := r.db.Query(`SELECT operation, min_amount, flat, bps, min_fee, max_fee FROM fee_rules ORDER BY operation, min_amount`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.FeeRule{}
+	for rows.Next() {
+		var rule models.FeeRule
+		var minFee, maxFee sql.NullInt64
+		if err := rows.Scan(&rule.Operation, &rule.MinAmount, &rule.Flat, &rule.Bps, &minFee, &maxFee); err != nil {
+			return nil, err
+		}
+		if minFee.Valid {
+			rule.MinFee = &minFee.Int64
+		}
+		if maxFee.Valid {
+			rule.MaxFee = &maxFee.Int64
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// SetFeeSchedule replaces the fee rules of an operation. An empty schedule
+// makes it free.
+func (r *Repository) SetFeeSchedule(ctx context.Context, operation string, rules []models.FeeRule) ([]models.FeeRule, error) {
+	err := r.runTx(ctx, nil, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM fee_rules WHERE operation = $1", operation); err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO fee_rules (operation, min_amount, flat, bps, min_fee, max_fee) VALUES ($1, $2, $3, $4, $5, $6)
+			`, operation, rule.MinAmount, rule.Flat, rule.Bps, rule.MinFee, rule.MaxFee)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := []models.FeeRule{}
+	for _, rule := range rules {
+		rule.Operation = operation
+		schedule = append(schedule, rule)
+	}
+	return schedule, nil
+}
+
+func quoteFee(q querier, operation string, amount int64) (int64, error) {
+	rules, err := feeRules(q, operation)
+	if err != nil {
+		return 0, err
+	}
+	return fees.Calculate(rules, amount), nil
+}
+
+func feeRules(q querier, operation string) ([]fees.Rule, error) {
+	rows, err := q.Query(`SELECT min_amount, flat, bps, min_fee, max_fee FROM fee_rules WHERE operation = $1 ORDER BY min_amount`, operation)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []fees.Rule
+	for rows.Next() {
+		var rule fees.Rule
+		var minFee, maxFee sql.NullInt64
+		if err := rows.Scan(&rule.MinAmount, &rule.Flat, &rule.Bps, &minFee, &maxFee); err != nil {
+			return nil, err
+		}
+		if minFee.Valid {
+			rule.MinFee = &minFee.Int64
+		}
+		if maxFee.Valid {
+			rule.MaxFee = &maxFee.Int64
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// bookFee takes fee from the user as a fee transaction referencing the
+// transaction it was charged for, and credits it to the fees account. Only
+// fromWithdrawable of it comes off the withdrawable balance; the caller has
+// taken the rest out of locked funds. The fee is keyed by operation and the
+// transaction's key, so it is only taken once.
+func bookFee(tx *sql.Tx, userID, referenceID int, operation string, fee, fromWithdrawable int64, idempotencyKey string) error {
+	if fee == 0 {
+		return nil
+	}
+	var feeID int
+	err := tx.QueryRow(`
+		INSERT INTO transactions (user_id, amount, type, status, created_at, idempotency_key, reference_id)
+		VALUES ($1, $2, 'fee', $3, NOW(), $4, $5) RETURNING id
+	`, userID, -fee, models.StatusCompleted, fmt.Sprintf("%s:%s", operation, idempotencyKey), referenceID).Scan(&feeID)
+	if err != nil {
+		return err
+	}
+	if err := applyBalance(tx, userID, -fee, -fromWithdrawable); err != nil {
+		return err
+	}
+	_, err = ledger.Post(tx, ledger.Fee(feeID, userID, fee))
+	return err
+}
+
+// chargeFee takes the charge fee, if the schedule has one, as soon as the
+// charge is booked, and never more than the charge itself. It comes out of
+// the charge's own funds: its still-locked part first, latest release first,
+// and only the rest out of the withdrawable balance, which the charge has
+// just added at least that much to. A held charge is turned into a staged
+// charge with a single tranche first, so its locked part can shrink.
+func chargeFee(ctx context.Context, tx *sql.Tx, userID, chargeID int, amount int64, idempotencyKey string) error {
+	fee, err := quoteFee(tx, models.FeeCharge, amount)
+	if err != nil {
+		return err
+	}
+	fee = min(fee, amount)
+	if fee == 0 {
+		return nil
+	}
+
+	var releaseAt, releasedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT release_at, released_at FROM transactions WHERE id = $1", chargeID).Scan(&releaseAt, &releasedAt)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if !releasedAt.Valid {
+		if err := stageHeldCharge(ctx, tx, chargeID, userID, amount, releaseAt.Time, now); err != nil {
+			return err
+		}
+	}
+
+	fromLocked, err := takeLocked(ctx, tx, chargeID, fee, now)
+	if err != nil {
+		return err
+	}
+	return bookFee(tx, userID, chargeID, models.FeeCharge, fee, fee-fromLocked, idempotencyKey)
+}
+
+// refundFee gives back the fee taken for a withdrawal or a charge, if there
+// was one, and returns how much it gave back.
+func refundFee(tx *sql.Tx, referenceID, userID int) (int64, error) {
+	var feeID int
+	var fee int64
+	var key string
+	err := tx.QueryRow("SELECT id, -amount, idempotency_key FROM transactions WHERE type = 'fee' AND reference_id = $1", referenceID).Scan(&feeID, &fee, &key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var refundID int
+	err = tx.QueryRow(`
+		INSERT INTO transactions (user_id, amount, type, status, created_at, idempotency_key, reference_id)
+		VALUES ($1, $2, 'fee_refund', $3, NOW(), $4, $5) RETURNING id
+	`, userID, fee, models.StatusCompleted, key, feeID).Scan(&refundID)
+	if err != nil {
+		return 0, err
+	}
+	if err := applyBalance(tx, userID, fee, fee); err != nil {
+		return 0, err
+	}
+	if _, err := ledger.Post(tx, ledger.FeeRefund(refundID, userID, fee)); err != nil {
+		return 0, err
+	}
+	log.Printf("💸 Refunded fee of %d to user %d for transaction %d", fee, userID, referenceID)
+	return fee, nil
+}
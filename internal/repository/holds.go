@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"wallet-simulator/internal/fees"
+	"wallet-simulator/internal/models"
+)
+
+const holdColumns = `id, user_id, amount, captured_amount, status, idempotency_key, expires_at, capture_transaction_id, created_at, updated_at`
+
+// PlaceHold reserves amount of the user's withdrawable balance until
+// expiresAt. The reserved amount leaves the withdrawable balance at once but
+// stays in the total until the hold is captured.
+func (r *Repository) PlaceHold(ctx context.Context, userID int, amount int64, expiresAt time.Time, idempotencyKey string) (*models.Hold, error) {
+	var h *models.Hold
+	err := r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		if err := lockUser(tx, userID); err != nil {
+			return err
+		}
+
+		var exists int
+		err := tx.QueryRow("SELECT 1 FROM holds WHERE user_id = $1 AND idempotency_key = $2", userID, idempotencyKey).Scan(&exists)
+		if err == nil {
+			return models.ErrDuplicateRequest
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		withdrawable, err := withdrawableBalance(tx, userID)
+		if err != nil {
+			return err
+		}
+		if withdrawable < amount {
+			return models.ErrInsufficientBalance
+		}
+
+		h, err = scanHold(tx.QueryRowContext(ctx, `
+			INSERT INTO holds (user_id, amount, status, idempotency_key, expires_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING `+holdColumns,
+			userID, amount, models.HoldActive, idempotencyKey, expiresAt, time.Now()))
+		if err != nil {
+			return err
+		}
+		return applyBalance(tx, userID, 0, -amount)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// CaptureHold turns amount of an active hold into a withdrawal or a debit,
+// keyed by the hold so it can only happen once, and releases whatever is
+// left. An amount of zero captures the whole hold. Expired holds cannot be
+// captured, even before ExpireHolds has picked them up.
+func (r *Repository) CaptureHold(ctx context.Context, holdID, userID int, amount int64, into string) (*models.Hold, error) {
+	var h *models.Hold
+	err := r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		h, err = r.activeHold(ctx, tx, holdID, userID)
+		if err != nil {
+			return err
+		}
+		if !h.ExpiresAt.After(time.Now()) {
+			return models.ErrHoldNotActive
+		}
+		if amount == 0 {
+			amount = h.Amount
+		}
+		if amount > h.Amount {
+			return models.ErrCaptureExceedsHold
+		}
+
+		// Hand the reservation back first; withdraw and debit re-check the
+		// balance and take the captured part out again.
+		if err := applyBalance(tx, userID, 0, h.Amount); err != nil {
+			return err
+		}
+		// Clients cannot send keys with this prefix, see
+		// models.ReservedKeyPrefixes.
+		key := fmt.Sprintf("hold:%d", h.ID)
+		var txID int
+		if into == models.CaptureDebit {
+			txID, err = r.debit(tx, userID, amount, key)
+		} else {
+			// The withdrawal fee comes out of the captured amount rather than
+			// on top of it, so a capture never takes more than was reserved.
+			rules, rulesErr := feeRules(tx, models.FeeWithdraw)
+			if rulesErr != nil {
+				return rulesErr
+			}
+			payout := fees.MaxAmount(rules, amount)
+			if payout <= 0 {
+				return models.ErrCaptureBelowFee
+			}
+			txID, err = r.withdraw(tx, userID, payout, key, nil)
+		}
+		if err != nil {
+			return err
+		}
+
+		h, err = scanHold(tx.QueryRowContext(ctx, `
+			UPDATE holds SET status = $1, captured_amount = $2, capture_transaction_id = $3, updated_at = $4
+			WHERE id = $5 RETURNING `+holdColumns,
+			models.HoldCaptured, amount, txID, time.Now(), h.ID))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// VoidHold releases an active hold in full. A hold past its expiry is
+// recorded as expired rather than voided.
+func (r *Repository) VoidHold(ctx context.Context, holdID, userID int) (*models.Hold, error) {
+	var h *models.Hold
+	err := r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		h, err = r.activeHold(ctx, tx, holdID, userID)
+		if err != nil {
+			return err
+		}
+		h, err = closeHold(ctx, tx, h)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ExpireHolds releases up to limit active holds whose expires_at has passed.
+func (r *Repository) ExpireHolds(ctx context.Context, limit int) (int, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id FROM holds WHERE status = $1 AND expires_at <= $2 ORDER BY expires_at LIMIT $3
+	`, models.HoldActive, time.Now(), limit)
+	if err != nil {
+		return 0, err
+	}
+	type due struct{ holdID, userID int }
+	var expired []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.holdID, &d.userID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, d := range expired {
+		_, err := r.VoidHold(ctx, d.holdID, d.userID)
+		// A hold captured or voided since it was listed is simply skipped.
+		if errors.Is(err, models.ErrHoldNotActive) {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (r *Repository) GetHold(holdID, userID int) (*models.Hold, error) {
+	h, err := scanHold(r.db.QueryRow(`SELECT `+holdColumns+` FROM holds WHERE id = $1 AND user_id = $2`, holdID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrHoldNotFound
+	}
+	return h, err
+}
+
+// activeHold locks the user and their hold, which must still be active.
+func (r *Repository) activeHold(ctx context.Context, tx *sql.Tx, holdID, userID int) (*models.Hold, error) {
+	if err := lockUser(tx, userID); err != nil {
+		return nil, err
+	}
+	h, err := scanHold(tx.QueryRowContext(ctx, `SELECT `+holdColumns+` FROM holds WHERE id = $1 AND user_id = $2 FOR UPDATE`, holdID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrHoldNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if h.Status != models.HoldActive {
+		return nil, models.ErrHoldNotActive
+	}
+	return h, nil
+}
+
+// closeHold releases the whole reservation of an active hold.
+func closeHold(ctx context.Context, tx *sql.Tx, h *models.Hold) (*models.Hold, error) {
+	status := models.HoldVoided
+	if !h.ExpiresAt.After(time.Now()) {
+		status = models.HoldExpired
+	}
+	if err := applyBalance(tx, h.UserID, 0, h.Amount); err != nil {
+		return nil, err
+	}
+	return scanHold(tx.QueryRowContext(ctx, `
+		UPDATE holds SET status = $1, updated_at = $2 WHERE id = $3 RETURNING `+holdColumns,
+		status, time.Now(), h.ID))
+}
+
+func scanHold(row rowScanner) (*models.Hold, error) {
+	var h models.Hold
+	var captureTransactionID sql.NullInt64
+	var updatedAt sql.NullTime
+	err := row.Scan(&h.ID, &h.UserID, &h.Amount, &h.CapturedAmount, &h.Status, &h.IdempotencyKey, &h.ExpiresAt,
+		&captureTransactionID, &h.CreatedAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if captureTransactionID.Valid {
+		id := int(captureTransactionID.Int64)
+		h.CaptureTransactionID = &id
+	}
+	if updatedAt.Valid {
+		h.UpdatedAt = &updatedAt.Time
+	}
+	return &h, nil
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"wallet-simulator/internal/models"
+)
+
+// IdempotencyLockTimeout is how long an in-progress key is held before a
+// retry may take it over, so a request lost to a crash does not block the key
+// forever.
+const IdempotencyLockTimeout = time.Minute
+
+// BeginIdempotentRequest claims key for a new request. It returns nil when the
+// caller should execute the request, or the stored record when a previous
+// request with the same payload already completed and its response should be
+// replayed.
+func (r *Repository) BeginIdempotentRequest(ctx context.Context, key, operation string, userID int, fingerprint string) (*models.IdempotencyRecord, error) {
+	now := time.Now()
+	var id int
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (idempotency_key, operation, user_id, fingerprint, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		ON CONFLICT (user_id, operation, idempotency_key) DO UPDATE SET updated_at = EXCLUDED.updated_at
+		WHERE idempotency_keys.status = $5 AND idempotency_keys.updated_at < $7
+			AND idempotency_keys.fingerprint = EXCLUDED.fingerprint
+		RETURNING id
+	`, key, operation, userID, fingerprint, models.IdempotencyInProgress, now, now.Add(-IdempotencyLockTimeout)).Scan(&id)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var rec models.IdempotencyRecord
+	var responseCode sql.NullInt64
+	var responseBody, contentType sql.NullString
+	err = r.db.QueryRowContext(ctx, `
+		SELECT idempotency_key, operation, user_id, fingerprint, status, response_code, response_body, content_type
+		FROM idempotency_keys WHERE user_id = $1 AND operation = $2 AND idempotency_key = $3
+	`, userID, operation, key).Scan(&rec.IdempotencyKey, &rec.Operation, &rec.UserID, &rec.Fingerprint, &rec.Status, &responseCode, &responseBody, &contentType)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released between the insert and the lookup; the client can retry.
+		return nil, models.ErrRequestInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.Fingerprint != fingerprint {
+		return nil, models.ErrIdempotencyKeyReused
+	}
+	if rec.Status != models.IdempotencyCompleted {
+		return nil, models.ErrRequestInProgress
+	}
+	rec.ResponseCode = int(responseCode.Int64)
+	rec.ResponseBody = responseBody.String
+	rec.ContentType = contentType.String
+	return &rec, nil
+}
+
+// CompleteIdempotentRequest stores the response sent for key so retries get
+// the same answer.
+func (r *Repository) CompleteIdempotentRequest(ctx context.Context, key, operation string, userID int, responseCode int, responseBody, contentType string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET status = $4, response_code = $5, response_body = $6, content_type = $7, updated_at = $8
+		WHERE user_id = $1 AND operation = $2 AND idempotency_key = $3
+	`, userID, operation, key, models.IdempotencyCompleted, responseCode, responseBody, contentType, time.Now())
+	return err
+}
+
+// ReleaseIdempotentRequest frees an in-progress key after a request failed
+// without a definite outcome, so the client may retry it.
+func (r *Repository) ReleaseIdempotentRequest(ctx context.Context, key, operation string, userID int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id = $1 AND operation = $2 AND idempotency_key = $3 AND status = $4",
+		userID, operation, key, models.IdempotencyInProgress)
+	return err
+}
+
+// PurgeIdempotencyKeys drops stored responses older than retention. A retry
+// after that gets no replay, but the scoped unique index on transactions
+// still rejects it as a duplicate.
+func (r *Repository) PurgeIdempotencyKeys(ctx context.Context, retention time.Duration) (int64, error) {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < $1", time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
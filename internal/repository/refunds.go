@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"wallet-simulator/internal/ledger"
+	"wallet-simulator/internal/models"
+)
+
+// RefundCharge gives back amount of a charge to its payer, or everything
+// not refunded yet when amount is zero. The refund is taken from the part of
+// the charge that is still locked first, latest release first, and only the
+// rest from the withdrawable balance. That part may not take the balance
+// below zero unless allowOverdraft is set.
+//
+// A charge still waiting for its release_at is turned into a staged charge
+// with a single tranche, so its locked part can shrink like any other. The
+// refund that empties a charge also gives back its charge fee.
+func (r *Repository) RefundCharge(ctx context.Context, chargeID, userID int, amount int64, idempotencyKey string, allowOverdraft bool) (*models.Refund, error) {
+	var refund *models.Refund
+	err := r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		if err := lockUser(tx, userID); err != nil {
+			return err
+		}
+
+		var txType string
+		var chargeAmount int64
+		var releaseAt, releasedAt sql.NullTime
+		err := tx.QueryRowContext(ctx, `
+			SELECT type, amount, release_at, released_at FROM transactions WHERE id = $1 AND user_id = $2 FOR UPDATE
+		`, chargeID, userID).Scan(&txType, &chargeAmount, &releaseAt, &releasedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrTransactionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if txType != "charge" {
+			return models.ErrNotRefundable
+		}
+
+		var exists int
+		err = tx.QueryRowContext(ctx, "SELECT 1 FROM transactions WHERE user_id = $1 AND type = 'refund' AND idempotency_key = $2", userID, idempotencyKey).Scan(&exists)
+		if err == nil {
+			return models.ErrDuplicateRequest
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		var refunded int64
+		err = tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(-amount), 0) FROM transactions WHERE type = 'refund' AND reference_id = $1", chargeID).Scan(&refunded)
+		if err != nil {
+			return err
+		}
+		remaining := chargeAmount - refunded
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount == 0 || amount > remaining {
+			return models.ErrRefundExceedsCharge
+		}
+
+		// Read before anything moves: it already counts a charge that is due
+		// but not yet picked up by ReleaseDueCharges.
+		withdrawable, err := withdrawableBalance(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var released int64
+		if !releasedAt.Valid {
+			if releaseAt.Valid && releaseAt.Time.After(now) {
+				err = stageHeldCharge(ctx, tx, chargeID, userID, chargeAmount, releaseAt.Time, now)
+			} else {
+				released = chargeAmount
+				_, err = tx.ExecContext(ctx, "UPDATE transactions SET released_at = $1, updated_at = $1 WHERE id = $2", now, chargeID)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		fromLocked, err := takeLocked(ctx, tx, chargeID, amount, now)
+		if err != nil {
+			return err
+		}
+		fromWithdrawable := amount - fromLocked
+
+		var feeRefunded int64
+		if amount == remaining {
+			if feeRefunded, err = refundFee(tx, chargeID, userID); err != nil {
+				return err
+			}
+		}
+		if !allowOverdraft && fromWithdrawable > withdrawable+feeRefunded {
+			return models.ErrRefundOverdraft
+		}
+
+		var refundID int
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO transactions (user_id, amount, type, status, created_at, idempotency_key, reference_id)
+			VALUES ($1, $2, 'refund', $3, $4, $5, $6) RETURNING id
+		`, userID, -amount, models.StatusCompleted, now, idempotencyKey, chargeID).Scan(&refundID)
+		if err != nil {
+			return err
+		}
+		if err := applyBalance(tx, userID, -amount, released-fromWithdrawable); err != nil {
+			return err
+		}
+
+		if amount == remaining {
+			_, err = tx.ExecContext(ctx, "UPDATE transactions SET status = $1, updated_at = $2 WHERE id = $3", models.StatusRefunded, now, chargeID)
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := ledger.Post(tx, ledger.Refund(refundID, userID, amount)); err != nil {
+			return err
+		}
+
+		refund = &models.Refund{
+			ID:               refundID,
+			ChargeID:         chargeID,
+			UserID:           userID,
+			Amount:           amount,
+			FromLocked:       fromLocked,
+			FromWithdrawable: fromWithdrawable,
+			FeeRefunded:      feeRefunded,
+			Remaining:        remaining - amount,
+			CreatedAt:        now,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// stageHeldCharge turns a charge held until releaseAt into a staged charge
+// with a single tranche of its whole amount, so refunds and fees can take
+// part of its locked funds. The charge row itself then counts as released.
+func stageHeldCharge(ctx context.Context, tx *sql.Tx, chargeID, userID int, amount int64, releaseAt, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO charge_releases (transaction_id, user_id, amount, release_at) VALUES ($1, $2, $3, $4)
+	`, chargeID, userID, amount, releaseAt)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE transactions SET released_at = $1, updated_at = $1 WHERE id = $2", now, chargeID)
+	return err
+}
+
+// takeLocked takes up to amount out of a charge's tranches that are not due
+// yet, latest first, and returns how much it took. A tranche taken in full
+// is marked released so it leaves the release timeline.
+func takeLocked(ctx context.Context, tx *sql.Tx, chargeID int, amount int64, now time.Time) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, amount FROM charge_releases
+		WHERE transaction_id = $1 AND released_at IS NULL AND release_at > $2
+		ORDER BY release_at DESC, id DESC FOR UPDATE
+	`, chargeID, now)
+	if err != nil {
+		return 0, err
+	}
+	type tranche struct {
+		id     int
+		amount int64
+	}
+	var tranches []tranche
+	for rows.Next() {
+		var t tranche
+		if err := rows.Scan(&t.id, &t.amount); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		tranches = append(tranches, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var taken int64
+	for _, t := range tranches {
+		if taken == amount {
+			break
+		}
+		take := min(t.amount, amount-taken)
+		var releasedAt *time.Time
+		if take == t.amount {
+			releasedAt = &now
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE charge_releases SET amount = $1, released_at = $2 WHERE id = $3", t.amount-take, releasedAt, t.id)
+		if err != nil {
+			return 0, err
+		}
+		taken += take
+	}
+	return taken, nil
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"wallet-simulator/internal/models"
+)
+
+// ChangeReleaseAt moves the release time of a held charge or received
+// transfer to releaseAt, releasing it straight away when releaseAt is not in
+// the future. For a staged charge every tranche that is still locked is
+// moved. Each change is audited with who made it and why. Funds that are
+// already released cannot be locked again, since they may have been spent;
+// that includes funds past their release_at that ReleaseDueCharges has not
+// picked up yet, which already count as withdrawable.
+func (r *Repository) ChangeReleaseAt(ctx context.Context, transactionID int, releaseAt time.Time, changedBy, reason string) ([]models.ReleaseChange, error) {
+	var changes []models.ReleaseChange
+	err := r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		changes = nil
+
+		var userID int
+		var txType string
+		err := tx.QueryRowContext(ctx, "SELECT user_id, type FROM transactions WHERE id = $1", transactionID).Scan(&userID, &txType)
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrTransactionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if txType != "charge" && txType != "transfer_in" {
+			return models.ErrNotHeld
+		}
+		if err := lockUser(tx, userID); err != nil {
+			return err
+		}
+
+		var amount int64
+		var oldReleaseAt, releasedAt sql.NullTime
+		err = tx.QueryRowContext(ctx, `
+			SELECT amount, release_at, released_at FROM transactions WHERE id = $1 FOR UPDATE
+		`, transactionID).Scan(&amount, &oldReleaseAt, &releasedAt)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		release := !releaseAt.After(now)
+		if release {
+			releaseAt = now
+		}
+
+		// A charge still waiting for its release_at is one hold; a staged
+		// charge is released itself and holds its unreleased tranches.
+		type hold struct {
+			trancheID *int
+			amount    int64
+			releaseAt *time.Time
+		}
+		var holds []hold
+		if !releasedAt.Valid {
+			if !oldReleaseAt.Valid {
+				holds = append(holds, hold{amount: amount})
+			} else if oldReleaseAt.Time.After(now) {
+				holds = append(holds, hold{amount: amount, releaseAt: &oldReleaseAt.Time})
+			}
+		} else {
+			rows, err := tx.QueryContext(ctx, `
+				SELECT id, amount, release_at FROM charge_releases
+				WHERE transaction_id = $1 AND released_at IS NULL AND release_at > $2
+				ORDER BY release_at, id FOR UPDATE
+			`, transactionID, now)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var h hold
+				var id int
+				var at time.Time
+				if err := rows.Scan(&id, &h.amount, &at); err != nil {
+					rows.Close()
+					return err
+				}
+				h.trancheID, h.releaseAt = &id, &at
+				holds = append(holds, h)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+		if len(holds) == 0 {
+			return models.ErrAlreadyReleased
+		}
+
+		var releasedAmount int64
+		for _, h := range holds {
+			var newReleasedAt *time.Time
+			if release {
+				newReleasedAt = &now
+				releasedAmount += h.amount
+			}
+			if h.trancheID == nil {
+				_, err = tx.ExecContext(ctx, "UPDATE transactions SET release_at = $1, released_at = $2, updated_at = $3 WHERE id = $4",
+					releaseAt, newReleasedAt, now, transactionID)
+			} else {
+				_, err = tx.ExecContext(ctx, "UPDATE charge_releases SET release_at = $1, released_at = $2 WHERE id = $3",
+					releaseAt, newReleasedAt, *h.trancheID)
+			}
+			if err != nil {
+				return err
+			}
+
+			c := models.ReleaseChange{
+				TransactionID: transactionID,
+				TrancheID:     h.trancheID,
+				ChangedBy:     changedBy,
+				Reason:        reason,
+				OldReleaseAt:  h.releaseAt,
+				NewReleaseAt:  releaseAt,
+				CreatedAt:     now,
+			}
+			err = tx.QueryRowContext(ctx, `
+				INSERT INTO release_changes (transaction_id, tranche_id, changed_by, reason, old_release_at, new_release_at, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
+			`, c.TransactionID, c.TrancheID, c.ChangedBy, c.Reason, c.OldReleaseAt, c.NewReleaseAt, c.CreatedAt).Scan(&c.ID)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, c)
+		}
+
+		if releasedAt.Valid {
+			// A staged charge shows its last tranche as release_at.
+			_, err = tx.ExecContext(ctx, `
+				UPDATE transactions SET release_at = (SELECT MAX(release_at) FROM charge_releases WHERE transaction_id = $1), updated_at = $2
+				WHERE id = $1
+			`, transactionID, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		if releasedAmount > 0 {
+			return applyBalance(tx, userID, 0, releasedAmount)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// GetReleaseChanges returns the audit trail of a transaction's release
+// time, oldest first.
+func (r *Repository) GetReleaseChanges(transactionID int) ([]models.ReleaseChange, error) {
+	rows, err := r.db.Query(`
+		SELECT id, transaction_id, tranche_id, changed_by, reason, old_release_at, new_release_at, created_at
+		FROM release_changes WHERE transaction_id = $1 ORDER BY created_at, id
+	`, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []models.ReleaseChange{}
+	for rows.Next() {
+		var c models.ReleaseChange
+		var trancheID sql.NullInt64
+		var oldReleaseAt sql.NullTime
+		if err := rows.Scan(&c.ID, &c.TransactionID, &trancheID, &c.ChangedBy, &c.Reason, &oldReleaseAt, &c.NewReleaseAt, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		if trancheID.Valid {
+			id := int(trancheID.Int64)
+			c.TrancheID = &id
+		}
+		if oldReleaseAt.Valid {
+			c.OldReleaseAt = &oldReleaseAt.Time
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
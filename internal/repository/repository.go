@@ -4,8 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"log"
 	"time"
+	"wallet-simulator/internal/ledger"
 	"wallet-simulator/internal/models"
 )
 
@@ -17,24 +17,59 @@ func NewRepository(db *sql.DB) *Repository {
 	return &Repository{db: db}
 }
 
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// CreateTransaction inserts a ledger entry and applies it to the user's
+// account. Charges and received transfers only count towards the
+// withdrawable balance once their release_at has passed; until then they are
+// released later by ReleaseDueCharges.
 func (r *Repository) CreateTransaction(tx *sql.Tx, userID int, amount int64, txType string, releaseAt *time.Time, idempotencyKey string) (int, error) {
-	var id int
-	query := `
-		INSERT INTO transactions (user_id, amount, type, status, created_at, release_at, idempotency_key)
-		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
-	`
-	status := "completed"
+	return r.createTransaction(tx, userID, amount, txType, releaseAt, idempotencyKey, nil)
+}
+
+func (r *Repository) createTransaction(tx *sql.Tx, userID int, amount int64, txType string, releaseAt *time.Time, idempotencyKey string, transferID *int) (int, error) {
+	status := models.StatusCompleted
 	if txType == "withdraw" {
-		status = "pending"
+		status = models.StatusPending
 	}
-	err := tx.QueryRow(query, userID, amount, txType, status, time.Now(), releaseAt, idempotencyKey).Scan(&id)
+
+	now := time.Now()
+	var releasedAt *time.Time
+	withdrawable := amount
+	if txType == "charge" || txType == "transfer_in" {
+		if releaseAt == nil || !releaseAt.After(now) {
+			releasedAt = &now
+		} else {
+			withdrawable = 0
+		}
+	}
+
+	id, err := insertTransaction(tx, userID, amount, txType, status, now, releaseAt, releasedAt, idempotencyKey, transferID)
+	if err != nil {
+		return 0, err
+	}
+	if err := applyBalance(tx, userID, amount, withdrawable); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func insertTransaction(tx *sql.Tx, userID int, amount int64, txType, status string, createdAt time.Time, releaseAt, releasedAt *time.Time, idempotencyKey string, transferID *int) (int, error) {
+	var id int
+	err := tx.QueryRow(`
+		INSERT INTO transactions (user_id, amount, type, status, created_at, release_at, released_at, idempotency_key, transfer_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id
+	`, userID, amount, txType, status, createdAt, releaseAt, releasedAt, idempotencyKey, transferID).Scan(&id)
 	return id, err
 }
 
 func (r *Repository) GetTransactions(userID, page, limit int) ([]models.Transaction, int, error) {
 	offset := (page - 1) * limit
 	rows, err := r.db.Query(`
-		SELECT id, user_id, amount, type, status, created_at, release_at
+		SELECT id, user_id, amount, type, status, created_at, release_at, reference_id, transfer_id
 		FROM transactions WHERE user_id = $1
 		ORDER BY created_at DESC LIMIT $2 OFFSET $3
 	`, userID, limit, offset)
@@ -47,13 +82,22 @@ func (r *Repository) GetTransactions(userID, page, limit int) ([]models.Transact
 	for rows.Next() {
 		var t models.Transaction
 		var releaseAt sql.NullTime
-		err = rows.Scan(&t.ID, &t.UserID, &t.Amount, &t.Type, &t.Status, &t.CreatedAt, &releaseAt)
+		var referenceID, transferID sql.NullInt64
+		err = rows.Scan(&t.ID, &t.UserID, &t.Amount, &t.Type, &t.Status, &t.CreatedAt, &releaseAt, &referenceID, &transferID)
 		if err != nil {
 			return nil, 0, err
 		}
 		if releaseAt.Valid {
 			t.ReleaseAt = &releaseAt.Time
 		}
+		if referenceID.Valid {
+			id := int(referenceID.Int64)
+			t.ReferenceID = &id
+		}
+		if transferID.Valid {
+			id := int(transferID.Int64)
+			t.TransferID = &id
+		}
 		transactions = append(transactions, t)
 	}
 
@@ -62,15 +106,88 @@ func (r *Repository) GetTransactions(userID, page, limit int) ([]models.Transact
 	return transactions, total, err
 }
 
+const transactionDetailQuery = `
+	SELECT t.id, t.user_id, t.amount, t.type, t.status, t.created_at, t.release_at, t.reference_id, t.transfer_id,
+		t.idempotency_key, t.updated_at, COALESCE(j.payout_attempts, 0), j.last_error, j.bank_reference
+	FROM transactions t
+	LEFT JOIN withdrawal_jobs j ON j.transaction_id = t.id
+`
+
+// GetTransaction returns one of the user's transactions with its payout job
+// state.
+func (r *Repository) GetTransaction(id, userID int) (*models.TransactionDetail, error) {
+	return scanTransactionDetail(r.db.QueryRow(transactionDetailQuery+` WHERE t.id = $1 AND t.user_id = $2`, id, userID))
+}
+
+// GetWithdrawal looks a withdrawal up by the idempotency key it was
+// submitted with.
+func (r *Repository) GetWithdrawal(idempotencyKey string, userID int) (*models.TransactionDetail, error) {
+	return scanTransactionDetail(r.db.QueryRow(transactionDetailQuery+` WHERE t.idempotency_key = $1 AND t.user_id = $2 AND t.type = 'withdraw'`, idempotencyKey, userID))
+}
+
+func scanTransactionDetail(row rowScanner) (*models.TransactionDetail, error) {
+	var d models.TransactionDetail
+	var releaseAt, updatedAt sql.NullTime
+	var referenceID, transferID sql.NullInt64
+	var idempotencyKey, lastError, bankReference sql.NullString
+	err := row.Scan(&d.ID, &d.UserID, &d.Amount, &d.Type, &d.Status, &d.CreatedAt, &releaseAt, &referenceID, &transferID,
+		&idempotencyKey, &updatedAt, &d.Attempts, &lastError, &bankReference)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if releaseAt.Valid {
+		d.ReleaseAt = &releaseAt.Time
+	}
+	if referenceID.Valid {
+		id := int(referenceID.Int64)
+		d.ReferenceID = &id
+	}
+	if transferID.Valid {
+		id := int(transferID.Int64)
+		d.TransferID = &id
+	}
+	if updatedAt.Valid {
+		d.UpdatedAt = &updatedAt.Time
+	}
+	if lastError.Valid {
+		d.LastError = &lastError.String
+	}
+	if bankReference.Valid {
+		d.BankReference = &bankReference.String
+	}
+	d.IdempotencyKey = idempotencyKey.String
+	return &d, nil
+}
+
+// Balances are read from the materialized accounts row. A withdrawal holds
+// its funds from the moment it is accepted, whatever its status, and a
+// failed or reversed one is offset by its reversal entry.
 func (r *Repository) GetTotalBalance(userID int) (int64, error) {
 	var total int64
-	err := r.db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE user_id = $1 AND status = 'completed'", userID).Scan(&total)
+	err := r.db.QueryRow("SELECT COALESCE((SELECT total FROM accounts WHERE user_id = $1), 0)", userID).Scan(&total)
 	return total, err
 }
 
 func (r *Repository) GetWithdrawableBalance(userID int) (int64, error) {
+	return withdrawableBalance(r.db, userID)
+}
+
+// withdrawableBalance also counts charges and release tranches that are due
+// but not yet picked up by ReleaseDueCharges, so a release is visible as soon
+// as release_at passes.
+func withdrawableBalance(q queryRower, userID int) (int64, error) {
 	var withdrawable int64
-	err := r.db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE user_id = $1 AND status = 'completed' AND ((type = 'charge' AND release_at <= $2) OR type = 'withdraw')", userID, time.Now()).Scan(&withdrawable)
+	err := q.QueryRow(`
+		SELECT COALESCE((SELECT withdrawable FROM accounts WHERE user_id = $1), 0)
+			+ COALESCE((SELECT SUM(amount) FROM transactions
+				WHERE user_id = $1 AND type IN ('charge', 'transfer_in') AND released_at IS NULL AND release_at <= $2), 0)
+			+ COALESCE((SELECT SUM(amount) FROM charge_releases
+				WHERE user_id = $1 AND released_at IS NULL AND release_at <= $2), 0)
+	`, userID, time.Now()).Scan(&withdrawable)
 	return withdrawable, err
 }
 
@@ -81,7 +198,7 @@ func (r *Repository) Charge(userID int, amount int64, releaseAt *time.Time, idem
 	}
 
 	var exists int
-	err = tx.QueryRow("SELECT 1 FROM transactions WHERE idempotency_key = $1 FOR UPDATE", idempotencyKey).Scan(&exists)
+	err = tx.QueryRow("SELECT 1 FROM transactions WHERE user_id = $1 AND type = 'charge' AND idempotency_key = $2 FOR UPDATE", userID, idempotencyKey).Scan(&exists)
 	if err == nil {
 		tx.Rollback()
 		return models.ErrDuplicateRequest
@@ -90,71 +207,106 @@ func (r *Repository) Charge(userID int, amount int64, releaseAt *time.Time, idem
 		return err
 	}
 
-	_, err = r.CreateTransaction(tx, userID, amount, "charge", releaseAt, idempotencyKey)
+	txID, err := r.CreateTransaction(tx, userID, amount, "charge", releaseAt, idempotencyKey)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
-	return tx.Commit()
-}
 
-func (r *Repository) Withdraw(ctx context.Context, userID int, amount int64, idempotencyKey string) error {
-	opts := &sql.TxOptions{
-		Isolation: sql.LevelSerializable,
-		ReadOnly:  false,
+	if _, err := ledger.Post(tx, ledger.Charge(txID, userID, amount)); err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	tx, err := r.db.BeginTx(ctx, opts)
-	if err != nil {
+	if err := chargeFee(context.Background(), tx, userID, txID, amount, idempotencyKey); err != nil {
+		tx.Rollback()
 		return err
 	}
+	return tx.Commit()
+}
+
+func (r *Repository) Withdraw(ctx context.Context, userID int, amount int64, idempotencyKey string) error {
+	return r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		_, err := r.withdraw(tx, userID, amount, idempotencyKey, nil)
+		return err
+	})
+}
 
-	defer tx.Rollback()
+// withdraw books a pending withdrawal, its fee and its payout job inside tx.
+// The balance and the user's withdrawal limits are checked in tx under the
+// user's lock, so concurrent withdrawals cannot both spend the same funds or
+// the same remaining limit. With executeAt set the withdrawal is scheduled:
+// its job is only released to the workers once executeAt has passed.
+func (r *Repository) withdraw(tx *sql.Tx, userID int, amount int64, idempotencyKey string, executeAt *time.Time) (int, error) {
+	if err := lockUser(tx, userID); err != nil {
+		return 0, err
+	}
 
-	withdrawable, err := r.GetWithdrawableBalance(userID)
+	withdrawable, err := withdrawableBalance(tx, userID)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return 0, err
 	}
 	if withdrawable < amount {
-		tx.Rollback()
-		return models.ErrInsufficientBalance
+		return 0, models.ErrInsufficientBalance
 	}
 
 	var exists int
-	err = tx.QueryRow("SELECT 1 FROM transactions WHERE idempotency_key = $1", idempotencyKey).Scan(&exists)
+	err = tx.QueryRow("SELECT 1 FROM transactions WHERE user_id = $1 AND type = 'withdraw' AND idempotency_key = $2", userID, idempotencyKey).Scan(&exists)
 	if err == nil {
-		tx.Rollback()
-		return models.ErrDuplicateRequest
+		return 0, models.ErrDuplicateRequest
 	} else if !errors.Is(err, sql.ErrNoRows) {
-		tx.Rollback()
-		return err
+		return 0, err
 	}
 
-	_, err = r.CreateTransaction(tx, userID, -amount, "withdraw", nil, idempotencyKey)
-	if err != nil {
-		tx.Rollback()
-		return err
+	if err := checkWithdrawalLimits(tx, userID, amount); err != nil {
+		return 0, err
 	}
-	return tx.Commit()
-}
 
-func (r *Repository) UpdateWithdrawalStatus(idempotencyKey string, status string, userID int) error {
-	query := `UPDATE transactions SET status = $1 WHERE idempotency_key = $2 AND user_id = $3`
-	result, err := r.db.Exec(query, status, idempotencyKey, userID)
+	// The fee comes out of the balance on top of amount.
+	fee, err := quoteFee(tx, models.FeeWithdraw, amount)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if withdrawable < amount+fee {
+		return 0, models.ErrInsufficientBalance
 	}
 
-	rows, err := result.RowsAffected()
+	status := models.StatusPending
+	if executeAt != nil {
+		status = models.StatusScheduled
+	}
+	txID, err := insertTransaction(tx, userID, -amount, "withdraw", status, time.Now(), nil, nil, idempotencyKey, nil)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if err := applyBalance(tx, userID, -amount, -amount); err != nil {
+		return 0, err
+	}
+	if _, err := ledger.Post(tx, ledger.Withdrawal(txID, userID, amount)); err != nil {
+		return 0, err
+	}
+	if err := bookFee(tx, userID, txID, models.FeeWithdraw, fee, fee, idempotencyKey); err != nil {
+		return 0, err
 	}
 
-	if rows == 0 {
-		return models.ErrUserNotFound
+	// The payout job is committed together with the withdrawal row so no
+	// withdrawal can be accepted without something left to process it.
+	if executeAt != nil {
+		err = r.ScheduleWithdrawalJob(tx, txID, userID, amount, idempotencyKey, *executeAt)
+	} else {
+		err = r.EnqueueWithdrawalJob(tx, txID, userID, amount, idempotencyKey)
 	}
+	if err != nil {
+		return 0, err
+	}
+	return txID, nil
+}
 
-	log.Printf("✅ Updated withdrawal %s status to %s", idempotencyKey, status)
-	return nil
+func (r *Repository) GetWithdrawalStatus(ctx context.Context, idempotencyKey string, userID int) (string, error) {
+	var status string
+	err := r.db.QueryRowContext(ctx, "SELECT status FROM transactions WHERE idempotency_key = $1 AND user_id = $2 AND type = 'withdraw'", idempotencyKey, userID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", models.ErrTransactionNotFound
+	}
+	return status, err
 }
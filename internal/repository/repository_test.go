@@ -3,15 +3,49 @@ package repository_test
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"testing"
 	"time"
 	"wallet-simulator/internal/models"
 	"wallet-simulator/internal/repository"
+	"wallet-simulator/internal/split"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
+func expectJournal(mock sqlmock.Sqlmock, kind string, transactionID int) {
+	mock.ExpectQuery("INSERT INTO journal_entries").
+		WithArgs(kind, transactionID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO postings").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+}
+
+// expectWithdrawalChecks expects the limit check and fee lookup of a
+// withdrawal by a user with no limits configured and no withdrawal fees.
+func expectWithdrawalChecks(mock sqlmock.Sqlmock, userID int) {
+	mock.ExpectQuery("FROM withdrawal_limit_tiers").
+		WithArgs(userID, models.DefaultLimitTier).
+		WillReturnError(sql.ErrNoRows)
+	expectNoFee(mock, models.FeeWithdraw)
+}
+
+func expectNoFee(mock sqlmock.Sqlmock, operation string) {
+	mock.ExpectQuery("FROM fee_rules WHERE operation = \\$1").
+		WithArgs(operation).
+		WillReturnRows(sqlmock.NewRows([]string{"min_amount", "flat", "bps", "min_fee", "max_fee"}))
+}
+
+// expectNoFeeRefund expects the fee lookup of a refunded withdrawal that
+// was free.
+func expectNoFeeRefund(mock sqlmock.Sqlmock, withdrawalID int) {
+	mock.ExpectQuery("SELECT id, -amount, idempotency_key FROM transactions WHERE type = 'fee'").
+		WithArgs(withdrawalID).
+		WillReturnError(sql.ErrNoRows)
+}
+
 func TestGetTotalBalance(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -24,7 +58,7 @@ func TestGetTotalBalance(t *testing.T) {
 	userID := 1
 	expectedBalance := int64(500)
 
-	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM transactions").
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT total FROM accounts").
 		WithArgs(userID).
 		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(expectedBalance))
 
@@ -54,13 +88,18 @@ func TestCharge(t *testing.T) {
 	// Check for duplicate
 	mock.ExpectBegin()
 	mock.ExpectQuery("SELECT 1 FROM transactions").
-		WithArgs(idempotencyKey).
+		WithArgs(userID, idempotencyKey).
 		WillReturnError(sql.ErrNoRows)
 
 	// Insert transaction
 	mock.ExpectQuery("INSERT INTO transactions").
-		WithArgs(userID, amount, "charge", "completed", sqlmock.AnyArg(), &releaseAt, idempotencyKey).
+		WithArgs(userID, amount, "charge", "completed", sqlmock.AnyArg(), &releaseAt, sqlmock.AnyArg(), idempotencyKey, nil).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(userID, amount, int64(0), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "charge", 1)
+	expectNoFee(mock, models.FeeCharge)
 	mock.ExpectCommit()
 
 	err = repo.Charge(userID, amount, &releaseAt, idempotencyKey)
@@ -71,6 +110,65 @@ func TestCharge(t *testing.T) {
 	}
 }
 
+func TestCharge_HeldChargePaysFeeFromLockedFunds(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	// The user has nothing withdrawable, so the 30 fee on a held charge of
+	// 1000 comes out of the charge, which stays locked as a 970 tranche.
+	releaseAt := time.Now().Add(48 * time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(1, "charge-key-457").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, int64(1000), "charge", "completed", sqlmock.AnyArg(), &releaseAt, nil, "charge-key-457", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(1000), int64(0), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "charge", 5)
+	mock.ExpectQuery("FROM fee_rules WHERE operation = \\$1").
+		WithArgs(models.FeeCharge).
+		WillReturnRows(sqlmock.NewRows([]string{"min_amount", "flat", "bps", "min_fee", "max_fee"}).
+			AddRow(0, 30, 0, nil, nil))
+	mock.ExpectQuery("SELECT release_at, released_at FROM transactions").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"release_at", "released_at"}).AddRow(releaseAt, nil))
+	mock.ExpectExec("INSERT INTO charge_releases").
+		WithArgs(5, 1, int64(1000), releaseAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE transactions SET released_at").
+		WithArgs(sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, amount FROM charge_releases").
+		WithArgs(5, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount"}).AddRow(8, 1000))
+	mock.ExpectExec("UPDATE charge_releases SET amount").
+		WithArgs(int64(970), nil, 8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO transactions .*'fee'").
+		WithArgs(1, int64(-30), models.StatusCompleted, "charge:charge-key-457", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-30), int64(0), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "fee", 6)
+	mock.ExpectCommit()
+
+	err = repo.Charge(1, 1000, &releaseAt, "charge-key-457")
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
 func TestWithdraw_InsufficientBalance(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -85,7 +183,10 @@ func TestWithdraw_InsufficientBalance(t *testing.T) {
 	idempotencyKey := "withdraw-key-789"
 
 	mock.ExpectBegin()
-	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM transactions").
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, userID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
 		WithArgs(userID, sqlmock.AnyArg()).
 		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(100)) // Less than amount
 	mock.ExpectRollback()
@@ -97,3 +198,1947 @@ func TestWithdraw_InsufficientBalance(t *testing.T) {
 		t.Errorf("unfulfilled expectations: %s", err)
 	}
 }
+
+func TestWithdraw_EnqueuesJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	userID := 1
+	amount := int64(300)
+	idempotencyKey := "withdraw-key-790"
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, userID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(userID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1000))
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(userID, idempotencyKey).
+		WillReturnError(sql.ErrNoRows)
+	expectWithdrawalChecks(mock, userID)
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(userID, -amount, "withdraw", "pending", sqlmock.AnyArg(), nil, nil, idempotencyKey, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(userID, -amount, -amount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "withdrawal", 7)
+	mock.ExpectExec("INSERT INTO withdrawal_jobs").
+		WithArgs(7, userID, amount, idempotencyKey, models.JobStatusQueued).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = repo.Withdraw(context.Background(), userID, amount, idempotencyKey)
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDebit_CompletesWithoutPayoutJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	userID := 1
+	amount := int64(300)
+	idempotencyKey := "debit-key-1"
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, userID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(userID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1000))
+	mock.ExpectQuery("SELECT 1 FROM transactions WHERE user_id = \\$1 AND type = 'debit'").
+		WithArgs(userID, idempotencyKey).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(userID, -amount, "debit", "completed", sqlmock.AnyArg(), nil, nil, idempotencyKey, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(userID, -amount, -amount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "debit", 8)
+	mock.ExpectCommit()
+
+	txID, err := repo.Debit(context.Background(), userID, amount, idempotencyKey)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, txID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDebit_InsufficientBalance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(100))
+	mock.ExpectRollback()
+
+	_, err = repo.Debit(context.Background(), 1, 300, "debit-key-2")
+	assert.Equal(t, models.ErrInsufficientBalance, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestScheduleWithdrawal_ReservesFundsAndSchedulesJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	executeAt := time.Now().Add(72 * time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1000))
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(1, "payday-1").
+		WillReturnError(sql.ErrNoRows)
+	expectWithdrawalChecks(mock, 1)
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, int64(-300), "withdraw", models.StatusScheduled, sqlmock.AnyArg(), nil, nil, "payday-1", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-300), int64(-300), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "withdrawal", 7)
+	mock.ExpectExec("INSERT INTO withdrawal_jobs").
+		WithArgs(7, 1, int64(300), "payday-1", models.JobStatusScheduled, executeAt).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = repo.ScheduleWithdrawal(context.Background(), 1, 300, executeAt, "payday-1")
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPromoteScheduledWithdrawals_SkipsCancelled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectQuery("SELECT transaction_id FROM withdrawal_jobs").
+		WithArgs(models.JobStatusScheduled, sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(7).AddRow(8))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM transactions WHERE id = \\$1 FOR UPDATE").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.StatusScheduled))
+	mock.ExpectExec("UPDATE withdrawal_jobs SET status").
+		WithArgs(models.JobStatusQueued, 7, models.JobStatusScheduled, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE transactions SET status").
+		WithArgs(models.StatusPending, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Cancelled after it was listed
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM transactions WHERE id = \\$1 FOR UPDATE").
+		WithArgs(8).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.StatusCancelled))
+	mock.ExpectCommit()
+
+	promoted, err := repo.PromoteScheduledWithdrawals(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, promoted)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestClaimWithdrawalJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectQuery("UPDATE withdrawal_jobs .* FOR UPDATE SKIP LOCKED").
+		WithArgs(models.JobStatusRunning, float64(60), models.JobStatusQueued).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "user_id", "amount", "idempotency_key", "attempts"}).
+			AddRow(3, 7, 1, 300, "withdraw-key-790", 1))
+
+	job, err := repo.ClaimWithdrawalJob(context.Background(), time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, &models.WithdrawalJob{ID: 3, TransactionID: 7, UserID: 1, Amount: 300, IdempotencyKey: "withdraw-key-790", Attempts: 1}, job)
+
+	// Nothing runnable
+	mock.ExpectQuery("UPDATE withdrawal_jobs").
+		WillReturnError(sql.ErrNoRows)
+
+	job, err = repo.ClaimWithdrawalJob(context.Background(), time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, job)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRecoverPendingWithdrawals(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, user_id, amount, idempotency_key FROM transactions .* FOR UPDATE").
+		WithArgs(models.StatusPending, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount", "idempotency_key"}).AddRow(7, 1, -300, "withdraw-key-790"))
+	mock.ExpectExec("UPDATE transactions SET status").
+		WithArgs(models.StatusManualReview, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO withdrawal_jobs .* ON CONFLICT \\(transaction_id\\) DO UPDATE").
+		WithArgs(models.JobStatusQueued, sqlmock.AnyArg(), models.JobStatusDone, models.JobStatusFailed).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	requeued, flagged, err := repo.RecoverPendingWithdrawals(context.Background(), time.Minute, 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), requeued)
+	assert.Equal(t, int64(1), flagged)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	payload := []byte(`{"id":3,"transaction_id":7,"user_id":1,"amount":300,"idempotency_key":"withdraw-key-790","attempts":2}`)
+	history := []byte(`[{"attempt":1,"error":"bank unavailable"}]`)
+	replayKey := "withdraw-key-790:replay:5"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM dead_letters WHERE id = \\$1 FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "task_type", "task_key", "user_id", "payload", "error_history", "attempts", "status", "replay_key", "created_at", "updated_at"}).
+			AddRow(5, models.TaskTypeBankWithdrawal, "withdraw-key-790", 1, payload, history, 1, models.DeadLetterOpen, nil, time.Now(), time.Now()))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1000))
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(1, replayKey).
+		WillReturnError(sql.ErrNoRows)
+	expectWithdrawalChecks(mock, 1)
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, int64(-300), "withdraw", "pending", sqlmock.AnyArg(), nil, nil, replayKey, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-300), int64(-300), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "withdrawal", 8)
+	mock.ExpectExec("INSERT INTO withdrawal_jobs").
+		WithArgs(8, 1, int64(300), replayKey, models.JobStatusQueued).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE dead_letters SET status").
+		WithArgs(models.DeadLetterReplayed, replayKey, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	dl, err := repo.ReplayDeadLetter(context.Background(), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, models.DeadLetterReplayed, dl.Status)
+	assert.Equal(t, replayKey, *dl.ReplayKey)
+	assert.Len(t, dl.ErrorHistory, 1)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateWithdrawalStatus_FailureWritesReversal(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, amount, status FROM transactions .* FOR UPDATE").
+		WithArgs("withdraw-key-790", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "status"}).AddRow(7, -300, models.StatusProcessing))
+	mock.ExpectExec("UPDATE transactions SET status").
+		WithArgs(models.StatusFailed, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO transactions .*'reversal'").
+		WithArgs(1, int64(300), models.StatusCompleted, "withdraw-key-790:reversal", 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(300), int64(300), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "payout_failed", 8)
+	expectNoFeeRefund(mock, 7)
+	mock.ExpectCommit()
+
+	err = repo.UpdateWithdrawalStatus("withdraw-key-790", models.StatusFailed, 1)
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCancelWithdrawal_RefundsAndClosesJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, amount, status FROM transactions .* FOR UPDATE").
+		WithArgs("withdraw-key-790", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "status"}).AddRow(7, -300, models.StatusPending))
+	mock.ExpectExec("UPDATE transactions SET status").
+		WithArgs(models.StatusCancelled, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO transactions .*'reversal'").
+		WithArgs(1, int64(300), models.StatusCompleted, "withdraw-key-790:reversal", 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(300), int64(300), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "payout_cancelled", 8)
+	expectNoFeeRefund(mock, 7)
+	mock.ExpectExec("UPDATE withdrawal_jobs SET status").
+		WithArgs(models.JobStatusCancelled, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT t.id, t.user_id").
+		WithArgs("withdraw-key-790", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount", "type", "status", "created_at", "release_at", "reference_id", "transfer_id",
+			"idempotency_key", "updated_at", "payout_attempts", "last_error", "bank_reference"}).
+			AddRow(7, 1, -300, "withdraw", models.StatusCancelled, time.Now(), nil, nil, nil, "withdraw-key-790", time.Now(), 0, nil, nil))
+
+	withdrawal, err := repo.CancelWithdrawal(context.Background(), "withdraw-key-790", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusCancelled, withdrawal.Status)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCancelWithdrawal_RejectsPayoutAlreadySent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, amount, status FROM transactions .* FOR UPDATE").
+		WithArgs("withdraw-key-790", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "status"}).AddRow(7, -300, models.StatusProcessing))
+	mock.ExpectRollback()
+
+	_, err = repo.CancelWithdrawal(context.Background(), "withdraw-key-790", 1)
+	assert.Equal(t, models.ErrPayoutAlreadySent, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+var detailRowColumns = []string{"id", "user_id", "amount", "type", "status", "created_at", "release_at", "reference_id", "transfer_id",
+	"idempotency_key", "updated_at", "payout_attempts", "last_error", "bank_reference"}
+
+func TestResolveManualReview_RequeueReopensJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, amount, status, idempotency_key FROM transactions .* FOR UPDATE").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "amount", "status", "idempotency_key"}).
+			AddRow(1, -300, models.StatusManualReview, "withdraw-key-790"))
+	mock.ExpectExec("UPDATE transactions SET status").
+		WithArgs(models.StatusPending, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO withdrawal_jobs .* ON CONFLICT \\(transaction_id\\) DO UPDATE").
+		WithArgs(7, 1, int64(300), "withdraw-key-790", models.JobStatusQueued).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT t.id, t.user_id").
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows(detailRowColumns).
+			AddRow(7, 1, -300, "withdraw", models.StatusPending, time.Now(), nil, nil, nil, "withdraw-key-790", time.Now(), 0, nil, nil))
+
+	withdrawal, err := repo.ResolveManualReview(context.Background(), 7, models.ResolveRequeue)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusPending, withdrawal.Status)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestResolveManualReview_FailRefundsAndClosesJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, amount, status, idempotency_key FROM transactions .* FOR UPDATE").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "amount", "status", "idempotency_key"}).
+			AddRow(1, -300, models.StatusManualReview, "withdraw-key-790"))
+	mock.ExpectExec("UPDATE transactions SET status").
+		WithArgs(models.StatusFailed, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO transactions .*'reversal'").
+		WithArgs(1, int64(300), models.StatusCompleted, "withdraw-key-790:reversal", 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(300), int64(300), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "payout_failed", 8)
+	mock.ExpectQuery("SELECT id, -amount, idempotency_key FROM transactions WHERE type = 'fee'").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "idempotency_key"}).AddRow(9, 30, "withdraw:withdraw-key-790"))
+	mock.ExpectQuery("INSERT INTO transactions .*'fee_refund'").
+		WithArgs(1, int64(30), models.StatusCompleted, "withdraw:withdraw-key-790", 9).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(30), int64(30), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "fee_refund", 10)
+	mock.ExpectExec("INSERT INTO withdrawal_jobs").
+		WithArgs(7, 1, int64(300), "withdraw-key-790", models.JobStatusFailed).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT t.id, t.user_id").
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows(detailRowColumns).
+			AddRow(7, 1, -300, "withdraw", models.StatusFailed, time.Now(), nil, nil, nil, "withdraw-key-790", time.Now(), 0, nil, nil))
+
+	withdrawal, err := repo.ResolveManualReview(context.Background(), 7, models.ResolveFail)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusFailed, withdrawal.Status)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestResolveManualReview_RejectsOtherStatuses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, amount, status, idempotency_key FROM transactions .* FOR UPDATE").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "amount", "status", "idempotency_key"}).
+			AddRow(1, -300, models.StatusProcessing, "withdraw-key-790"))
+	mock.ExpectRollback()
+
+	_, err = repo.ResolveManualReview(context.Background(), 7, models.ResolveCancel)
+	assert.Equal(t, models.ErrNotInManualReview, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateWithdrawalStatus_RejectsLateSuccess(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, amount, status FROM transactions .* FOR UPDATE").
+		WithArgs("withdraw-key-790", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "status"}).AddRow(7, -300, models.StatusFailed))
+	mock.ExpectRollback()
+
+	err = repo.UpdateWithdrawalStatus("withdraw-key-790", models.StatusCompleted, 1)
+	assert.ErrorIs(t, err, models.ErrInvalidStatusTransition)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCanTransitionWithdrawal(t *testing.T) {
+	assert.True(t, repository.CanTransitionWithdrawal(models.StatusPending, models.StatusProcessing))
+	assert.True(t, repository.CanTransitionWithdrawal(models.StatusProcessing, models.StatusCompleted))
+	assert.True(t, repository.CanTransitionWithdrawal(models.StatusCompleted, models.StatusReversed))
+	assert.False(t, repository.CanTransitionWithdrawal(models.StatusPending, models.StatusCompleted))
+	assert.False(t, repository.CanTransitionWithdrawal(models.StatusFailed, models.StatusCompleted))
+	assert.False(t, repository.CanTransitionWithdrawal(models.StatusReversed, models.StatusCompleted))
+}
+
+func TestGetWithdrawal(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	createdAt := time.Now()
+	mock.ExpectQuery("SELECT .* FROM transactions t LEFT JOIN withdrawal_jobs j").
+		WithArgs("withdraw-key-790", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount", "type", "status", "created_at", "release_at", "reference_id", "transfer_id",
+			"idempotency_key", "updated_at", "payout_attempts", "last_error", "bank_reference"}).
+			AddRow(7, 1, -300, "withdraw", models.StatusCompleted, createdAt, nil, nil, nil,
+				"withdraw-key-790", createdAt, 2, "bank payout unavailable: bank unavailable", "SIM-1"))
+
+	withdrawal, err := repo.GetWithdrawal("withdraw-key-790", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusCompleted, withdrawal.Status)
+	assert.Equal(t, 2, withdrawal.Attempts)
+	assert.Equal(t, "SIM-1", *withdrawal.BankReference)
+	assert.NotNil(t, withdrawal.LastError)
+
+	mock.ExpectQuery("SELECT .* FROM transactions t LEFT JOIN withdrawal_jobs j").
+		WithArgs("missing", 1).
+		WillReturnError(sql.ErrNoRows)
+
+	_, err = repo.GetWithdrawal("missing", 1)
+	assert.Equal(t, models.ErrTransactionNotFound, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBeginIdempotentRequest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	ctx := context.Background()
+	columns := []string{"idempotency_key", "operation", "user_id", "fingerprint", "status", "response_code", "response_body", "content_type"}
+
+	// First request claims the key
+	mock.ExpectQuery("INSERT INTO idempotency_keys").
+		WithArgs("charge-key-1", "charge", 1, "fp-1", models.IdempotencyInProgress, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	rec, err := repo.BeginIdempotentRequest(ctx, "charge-key-1", "charge", 1, "fp-1")
+	assert.NoError(t, err)
+	assert.Nil(t, rec)
+
+	// Matching retry replays the stored response
+	mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT .* FROM idempotency_keys").
+		WithArgs(1, "charge", "charge-key-1").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("charge-key-1", "charge", 1, "fp-1", models.IdempotencyCompleted, 200, `{"message":"charged"}`, "application/json"))
+
+	rec, err = repo.BeginIdempotentRequest(ctx, "charge-key-1", "charge", 1, "fp-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, rec.ResponseCode)
+	assert.Equal(t, `{"message":"charged"}`, rec.ResponseBody)
+
+	// Same key, different payload
+	mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT .* FROM idempotency_keys").
+		WithArgs(1, "charge", "charge-key-1").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("charge-key-1", "charge", 1, "fp-1", models.IdempotencyCompleted, 200, `{"message":"charged"}`, "application/json"))
+
+	_, err = repo.BeginIdempotentRequest(ctx, "charge-key-1", "charge", 1, "fp-2")
+	assert.Equal(t, models.ErrIdempotencyKeyReused, err)
+
+	// Original request still running
+	mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT .* FROM idempotency_keys").
+		WithArgs(1, "charge", "charge-key-2").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("charge-key-2", "charge", 1, "fp-1", models.IdempotencyInProgress, nil, nil, nil))
+
+	_, err = repo.BeginIdempotentRequest(ctx, "charge-key-2", "charge", 1, "fp-1")
+	assert.Equal(t, models.ErrRequestInProgress, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPurgeIdempotencyKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectExec("DELETE FROM idempotency_keys WHERE created_at < \\$1").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	purged, err := repo.PurgeIdempotencyKeys(context.Background(), 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWithdraw_RetriesSerializationFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	userID := 1
+	amount := int64(300)
+	idempotencyKey := "withdraw-key-791"
+
+	// First attempt is aborted by Postgres
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, userID).
+		WillReturnError(&pq.Error{Code: "40001"})
+	mock.ExpectRollback()
+
+	// Second attempt goes through
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, userID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(userID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1000))
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(userID, idempotencyKey).
+		WillReturnError(sql.ErrNoRows)
+	expectWithdrawalChecks(mock, userID)
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(userID, -amount, "withdraw", "pending", sqlmock.AnyArg(), nil, nil, idempotencyKey, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(userID, -amount, -amount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "withdrawal", 9)
+	mock.ExpectExec("INSERT INTO withdrawal_jobs").
+		WithArgs(9, userID, amount, idempotencyKey, models.JobStatusQueued).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = repo.Withdraw(context.Background(), userID, amount, idempotencyKey)
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestReleaseDueCharges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE transactions SET released_at").
+		WithArgs(sqlmock.AnyArg(), 100).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "amount"}).
+			AddRow(1, 200).
+			AddRow(2, 500).
+			AddRow(1, 300))
+	mock.ExpectQuery("UPDATE charge_releases SET released_at").
+		WithArgs(sqlmock.AnyArg(), 97).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "amount"}).
+			AddRow(2, 100))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(0), int64(500), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(2, int64(0), int64(600), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	released, err := repo.ReleaseDueCharges(context.Background(), 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, released)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCheckAccountConsistency(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM accounts a FULL OUTER JOIN").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "total", "withdrawable", "expected_total", "expected_withdrawable"}).
+			AddRow(3, 1000, 400, 1000, 500))
+	mock.ExpectCommit()
+
+	mismatches, err := repo.CheckAccountConsistency(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []models.AccountMismatch{{UserID: 3, Total: 1000, Withdrawable: 400, ExpectedTotal: 1000, ExpectedWithdrawable: 500}}, mismatches)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTransfer_LocksUsersInOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	senderID, receiverID := 5, 3
+	amount := int64(400)
+	idempotencyKey := "transfer-key-1"
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, receiverID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, senderID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT 1 FROM transfers").
+		WithArgs(senderID, idempotencyKey).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(senderID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1000))
+	mock.ExpectQuery("INSERT INTO transfers").
+		WithArgs(senderID, receiverID, amount, nil, idempotencyKey, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(senderID, -amount, "transfer_out", "completed", sqlmock.AnyArg(), nil, nil, idempotencyKey, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(senderID, -amount, -amount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(receiverID, amount, "transfer_in", "completed", sqlmock.AnyArg(), nil, sqlmock.AnyArg(), "transfer:11", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(receiverID, amount, amount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "transfer", 20)
+	mock.ExpectCommit()
+
+	transfer, err := repo.Transfer(context.Background(), senderID, receiverID, amount, nil, idempotencyKey)
+	assert.NoError(t, err)
+	assert.Equal(t, 11, transfer.ID)
+	assert.Equal(t, 20, transfer.OutTransactionID)
+	assert.Equal(t, 21, transfer.InTransactionID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTransfer_InsufficientBalance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT 1 FROM transfers").
+		WithArgs(1, "transfer-key-2").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(100))
+	mock.ExpectRollback()
+
+	_, err = repo.Transfer(context.Background(), 1, 2, 400, nil, "transfer-key-2")
+	assert.Equal(t, models.ErrInsufficientBalance, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSplitCharge_BooksEveryLeg(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	release := time.Now().Add(time.Hour)
+	recipients := []models.SplitRecipient{
+		{UserID: 7, Amount: 50},
+		{UserID: 8, ShareBps: 5000, ReleaseAt: &release},
+		{UserID: 9, ShareBps: 5000},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT 1 FROM split_charges").
+		WithArgs(42, "split-key-1").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO split_charges").
+		WithArgs(42, int64(1051), "split-key-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+
+	legs := []struct {
+		userID, txID int
+		amount       int64
+		releaseAt    any
+		withdrawable int64
+	}{
+		{7, 30, 50, nil, 50},
+		{8, 31, 501, &release, 0},
+		{9, 32, 500, nil, 500},
+	}
+	for i, leg := range legs {
+		mock.ExpectQuery("INSERT INTO transactions").
+			WithArgs(leg.userID, leg.amount, "charge", "completed", sqlmock.AnyArg(), leg.releaseAt, sqlmock.AnyArg(), fmt.Sprintf("split:4:%d", i+1), nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(leg.txID))
+		mock.ExpectExec("INSERT INTO accounts").
+			WithArgs(leg.userID, leg.amount, leg.withdrawable, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO split_charge_legs").
+			WithArgs(4, i+1, leg.txID, leg.userID, leg.amount, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	expectJournal(mock, "split_charge", 30)
+	// Every leg pays the charge fee on its own amount: 5 flat plus 1%. The
+	// held leg pays it out of its locked funds.
+	for i, leg := range legs {
+		fee := int64(5) + (leg.amount+50)/100
+		key := fmt.Sprintf("charge:split:4:%d", i+1)
+		mock.ExpectQuery("FROM fee_rules WHERE operation = \\$1").
+			WithArgs(models.FeeCharge).
+			WillReturnRows(sqlmock.NewRows([]string{"min_amount", "flat", "bps", "min_fee", "max_fee"}).
+				AddRow(0, 5, 100, nil, nil))
+		fromWithdrawable := fee
+		if leg.releaseAt != nil {
+			fromWithdrawable = 0
+			mock.ExpectQuery("SELECT release_at, released_at FROM transactions").
+				WithArgs(leg.txID).
+				WillReturnRows(sqlmock.NewRows([]string{"release_at", "released_at"}).AddRow(release, nil))
+			mock.ExpectExec("INSERT INTO charge_releases").
+				WithArgs(leg.txID, leg.userID, leg.amount, release).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("UPDATE transactions SET released_at").
+				WithArgs(sqlmock.AnyArg(), leg.txID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("SELECT id, amount FROM charge_releases").
+				WithArgs(leg.txID, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "amount"}).AddRow(50, leg.amount))
+			mock.ExpectExec("UPDATE charge_releases SET amount").
+				WithArgs(leg.amount-fee, nil, 50).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		} else {
+			mock.ExpectQuery("SELECT release_at, released_at FROM transactions").
+				WithArgs(leg.txID).
+				WillReturnRows(sqlmock.NewRows([]string{"release_at", "released_at"}).AddRow(nil, time.Now()))
+			mock.ExpectQuery("SELECT id, amount FROM charge_releases").
+				WithArgs(leg.txID, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "amount"}))
+		}
+		mock.ExpectQuery("INSERT INTO transactions .*'fee'").
+			WithArgs(leg.userID, -fee, models.StatusCompleted, key, leg.txID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(40 + i))
+		mock.ExpectExec("INSERT INTO accounts").
+			WithArgs(leg.userID, -fee, -fromWithdrawable, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectJournal(mock, "fee", 40+i)
+	}
+	mock.ExpectCommit()
+
+	splitCharge, err := repo.SplitCharge(context.Background(), 42, 1051, recipients, "split-key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, splitCharge.ID)
+	assert.Len(t, splitCharge.Legs, 3)
+	assert.Equal(t, int64(501), splitCharge.Legs[1].Amount)
+	assert.Equal(t, 32, splitCharge.Legs[2].TransactionID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChargeWithSchedule_LocksFutureTranches(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	now := time.Now()
+	later := now.Add(7 * 24 * time.Hour)
+	releases := []split.Release{{Amount: 300, At: now}, {Amount: 700, At: later}}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT 1 FROM transactions WHERE user_id = \\$1 AND type = 'charge'").
+		WithArgs(1, "staged-key-1").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, int64(1000), "charge", "completed", sqlmock.AnyArg(), later, sqlmock.AnyArg(), "staged-key-1", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(40))
+	mock.ExpectExec("INSERT INTO charge_releases").
+		WithArgs(40, 1, int64(300), now, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO charge_releases").
+		WithArgs(40, 1, int64(700), later, nil).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(1000), int64(300), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "charge", 40)
+	expectNoFee(mock, models.FeeCharge)
+	mock.ExpectCommit()
+
+	err = repo.ChargeWithSchedule(context.Background(), 1, 1000, releases, "staged-key-1")
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetReleaseTimeline(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	first := time.Now().Add(time.Hour)
+	second := first.Add(24 * time.Hour)
+	mock.ExpectQuery("FROM charge_releases c JOIN transactions t").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tranche_id", "type", "amount", "release_at"}).
+			AddRow(12, nil, "transfer_in", 200, first).
+			AddRow(40, 2, "charge", 700, second))
+
+	releases, err := repo.GetReleaseTimeline(1)
+	assert.NoError(t, err)
+	assert.Len(t, releases, 2)
+	assert.Nil(t, releases[0].TrancheID)
+	assert.Equal(t, 2, *releases[1].TrancheID)
+	assert.Equal(t, int64(700), releases[1].Amount)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChangeReleaseAt_ReleasesHeldCharge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	held := time.Now().Add(24 * time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, type FROM transactions").
+		WithArgs(50).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "type"}).AddRow(1, "charge"))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT amount, release_at, released_at FROM transactions").
+		WithArgs(50).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "release_at", "released_at"}).AddRow(500, held, nil))
+	mock.ExpectExec("UPDATE transactions SET release_at").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 50).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO release_changes").
+		WithArgs(50, nil, "ops", "verified", held, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(0), int64(500), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	changes, err := repo.ChangeReleaseAt(context.Background(), 50, time.Now(), "ops", "verified")
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, held, *changes[0].OldReleaseAt)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChangeReleaseAt_AlreadyReleased(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, type FROM transactions").
+		WithArgs(51).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "type"}).AddRow(1, "charge"))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT amount, release_at, released_at FROM transactions").
+		WithArgs(51).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "release_at", "released_at"}).AddRow(500, time.Now(), time.Now()))
+	mock.ExpectQuery("FROM charge_releases").
+		WithArgs(51, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "release_at"}))
+	mock.ExpectRollback()
+
+	_, err = repo.ChangeReleaseAt(context.Background(), 51, time.Now().Add(time.Hour), "ops", "suspicious")
+	assert.Equal(t, models.ErrAlreadyReleased, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChangeReleaseAt_DueChargeCountsAsReleased(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	// Past its release_at but not yet picked up by ReleaseDueCharges, so
+	// already withdrawable.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, type FROM transactions").
+		WithArgs(52).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "type"}).AddRow(1, "charge"))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT amount, release_at, released_at FROM transactions").
+		WithArgs(52).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "release_at", "released_at"}).AddRow(500, time.Now().Add(-time.Minute), nil))
+	mock.ExpectRollback()
+
+	_, err = repo.ChangeReleaseAt(context.Background(), 52, time.Now().Add(time.Hour), "ops", "suspicious")
+	assert.Equal(t, models.ErrAlreadyReleased, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+var holdRowColumns = []string{"id", "user_id", "amount", "captured_amount", "status", "idempotency_key", "expires_at", "capture_transaction_id", "created_at", "updated_at"}
+
+func TestPlaceHold_ReservesWithdrawable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	expiresAt := time.Now().Add(time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT 1 FROM holds").
+		WithArgs(1, "hold-key-1").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1000))
+	mock.ExpectQuery("INSERT INTO holds").
+		WithArgs(1, int64(400), models.HoldActive, "hold-key-1", expiresAt, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(3, 1, 400, 0, models.HoldActive, "hold-key-1", expiresAt, nil, time.Now(), nil))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(0), int64(-400), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	hold, err := repo.PlaceHold(context.Background(), 1, 400, expiresAt, "hold-key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, hold.ID)
+	assert.Equal(t, models.HoldActive, hold.Status)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCaptureHold_PartialCaptureReleasesRest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	expiresAt := time.Now().Add(time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FROM holds WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(3, 1, 400, 0, models.HoldActive, "hold-key-1", expiresAt, nil, time.Now(), nil))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(0), int64(400), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoFee(mock, models.FeeWithdraw)
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1000))
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(1, "hold:3").
+		WillReturnError(sql.ErrNoRows)
+	expectWithdrawalChecks(mock, 1)
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, int64(-250), "withdraw", "pending", sqlmock.AnyArg(), nil, nil, "hold:3", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(60))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-250), int64(-250), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "withdrawal", 60)
+	mock.ExpectExec("INSERT INTO withdrawal_jobs").
+		WithArgs(60, 1, int64(250), "hold:3", models.JobStatusQueued).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("UPDATE holds SET status").
+		WithArgs(models.HoldCaptured, int64(250), 60, sqlmock.AnyArg(), 3).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(3, 1, 400, 250, models.HoldCaptured, "hold-key-1", expiresAt, 60, time.Now(), time.Now()))
+	mock.ExpectCommit()
+
+	hold, err := repo.CaptureHold(context.Background(), 3, 1, 250, models.CaptureWithdraw)
+	assert.NoError(t, err)
+	assert.Equal(t, models.HoldCaptured, hold.Status)
+	assert.Equal(t, 60, *hold.CaptureTransactionID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCaptureHold_FeeComesOutOfCapturedAmount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	// A flat 10 fee: capturing the whole 400 pays out 390 and the fee, and
+	// takes nothing beyond the hold from the 0 left withdrawable.
+	expectFlatFee := func() {
+		mock.ExpectQuery("FROM fee_rules WHERE operation = \\$1").
+			WithArgs(models.FeeWithdraw).
+			WillReturnRows(sqlmock.NewRows([]string{"min_amount", "flat", "bps", "min_fee", "max_fee"}).
+				AddRow(0, 10, 0, nil, nil))
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FROM holds WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(3, 1, 400, 0, models.HoldActive, "hold-key-1", expiresAt, nil, time.Now(), nil))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(0), int64(400), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectFlatFee()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(400))
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(1, "hold:3").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM withdrawal_limit_tiers").
+		WithArgs(1, models.DefaultLimitTier).
+		WillReturnError(sql.ErrNoRows)
+	expectFlatFee()
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, int64(-390), "withdraw", "pending", sqlmock.AnyArg(), nil, nil, "hold:3", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(60))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-390), int64(-390), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "withdrawal", 60)
+	mock.ExpectQuery("INSERT INTO transactions .*'fee'").
+		WithArgs(1, int64(-10), models.StatusCompleted, "withdraw:hold:3", 60).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(61))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-10), int64(-10), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "fee", 61)
+	mock.ExpectExec("INSERT INTO withdrawal_jobs").
+		WithArgs(60, 1, int64(390), "hold:3", models.JobStatusQueued).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("UPDATE holds SET status").
+		WithArgs(models.HoldCaptured, int64(400), 60, sqlmock.AnyArg(), 3).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(3, 1, 400, 400, models.HoldCaptured, "hold-key-1", expiresAt, 60, time.Now(), time.Now()))
+	mock.ExpectCommit()
+
+	hold, err := repo.CaptureHold(context.Background(), 3, 1, 0, models.CaptureWithdraw)
+	assert.NoError(t, err)
+	assert.Equal(t, models.HoldCaptured, hold.Status)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCaptureHold_IntoDebit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	expiresAt := time.Now().Add(time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FROM holds WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(3, 1, 400, 0, models.HoldActive, "hold-key-1", expiresAt, nil, time.Now(), nil))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(0), int64(400), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1000))
+	mock.ExpectQuery("SELECT 1 FROM transactions WHERE user_id = \\$1 AND type = 'debit'").
+		WithArgs(1, "hold:3").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, int64(-400), "debit", "completed", sqlmock.AnyArg(), nil, nil, "hold:3", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(61))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-400), int64(-400), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "debit", 61)
+	mock.ExpectQuery("UPDATE holds SET status").
+		WithArgs(models.HoldCaptured, int64(400), 61, sqlmock.AnyArg(), 3).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(3, 1, 400, 400, models.HoldCaptured, "hold-key-1", expiresAt, 61, time.Now(), time.Now()))
+	mock.ExpectCommit()
+
+	hold, err := repo.CaptureHold(context.Background(), 3, 1, 0, models.CaptureDebit)
+	assert.NoError(t, err)
+	assert.Equal(t, models.HoldCaptured, hold.Status)
+	assert.Equal(t, 61, *hold.CaptureTransactionID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCaptureHold_ExpiredHold(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FROM holds WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(3, 1, 400, 0, models.HoldActive, "hold-key-1", time.Now().Add(-time.Minute), nil, time.Now(), nil))
+	mock.ExpectRollback()
+
+	_, err = repo.CaptureHold(context.Background(), 3, 1, 0, models.CaptureWithdraw)
+	assert.Equal(t, models.ErrHoldNotActive, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRefundCharge_TakesLockedFundsFirst(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	releaseAt := time.Now().Add(24 * time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT type, amount, release_at, released_at FROM transactions").
+		WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "amount", "release_at", "released_at"}).AddRow("charge", 1000, releaseAt, nil))
+	mock.ExpectQuery("SELECT 1 FROM transactions WHERE user_id = \\$1 AND type = 'refund'").
+		WithArgs(1, "refund-key-1").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(-amount\\), 0\\)").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(0))
+	mock.ExpectExec("INSERT INTO charge_releases").
+		WithArgs(9, 1, int64(1000), releaseAt).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectExec("UPDATE transactions SET released_at").
+		WithArgs(sqlmock.AnyArg(), 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, amount FROM charge_releases").
+		WithArgs(9, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount"}).AddRow(5, 1000))
+	mock.ExpectExec("UPDATE charge_releases SET amount").
+		WithArgs(int64(600), nil, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO transactions .*'refund'").
+		WithArgs(1, int64(-400), models.StatusCompleted, sqlmock.AnyArg(), "refund-key-1", 9).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-400), int64(0), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "refund", 11)
+	mock.ExpectCommit()
+
+	refund, err := repo.RefundCharge(context.Background(), 9, 1, 400, "refund-key-1", false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(400), refund.FromLocked)
+	assert.Equal(t, int64(0), refund.FromWithdrawable)
+	assert.Equal(t, int64(600), refund.Remaining)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRefundCharge_FullRefundReturnsChargeFee(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	releaseAt := time.Now().Add(24 * time.Hour)
+
+	// A held charge of 1000 that paid a 30 fee out of its locked funds,
+	// refunded in full by a user with nothing withdrawable.
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT type, amount, release_at, released_at FROM transactions").
+		WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "amount", "release_at", "released_at"}).AddRow("charge", 1000, releaseAt, time.Now()))
+	mock.ExpectQuery("SELECT 1 FROM transactions WHERE user_id = \\$1 AND type = 'refund'").
+		WithArgs(1, "refund-key-3").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(-amount\\), 0\\)").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(0))
+	mock.ExpectQuery("SELECT id, amount FROM charge_releases").
+		WithArgs(9, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount"}).AddRow(5, 970))
+	mock.ExpectExec("UPDATE charge_releases SET amount").
+		WithArgs(int64(0), sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, -amount, idempotency_key FROM transactions WHERE type = 'fee'").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "idempotency_key"}).AddRow(10, 30, "charge:charge-key-457"))
+	mock.ExpectQuery("INSERT INTO transactions .*'fee_refund'").
+		WithArgs(1, int64(30), models.StatusCompleted, "charge:charge-key-457", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(30), int64(30), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "fee_refund", 12)
+	mock.ExpectQuery("INSERT INTO transactions .*'refund'").
+		WithArgs(1, int64(-1000), models.StatusCompleted, sqlmock.AnyArg(), "refund-key-3", 9).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(13))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-1000), int64(-30), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE transactions SET status").
+		WithArgs(models.StatusRefunded, sqlmock.AnyArg(), 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "refund", 13)
+	mock.ExpectCommit()
+
+	refund, err := repo.RefundCharge(context.Background(), 9, 1, 0, "refund-key-3", false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(970), refund.FromLocked)
+	assert.Equal(t, int64(30), refund.FromWithdrawable)
+	assert.Equal(t, int64(30), refund.FeeRefunded)
+	assert.Equal(t, int64(0), refund.Remaining)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRefundCharge_RejectsOverdraft(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	chargedAt := time.Now().Add(-time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT type, amount, release_at, released_at FROM transactions").
+		WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "amount", "release_at", "released_at"}).AddRow("charge", 1000, nil, chargedAt))
+	mock.ExpectQuery("SELECT 1 FROM transactions WHERE user_id = \\$1 AND type = 'refund'").
+		WithArgs(1, "refund-key-2").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(-amount\\), 0\\)").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(200))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(300))
+	mock.ExpectQuery("SELECT id, amount FROM charge_releases").
+		WithArgs(9, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount"}))
+	expectNoFeeRefund(mock, 9)
+	mock.ExpectRollback()
+
+	// A zero amount refunds the 800 left on the charge.
+	_, err = repo.RefundCharge(context.Background(), 9, 1, 0, "refund-key-2", false)
+	assert.Equal(t, models.ErrRefundOverdraft, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+var instructionRowColumns = []string{"id", "user_id", "schedule", "mode", "amount", "min_threshold", "status", "next_run_at", "last_run_at", "limit_rejections", "created_at", "updated_at"}
+
+func TestRunDueStandingInstructions_SweepsBalance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	scheduledAt := time.Now().Add(-time.Minute).Truncate(time.Minute)
+	key := fmt.Sprintf("standing:4:%d", scheduledAt.Unix())
+
+	mock.ExpectQuery("SELECT id, user_id FROM standing_instructions").
+		WithArgs(models.InstructionActive, sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(4, 1))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM standing_instructions WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(4, 1).
+		WillReturnRows(sqlmock.NewRows(instructionRowColumns).
+			AddRow(4, 1, "0 9 * * 1", models.InstructionSweep, 0, 100, models.InstructionActive, scheduledAt, nil, 0, time.Now(), nil))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(700))
+	expectNoFee(mock, models.FeeWithdraw)
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(700))
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(1, key).
+		WillReturnError(sql.ErrNoRows)
+	expectWithdrawalChecks(mock, 1)
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, int64(-700), "withdraw", "pending", sqlmock.AnyArg(), nil, nil, key, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(70))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-700), int64(-700), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "withdrawal", 70)
+	mock.ExpectExec("INSERT INTO withdrawal_jobs").
+		WithArgs(70, 1, int64(700), key, models.JobStatusQueued).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("INSERT INTO standing_instruction_runs").
+		WithArgs(4, 70, int64(700), models.RunWithdrawn, "", scheduledAt, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("UPDATE standing_instructions\\s+SET next_run_at").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), models.InstructionActive, 0, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	withdrawn, err := repo.RunDueStandingInstructions(context.Background(), 10, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, withdrawn)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunDueStandingInstructions_SweepLeavesRoomForTieredFee(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	scheduledAt := time.Now().Add(-time.Minute).Truncate(time.Minute)
+	key := fmt.Sprintf("standing:4:%d", scheduledAt.Unix())
+
+	// 1005 falls in the cheaper tier, but 1005 minus its fee does not: the
+	// sweep has to drop below 1000 and pay the higher flat fee.
+	feeRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"min_amount", "flat", "bps", "min_fee", "max_fee"}).
+			AddRow(0, 50, 0, nil, nil).
+			AddRow(1000, 10, 0, nil, nil)
+	}
+
+	mock.ExpectQuery("SELECT id, user_id FROM standing_instructions").
+		WithArgs(models.InstructionActive, sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(4, 1))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM standing_instructions WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(4, 1).
+		WillReturnRows(sqlmock.NewRows(instructionRowColumns).
+			AddRow(4, 1, "0 9 * * 1", models.InstructionSweep, 0, 100, models.InstructionActive, scheduledAt, nil, 0, time.Now(), nil))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1005))
+	mock.ExpectQuery("FROM fee_rules WHERE operation = \\$1").
+		WithArgs(models.FeeWithdraw).
+		WillReturnRows(feeRows())
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1005))
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(1, key).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM withdrawal_limit_tiers").
+		WithArgs(1, models.DefaultLimitTier).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM fee_rules WHERE operation = \\$1").
+		WithArgs(models.FeeWithdraw).
+		WillReturnRows(feeRows())
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, int64(-955), "withdraw", "pending", sqlmock.AnyArg(), nil, nil, key, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(70))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-955), int64(-955), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "withdrawal", 70)
+	mock.ExpectQuery("INSERT INTO transactions .*'fee'").
+		WithArgs(1, int64(-50), models.StatusCompleted, "withdraw:"+key, 70).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(71))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-50), int64(-50), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "fee", 71)
+	mock.ExpectExec("INSERT INTO withdrawal_jobs").
+		WithArgs(70, 1, int64(955), key, models.JobStatusQueued).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("INSERT INTO standing_instruction_runs").
+		WithArgs(4, 70, int64(955), models.RunWithdrawn, "", scheduledAt, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("UPDATE standing_instructions\\s+SET next_run_at").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), models.InstructionActive, 0, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	withdrawn, err := repo.RunDueStandingInstructions(context.Background(), 10, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, withdrawn)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunDueStandingInstructions_SkipsBelowThreshold(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	scheduledAt := time.Now().Add(-time.Minute).Truncate(time.Minute)
+
+	mock.ExpectQuery("SELECT id, user_id FROM standing_instructions").
+		WithArgs(models.InstructionActive, sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(5, 1).AddRow(6, 2))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM standing_instructions WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows(instructionRowColumns).
+			AddRow(5, 1, "0 0 1 * *", models.InstructionFixed, 500, 1000, models.InstructionActive, scheduledAt, nil, 0, time.Now(), nil))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(800))
+	mock.ExpectQuery("INSERT INTO standing_instruction_runs").
+		WithArgs(5, nil, int64(500), models.RunSkipped, sqlmock.AnyArg(), scheduledAt, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectExec("UPDATE standing_instructions\\s+SET next_run_at").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), models.InstructionActive, 0, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Paused after it was listed
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM standing_instructions WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(6, 2).
+		WillReturnRows(sqlmock.NewRows(instructionRowColumns).
+			AddRow(6, 2, "0 0 1 * *", models.InstructionFixed, 500, 0, models.InstructionPaused, nil, nil, 0, time.Now(), time.Now()))
+	mock.ExpectCommit()
+
+	withdrawn, err := repo.RunDueStandingInstructions(context.Background(), 10, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, withdrawn)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunDueStandingInstructions_FailureDoesNotStopBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	scheduledAt := time.Now().Add(-time.Minute).Truncate(time.Minute)
+	instructionRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(instructionRowColumns).
+			AddRow(7, 1, "0 0 1 * *", models.InstructionFixed, 500, 0, models.InstructionActive, scheduledAt, nil, 0, time.Now(), nil)
+	}
+
+	mock.ExpectQuery("SELECT id, user_id FROM standing_instructions").
+		WithArgs(models.InstructionActive, sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(7, 1).AddRow(5, 2))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM standing_instructions WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(7, 1).
+		WillReturnRows(instructionRows())
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	// The failure is recorded on instruction 7 alone, which moves on to its
+	// next run
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM standing_instructions WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(7, 1).
+		WillReturnRows(instructionRows())
+	mock.ExpectQuery("INSERT INTO standing_instruction_runs").
+		WithArgs(7, nil, int64(500), models.RunFailed, sql.ErrConnDone.Error(), scheduledAt, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec("UPDATE standing_instructions SET next_run_at").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), models.InstructionActive, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM standing_instructions WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(5, 2).
+		WillReturnRows(sqlmock.NewRows(instructionRowColumns).
+			AddRow(5, 2, "0 0 1 * *", models.InstructionFixed, 500, 1000, models.InstructionActive, scheduledAt, nil, 0, time.Now(), nil))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(2, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(800))
+	mock.ExpectQuery("INSERT INTO standing_instruction_runs").
+		WithArgs(5, nil, int64(500), models.RunSkipped, sqlmock.AnyArg(), scheduledAt, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectExec("UPDATE standing_instructions\\s+SET next_run_at").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), models.InstructionActive, 0, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	withdrawn, err := repo.RunDueStandingInstructions(context.Background(), 10, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, withdrawn)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunDueStandingInstructions_PausesAfterRepeatedLimitRejections(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	scheduledAt := time.Now().Add(-time.Minute).Truncate(time.Minute)
+	key := fmt.Sprintf("standing:8:%d", scheduledAt.Unix())
+
+	mock.ExpectQuery("SELECT id, user_id FROM standing_instructions").
+		WithArgs(models.InstructionActive, sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(8, 1))
+
+	// Refused twice before, so this third refusal pauses it
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM standing_instructions WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(8, 1).
+		WillReturnRows(sqlmock.NewRows(instructionRowColumns).
+			AddRow(8, 1, "0 0 1 * *", models.InstructionFixed, 500, 0, models.InstructionActive, scheduledAt, nil, 2, time.Now(), nil))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(5000))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(5000))
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(1, key).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM withdrawal_limit_tiers").
+		WithArgs(1, models.DefaultLimitTier).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "per_transaction", "daily_amount", "monthly_amount", "daily_count"}).
+			AddRow("standard", 100, nil, nil, nil))
+	mock.ExpectQuery("INSERT INTO standing_instruction_runs").
+		WithArgs(8, nil, int64(500), models.RunFailed, sqlmock.AnyArg(), scheduledAt, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec("UPDATE standing_instructions\\s+SET next_run_at").
+		WithArgs(nil, sqlmock.AnyArg(), models.InstructionPaused, 3, 8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	withdrawn, err := repo.RunDueStandingInstructions(context.Background(), 10, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, withdrawn)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWithdraw_DailyLimitExceeded(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(5000))
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(1, "withdraw-key-limit").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM withdrawal_limit_tiers").
+		WithArgs(1, models.DefaultLimitTier).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "per_transaction", "daily_amount", "monthly_amount", "daily_count"}).
+			AddRow("standard", 1000, 1000, nil, nil))
+	mock.ExpectQuery("FROM transactions\\s+WHERE user_id = \\$1 AND type = 'withdraw'").
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), models.StatusFailed, models.StatusCancelled, models.StatusReversed).
+		WillReturnRows(sqlmock.NewRows([]string{"daily_amount", "daily_count", "monthly_amount"}).AddRow(800, 2, 800))
+	mock.ExpectRollback()
+
+	err = repo.Withdraw(context.Background(), 1, 300, "withdraw-key-limit")
+	assert.Equal(t, models.ErrDailyLimit, err)
+	assert.ErrorIs(t, err, models.ErrWithdrawalLimitExceeded)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWithdraw_BooksFee(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT withdrawable FROM accounts").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"withdrawable"}).AddRow(1030))
+	mock.ExpectQuery("SELECT 1 FROM transactions").
+		WithArgs(1, "withdraw-key-fee").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM withdrawal_limit_tiers").
+		WithArgs(1, models.DefaultLimitTier).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM fee_rules WHERE operation = \\$1").
+		WithArgs(models.FeeWithdraw).
+		WillReturnRows(sqlmock.NewRows([]string{"min_amount", "flat", "bps", "min_fee", "max_fee"}).
+			AddRow(0, 10, 200, nil, nil))
+	mock.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, int64(-1000), "withdraw", "pending", sqlmock.AnyArg(), nil, nil, "withdraw-key-fee", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(80))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-1000), int64(-1000), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "withdrawal", 80)
+	mock.ExpectQuery("INSERT INTO transactions .*'fee'").
+		WithArgs(1, int64(-30), models.StatusCompleted, "withdraw:withdraw-key-fee", 80).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(81))
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(1, int64(-30), int64(-30), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectJournal(mock, "fee", 81)
+	mock.ExpectExec("INSERT INTO withdrawal_jobs").
+		WithArgs(80, 1, int64(1000), "withdraw-key-fee", models.JobStatusQueued).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = repo.Withdraw(context.Background(), 1, 1000, "withdraw-key-fee")
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
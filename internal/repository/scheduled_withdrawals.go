@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+	"wallet-simulator/internal/models"
+)
+
+// ScheduleWithdrawal books a withdrawal to be paid out at executeAt. The
+// funds are reserved straight away, exactly as for Withdraw, but the payout
+// job stays scheduled until PromoteScheduledWithdrawals queues it.
+func (r *Repository) ScheduleWithdrawal(ctx context.Context, userID int, amount int64, executeAt time.Time, idempotencyKey string) error {
+	return r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		_, err := r.withdraw(tx, userID, amount, idempotencyKey, &executeAt)
+		return err
+	})
+}
+
+// RescheduleWithdrawal moves the payout of a withdrawal that is still
+// scheduled to executeAt.
+func (r *Repository) RescheduleWithdrawal(ctx context.Context, idempotencyKey string, userID int, executeAt time.Time) (*models.TransactionDetail, error) {
+	err := r.runTx(ctx, nil, func(tx *sql.Tx) error {
+		var id int
+		var status string
+		err := tx.QueryRowContext(ctx, `
+			SELECT id, status FROM transactions
+			WHERE idempotency_key = $1 AND user_id = $2 AND type = 'withdraw' FOR UPDATE
+		`, idempotencyKey, userID).Scan(&id, &status)
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrTransactionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status != models.StatusScheduled {
+			return models.ErrWithdrawalNotScheduled
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE withdrawal_jobs SET run_at = $1, updated_at = NOW() WHERE transaction_id = $2 AND status = $3
+		`, executeAt, id, models.JobStatusScheduled)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE transactions SET updated_at = NOW() WHERE id = $1`, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("🗓️ Rescheduled withdrawal %s to %s", idempotencyKey, executeAt.Format(time.RFC3339))
+	return r.GetWithdrawal(idempotencyKey, userID)
+}
+
+// PromoteScheduledWithdrawals moves up to limit scheduled withdrawals whose
+// execute_at has passed to pending and queues their jobs for the workers.
+func (r *Repository) PromoteScheduledWithdrawals(ctx context.Context, limit int) (int, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT transaction_id FROM withdrawal_jobs WHERE status = $1 AND run_at <= $2 ORDER BY run_at, id LIMIT $3
+	`, models.JobStatusScheduled, time.Now(), limit)
+	if err != nil {
+		return 0, err
+	}
+	var due []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, id := range due {
+		promoted, err := r.promoteWithdrawal(ctx, id)
+		if err != nil {
+			return count, err
+		}
+		if promoted {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// promoteWithdrawal queues one scheduled withdrawal. It reports false when
+// the withdrawal was cancelled or rescheduled since it was listed. The
+// withdrawal row is locked before its job, in the same order as
+// CancelWithdrawal and RescheduleWithdrawal.
+func (r *Repository) promoteWithdrawal(ctx context.Context, transactionID int) (bool, error) {
+	promoted := false
+	err := r.runTx(ctx, nil, func(tx *sql.Tx) error {
+		var status string
+		err := tx.QueryRowContext(ctx, `SELECT status FROM transactions WHERE id = $1 FOR UPDATE`, transactionID).Scan(&status)
+		if err != nil {
+			return err
+		}
+		if status != models.StatusScheduled {
+			return nil
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			UPDATE withdrawal_jobs SET status = $1, run_at = NOW(), updated_at = NOW()
+			WHERE transaction_id = $2 AND status = $3 AND run_at <= $4
+		`, models.JobStatusQueued, transactionID, models.JobStatusScheduled, time.Now())
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE transactions SET status = $1, updated_at = NOW() WHERE id = $2`, models.StatusPending, transactionID)
+		if err != nil {
+			return err
+		}
+		promoted = true
+		return nil
+	})
+	return promoted, err
+}
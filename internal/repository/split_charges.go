@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"wallet-simulator/internal/ledger"
+	"wallet-simulator/internal/models"
+	"wallet-simulator/internal/split"
+)
+
+// SplitCharge books one incoming charge as a charge per recipient, all in a
+// single transaction. Amounts are allocated by split.Allocate, so the legs
+// always add up to amount and rounding remainders land on the same legs for
+// the same request. Each leg is held until its own release_at, and pays the
+// charge fee, if the schedule has one, on its own amount. The idempotency
+// key is unique per payer.
+func (r *Repository) SplitCharge(ctx context.Context, payerID int, amount int64, recipients []models.SplitRecipient, idempotencyKey string) (*models.SplitCharge, error) {
+	shares := make([]split.Share, len(recipients))
+	for i, rcpt := range recipients {
+		shares[i] = split.Share{Amount: rcpt.Amount, Bps: rcpt.ShareBps}
+	}
+	amounts, err := split.Allocate(amount, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	var sc *models.SplitCharge
+	err = r.runTx(ctx, nil, func(tx *sql.Tx) error {
+		var exists int
+		err := tx.QueryRow("SELECT 1 FROM split_charges WHERE payer_id = $1 AND idempotency_key = $2", payerID, idempotencyKey).Scan(&exists)
+		if err == nil {
+			return models.ErrDuplicateRequest
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		sc = &models.SplitCharge{
+			PayerID:        payerID,
+			Amount:         amount,
+			IdempotencyKey: idempotencyKey,
+			CreatedAt:      time.Now(),
+		}
+		err = tx.QueryRow(`
+			INSERT INTO split_charges (payer_id, amount, idempotency_key, created_at)
+			VALUES ($1, $2, $3, $4) RETURNING id
+		`, payerID, amount, idempotencyKey, sc.CreatedAt).Scan(&sc.ID)
+		if err != nil {
+			return err
+		}
+
+		userIDs := make([]int, len(recipients))
+		keys := make([]string, len(recipients))
+		for i, rcpt := range recipients {
+			leg := models.SplitLeg{
+				UserID:    rcpt.UserID,
+				Amount:    amounts[i],
+				ShareBps:  rcpt.ShareBps,
+				ReleaseAt: rcpt.ReleaseAt,
+			}
+			// Recipients did not choose the payer's key, so each leg is keyed
+			// by the split row, which is unique per payer and key, and its
+			// position instead.
+			keys[i] = fmt.Sprintf("split:%d:%d", sc.ID, i+1)
+			leg.TransactionID, err = r.CreateTransaction(tx, leg.UserID, leg.Amount, "charge", leg.ReleaseAt, keys[i])
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`
+				INSERT INTO split_charge_legs (split_charge_id, position, transaction_id, user_id, amount, share_bps)
+				VALUES ($1, $2, $3, $4, $5, $6)
+			`, sc.ID, i+1, leg.TransactionID, leg.UserID, leg.Amount, leg.ShareBps)
+			if err != nil {
+				return err
+			}
+			sc.Legs = append(sc.Legs, leg)
+			userIDs[i] = leg.UserID
+		}
+
+		if _, err := ledger.Post(tx, ledger.SplitCharge(sc.Legs[0].TransactionID, userIDs, amounts)); err != nil {
+			return err
+		}
+		for i, leg := range sc.Legs {
+			if err := chargeFee(ctx, tx, leg.UserID, leg.TransactionID, leg.Amount, keys[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
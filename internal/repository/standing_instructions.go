@@ -0,0 +1,372 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"wallet-simulator/internal/cron"
+	"wallet-simulator/internal/fees"
+	"wallet-simulator/internal/models"
+)
+
+const instructionColumns = `id, user_id, schedule, mode, amount, min_threshold, status, next_run_at, last_run_at, limit_rejections, created_at, updated_at`
+
+// CreateStandingInstruction stores an active instruction due at the first
+// time its schedule matches from now.
+func (r *Repository) CreateStandingInstruction(ctx context.Context, req models.StandingInstructionRequest) (*models.StandingInstruction, error) {
+	schedule, err := cron.Parse(req.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return scanInstruction(r.db.QueryRowContext(ctx, `
+		INSERT INTO standing_instructions (user_id, schedule, mode, amount, min_threshold, status, next_run_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING `+instructionColumns,
+		req.UserID, req.Schedule, req.Mode, req.Amount, req.MinThreshold, models.InstructionActive, schedule.Next(now), now))
+}
+
+// UpdateStandingInstruction replaces an instruction's schedule and payout.
+// Pausing it clears next_run_at; resuming or rescheduling computes it again
+// from now, so runs missed while paused are not made up. Either way the count
+// of limit rejections starts over.
+func (r *Repository) UpdateStandingInstruction(ctx context.Context, id int, req models.StandingInstructionRequest) (*models.StandingInstruction, error) {
+	schedule, err := cron.Parse(req.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	var si *models.StandingInstruction
+	err = r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		current, err := lockInstruction(ctx, tx, id, req.UserID)
+		if err != nil {
+			return err
+		}
+		if current.Status == models.InstructionCancelled {
+			return models.ErrInstructionCancelled
+		}
+
+		now := time.Now()
+		var nextRunAt *time.Time
+		if req.Status == models.InstructionActive {
+			next := schedule.Next(now)
+			nextRunAt = &next
+		}
+		si, err = scanInstruction(tx.QueryRowContext(ctx, `
+			UPDATE standing_instructions
+			SET schedule = $1, mode = $2, amount = $3, min_threshold = $4, status = $5, next_run_at = $6, limit_rejections = 0, updated_at = $7
+			WHERE id = $8 RETURNING `+instructionColumns,
+			req.Schedule, req.Mode, req.Amount, req.MinThreshold, req.Status, nextRunAt, now, id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+// CancelStandingInstruction stops an instruction for good. It stays listed
+// with its run history.
+func (r *Repository) CancelStandingInstruction(ctx context.Context, id, userID int) (*models.StandingInstruction, error) {
+	var si *models.StandingInstruction
+	err := r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		current, err := lockInstruction(ctx, tx, id, userID)
+		if err != nil {
+			return err
+		}
+		if current.Status == models.InstructionCancelled {
+			return models.ErrInstructionCancelled
+		}
+		si, err = scanInstruction(tx.QueryRowContext(ctx, `
+			UPDATE standing_instructions SET status = $1, next_run_at = NULL, updated_at = $2
+			WHERE id = $3 RETURNING `+instructionColumns,
+			models.InstructionCancelled, time.Now(), id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+func (r *Repository) GetStandingInstruction(id, userID int) (*models.StandingInstruction, error) {
+	si, err := scanInstruction(r.db.QueryRow(`SELECT `+instructionColumns+` FROM standing_instructions WHERE id = $1 AND user_id = $2`, id, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrInstructionNotFound
+	}
+	return si, err
+}
+
+func (r *Repository) ListStandingInstructions(userID int) ([]models.StandingInstruction, error) {
+	rows, err := r.db.Query(`SELECT `+instructionColumns+` FROM standing_instructions WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	instructions := []models.StandingInstruction{}
+	for rows.Next() {
+		si, err := scanInstruction(rows)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, *si)
+	}
+	return instructions, rows.Err()
+}
+
+// GetStandingInstructionRuns returns an instruction's run history, newest
+// first.
+func (r *Repository) GetStandingInstructionRuns(id, userID int) ([]models.StandingInstructionRun, error) {
+	if _, err := r.GetStandingInstruction(id, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, instruction_id, transaction_id, amount, status, reason, scheduled_at, created_at
+		FROM standing_instruction_runs WHERE instruction_id = $1 ORDER BY created_at DESC, id DESC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []models.StandingInstructionRun{}
+	for rows.Next() {
+		var run models.StandingInstructionRun
+		var transactionID sql.NullInt64
+		var reason sql.NullString
+		if err := rows.Scan(&run.ID, &run.InstructionID, &transactionID, &run.Amount, &run.Status, &reason, &run.ScheduledAt, &run.CreatedAt); err != nil {
+			return nil, err
+		}
+		if transactionID.Valid {
+			txID := int(transactionID.Int64)
+			run.TransactionID = &txID
+		}
+		run.Reason = reason.String
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// RunDueStandingInstructions runs up to limit active instructions whose
+// next_run_at has passed and returns how many of them withdrew. Runs missed
+// while the scheduler was down are made up by a single run. An instruction
+// that cannot be run gets a failed run and is moved on without holding up
+// the rest of the batch; one refused by withdrawal limits pauseAfter times
+// in a row is paused.
+func (r *Repository) RunDueStandingInstructions(ctx context.Context, limit, pauseAfter int) (int, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id FROM standing_instructions
+		WHERE status = $1 AND next_run_at <= $2 ORDER BY next_run_at LIMIT $3
+	`, models.InstructionActive, time.Now(), limit)
+	if err != nil {
+		return 0, err
+	}
+	type due struct{ id, userID int }
+	var instructions []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.userID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		instructions = append(instructions, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, d := range instructions {
+		run, err := r.runInstruction(ctx, d.id, d.userID, pauseAfter)
+		if err != nil {
+			if ctx.Err() != nil {
+				return count, ctx.Err()
+			}
+			log.Printf("⚠️ Standing instruction %d failed: %v", d.id, err)
+			if err := r.failInstructionRun(ctx, d.id, d.userID, err); err != nil {
+				return count, err
+			}
+			continue
+		}
+		if run != nil && run.Status == models.RunWithdrawn {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// runInstruction turns one due instruction into a withdrawal, or records
+// why it could not, and moves it on to its next run. It returns nil when the
+// instruction was paused, cancelled or already run since it was listed.
+func (r *Repository) runInstruction(ctx context.Context, id, userID, pauseAfter int) (*models.StandingInstructionRun, error) {
+	var run *models.StandingInstructionRun
+	err := r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		run = nil
+
+		si, err := lockInstruction(ctx, tx, id, userID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if si.Status != models.InstructionActive || si.NextRunAt == nil || si.NextRunAt.After(now) {
+			return nil
+		}
+		schedule, err := cron.Parse(si.Schedule)
+		if err != nil {
+			return err
+		}
+
+		if err := lockUser(tx, userID); err != nil {
+			return err
+		}
+		withdrawable, err := withdrawableBalance(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		run = &models.StandingInstructionRun{
+			InstructionID: id,
+			Amount:        si.Amount,
+			ScheduledAt:   *si.NextRunAt,
+			CreatedAt:     now,
+		}
+		if si.Mode == models.InstructionSweep {
+			// Leave room for the withdrawal fee, which comes on top.
+			rules, err := feeRules(tx, models.FeeWithdraw)
+			if err != nil {
+				return err
+			}
+			run.Amount = fees.MaxAmount(rules, withdrawable)
+		}
+
+		rejections := 0
+		switch {
+		case withdrawable < si.MinThreshold:
+			run.Status = models.RunSkipped
+			run.Reason = fmt.Sprintf("withdrawable balance %d is below the threshold of %d", withdrawable, si.MinThreshold)
+		case run.Amount <= 0:
+			run.Status = models.RunSkipped
+			run.Reason = "nothing to withdraw"
+		default:
+			// Keyed by the scheduled time, so each run withdraws at most once.
+			key := fmt.Sprintf("standing:%d:%d", id, run.ScheduledAt.Unix())
+			txID, err := r.withdraw(tx, userID, run.Amount, key, nil)
+			switch {
+			case err == nil:
+				run.Status = models.RunWithdrawn
+				run.TransactionID = &txID
+			case errors.Is(err, models.ErrWithdrawalLimitExceeded):
+				run.Status = models.RunFailed
+				run.Reason = err.Error()
+				rejections = si.LimitRejections + 1
+			case errors.Is(err, models.ErrInsufficientBalance), errors.Is(err, models.ErrDuplicateRequest):
+				run.Status = models.RunFailed
+				run.Reason = err.Error()
+			default:
+				return err
+			}
+		}
+
+		next := schedule.Next(now)
+		status, nextRunAt := models.InstructionActive, &next
+		if pauseAfter > 0 && rejections >= pauseAfter {
+			status, nextRunAt = models.InstructionPaused, nil
+			run.Reason = fmt.Sprintf("%s; paused after %d limit rejections in a row", run.Reason, rejections)
+			log.Printf("⏸️ Paused standing instruction %d after %d limit rejections", id, rejections)
+		}
+
+		if err := insertInstructionRun(ctx, tx, run); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE standing_instructions
+			SET next_run_at = $1, last_run_at = $2, updated_at = $2, status = $3, limit_rejections = $4
+			WHERE id = $5
+		`, nextRunAt, now, status, rejections, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// failInstructionRun records a failed run for an instruction whose run
+// errored and moves it on to its next run, so it is not picked up again
+// straight away. An instruction whose schedule no longer parses is paused.
+func (r *Repository) failInstructionRun(ctx context.Context, id, userID int, cause error) error {
+	return r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		si, err := lockInstruction(ctx, tx, id, userID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if si.Status != models.InstructionActive || si.NextRunAt == nil || si.NextRunAt.After(now) {
+			return nil
+		}
+
+		status := models.InstructionActive
+		var nextRunAt *time.Time
+		if schedule, err := cron.Parse(si.Schedule); err == nil {
+			next := schedule.Next(now)
+			nextRunAt = &next
+		} else {
+			status = models.InstructionPaused
+		}
+
+		run := &models.StandingInstructionRun{
+			InstructionID: id,
+			Amount:        si.Amount,
+			Status:        models.RunFailed,
+			Reason:        cause.Error(),
+			ScheduledAt:   *si.NextRunAt,
+			CreatedAt:     now,
+		}
+		if err := insertInstructionRun(ctx, tx, run); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE standing_instructions SET next_run_at = $1, last_run_at = $2, updated_at = $2, status = $3 WHERE id = $4
+		`, nextRunAt, now, status, id)
+		return err
+	})
+}
+
+func insertInstructionRun(ctx context.Context, tx *sql.Tx, run *models.StandingInstructionRun) error {
+	return tx.QueryRowContext(ctx, `
+		INSERT INTO standing_instruction_runs (instruction_id, transaction_id, amount, status, reason, scheduled_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
+	`, run.InstructionID, run.TransactionID, run.Amount, run.Status, run.Reason, run.ScheduledAt, run.CreatedAt).Scan(&run.ID)
+}
+
+func lockInstruction(ctx context.Context, tx *sql.Tx, id, userID int) (*models.StandingInstruction, error) {
+	si, err := scanInstruction(tx.QueryRowContext(ctx, `SELECT `+instructionColumns+` FROM standing_instructions WHERE id = $1 AND user_id = $2 FOR UPDATE`, id, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrInstructionNotFound
+	}
+	return si, err
+}
+
+func scanInstruction(row rowScanner) (*models.StandingInstruction, error) {
+	var si models.StandingInstruction
+	var nextRunAt, lastRunAt, updatedAt sql.NullTime
+	err := row.Scan(&si.ID, &si.UserID, &si.Schedule, &si.Mode, &si.Amount, &si.MinThreshold, &si.Status,
+		&nextRunAt, &lastRunAt, &si.LimitRejections, &si.CreatedAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if nextRunAt.Valid {
+		si.NextRunAt = &nextRunAt.Time
+	}
+	if lastRunAt.Valid {
+		si.LastRunAt = &lastRunAt.Time
+	}
+	if updatedAt.Valid {
+		si.UpdatedAt = &updatedAt.Time
+	}
+	return &si, nil
+}
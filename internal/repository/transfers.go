@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"wallet-simulator/internal/ledger"
+	"wallet-simulator/internal/models"
+)
+
+// Transfer moves amount from the sender's withdrawable balance to the
+// receiver in one transaction. Both users are locked in ascending ID order,
+// so two opposite transfers between the same pair cannot deadlock. The
+// receiver's leg is held until releaseAt when one is given.
+func (r *Repository) Transfer(ctx context.Context, senderID, receiverID int, amount int64, releaseAt *time.Time, idempotencyKey string) (*models.Transfer, error) {
+	var t *models.Transfer
+	err := r.inLockedTx(ctx, func(tx *sql.Tx) error {
+		first, second := senderID, receiverID
+		if second < first {
+			first, second = second, first
+		}
+		if err := lockUser(tx, first); err != nil {
+			return err
+		}
+		if err := lockUser(tx, second); err != nil {
+			return err
+		}
+
+		var exists int
+		err := tx.QueryRow("SELECT 1 FROM transfers WHERE sender_id = $1 AND idempotency_key = $2", senderID, idempotencyKey).Scan(&exists)
+		if err == nil {
+			return models.ErrDuplicateRequest
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		withdrawable, err := withdrawableBalance(tx, senderID)
+		if err != nil {
+			return err
+		}
+		if withdrawable < amount {
+			return models.ErrInsufficientBalance
+		}
+
+		t = &models.Transfer{
+			SenderID:       senderID,
+			ReceiverID:     receiverID,
+			Amount:         amount,
+			ReleaseAt:      releaseAt,
+			IdempotencyKey: idempotencyKey,
+			CreatedAt:      time.Now(),
+		}
+		err = tx.QueryRow(`
+			INSERT INTO transfers (sender_id, receiver_id, amount, release_at, idempotency_key, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
+		`, senderID, receiverID, amount, releaseAt, idempotencyKey, t.CreatedAt).Scan(&t.ID)
+		if err != nil {
+			return err
+		}
+
+		t.OutTransactionID, err = r.createTransaction(tx, senderID, -amount, "transfer_out", nil, idempotencyKey, &t.ID)
+		if err != nil {
+			return err
+		}
+		// The receiver did not choose the sender's key, so their leg is keyed
+		// by the transfer instead.
+		t.InTransactionID, err = r.createTransaction(tx, receiverID, amount, "transfer_in", releaseAt, fmt.Sprintf("transfer:%d", t.ID), &t.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = ledger.Post(tx, ledger.Transfer(t.OutTransactionID, senderID, receiverID, amount))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
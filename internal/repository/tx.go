@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxTxRetries bounds how often a transaction is re-run after Postgres
+// aborts it with a serialization failure or deadlock.
+const maxTxRetries = 5
+
+// userLockNamespace is the first key of the per-user advisory locks, so they
+// cannot collide with advisory locks taken for anything else.
+const userLockNamespace = 1
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// inLockedTx runs fn in a READ COMMITTED transaction and commits it,
+// re-running the whole transaction when it fails with SQLSTATE 40001
+// (serialization failure) or 40P01 (deadlock). Balance checks rely on
+// lockUser rather than SERIALIZABLE isolation: a serializable snapshot is
+// taken by the first statement, before the lock is granted, so every waiter
+// would read a stale balance and abort.
+func (r *Repository) inLockedTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := r.runTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted}, fn)
+		if !isRetryableTxError(err) || attempt >= maxTxRetries {
+			return err
+		}
+
+		backoff := time.Duration(rand.Int63n(int64(attempt) * int64(10*time.Millisecond)))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (r *Repository) runTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func isRetryableTxError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == "40001" || pqErr.Code == "40P01")
+}
+
+// lockUser serializes balance-changing transactions of one user until tx
+// ends. Under READ COMMITTED every later statement in tx sees what the
+// previous lock holder committed.
+func lockUser(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1, $2)", userLockNamespace, userID)
+	return err
+}
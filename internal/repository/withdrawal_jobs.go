@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"wallet-simulator/internal/models"
+)
+
+func (r *Repository) EnqueueWithdrawalJob(tx *sql.Tx, transactionID, userID int, amount int64, idempotencyKey string) error {
+	_, err := tx.Exec(`
+		INSERT INTO withdrawal_jobs (transaction_id, user_id, amount, idempotency_key, status)
+		VALUES ($1, $2, $3, $4, $5)
+	`, transactionID, userID, amount, idempotencyKey, models.JobStatusQueued)
+	return err
+}
+
+// ScheduleWithdrawalJob adds the job of a scheduled withdrawal. It is not
+// claimable until PromoteScheduledWithdrawals queues it at runAt.
+func (r *Repository) ScheduleWithdrawalJob(tx *sql.Tx, transactionID, userID int, amount int64, idempotencyKey string, runAt time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO withdrawal_jobs (transaction_id, user_id, amount, idempotency_key, status, run_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, transactionID, userID, amount, idempotencyKey, models.JobStatusScheduled, runAt)
+	return err
+}
+
+// ClaimWithdrawalJob locks the oldest runnable job with SKIP LOCKED so that
+// concurrent workers, in this process or another replica, never pick the same
+// row. A claimed job is leased for the given duration; if the worker dies the
+// lease expires and the job becomes claimable again. Returns nil when there
+// is nothing to run.
+func (r *Repository) ClaimWithdrawalJob(ctx context.Context, lease time.Duration) (*models.WithdrawalJob, error) {
+	query := `
+		UPDATE withdrawal_jobs
+		SET status = $1, attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $2), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM withdrawal_jobs
+			WHERE (status = $3 AND run_at <= NOW()) OR (status = $1 AND locked_until < NOW())
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, transaction_id, user_id, amount, idempotency_key, attempts
+	`
+	var job models.WithdrawalJob
+	err := r.db.QueryRowContext(ctx, query, models.JobStatusRunning, lease.Seconds(), models.JobStatusQueued).
+		Scan(&job.ID, &job.TransactionID, &job.UserID, &job.Amount, &job.IdempotencyKey, &job.Attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RecordPayoutAttempt counts one call to the bank and keeps its error, if
+// any, so status lookups can report progress while the job is still running.
+func (r *Repository) RecordPayoutAttempt(ctx context.Context, jobID int, payoutErr error) error {
+	var errMsg sql.NullString
+	if payoutErr != nil {
+		errMsg = sql.NullString{String: payoutErr.Error(), Valid: true}
+	}
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE withdrawal_jobs SET payout_attempts = payout_attempts + 1, last_error = COALESCE($1, last_error), updated_at = NOW()
+		WHERE id = $2
+	`, errMsg, jobID)
+	return err
+}
+
+// FinishWithdrawalJob releases the lease and records the final job status
+// along with the bank's payout reference, if any.
+func (r *Repository) FinishWithdrawalJob(ctx context.Context, jobID int, status, bankReference string, lastErr error) error {
+	var errMsg sql.NullString
+	if lastErr != nil {
+		errMsg = sql.NullString{String: lastErr.Error(), Valid: true}
+	}
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE withdrawal_jobs
+		SET status = $1, last_error = $2, bank_reference = COALESCE(NULLIF($3, ''), bank_reference), locked_until = NULL, updated_at = NOW()
+		WHERE id = $4
+	`, status, errMsg, bankReference, jobID)
+	return err
+}
+
+// RecoverPendingWithdrawals is run once at startup. Withdrawals pending for
+// longer than reviewAfter, counting a scheduled one from when it was queued,
+// are parked in manual_review; the remaining pending or processing ones
+// older than minAge get their job re-queued when it is missing or already
+// closed, which covers rows stranded by a crash, a shutdown timeout or
+// seeded data.
+func (r *Repository) RecoverPendingWithdrawals(ctx context.Context, minAge, reviewAfter time.Duration) (requeued int64, flagged int64, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, user_id, amount, idempotency_key FROM transactions
+		WHERE type = 'withdraw' AND status = $1 AND COALESCE(updated_at, created_at) < $2
+		ORDER BY id FOR UPDATE
+	`, models.StatusPending, now.Add(-reviewAfter))
+	if err != nil {
+		return 0, 0, err
+	}
+	type stale struct {
+		id, userID int
+		amount     int64
+		key        string
+	}
+	var stuck []stale
+	for rows.Next() {
+		var w stale
+		if err := rows.Scan(&w.id, &w.userID, &w.amount, &w.key); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		stuck = append(stuck, w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, w := range stuck {
+		if err := r.transitionWithdrawal(tx, w.id, w.userID, -w.amount, w.key, models.StatusPending, models.StatusManualReview); err != nil {
+			return 0, 0, err
+		}
+	}
+	flagged = int64(len(stuck))
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO withdrawal_jobs (transaction_id, user_id, amount, idempotency_key, status)
+		SELECT id, user_id, ABS(amount), idempotency_key, $1
+		FROM transactions
+		WHERE type = 'withdraw' AND status IN ('pending', 'processing') AND created_at < $2
+		ON CONFLICT (transaction_id) DO UPDATE
+		SET status = EXCLUDED.status, run_at = NOW(), locked_until = NULL, updated_at = NOW()
+		WHERE withdrawal_jobs.status IN ($3, $4)
+	`, models.JobStatusQueued, now.Add(-minAge), models.JobStatusDone, models.JobStatusFailed)
+	if err != nil {
+		return 0, 0, err
+	}
+	if requeued, err = result.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+
+	return requeued, flagged, tx.Commit()
+}
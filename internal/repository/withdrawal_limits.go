@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"wallet-simulator/internal/models"
+)
+
+// GetWithdrawalLimits returns the limits that apply to a user and how much of
+// them is used up.
+func (r *Repository) GetWithdrawalLimits(userID int) (*models.WithdrawalLimitsResponse, error) {
+	limits, err := withdrawalLimits(r.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := withdrawalUsage(r.db, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &models.WithdrawalLimitsResponse{Limits: *limits, Usage: *usage}, nil
+}
+
+// SetWithdrawalLimits moves a user to a tier and replaces their overrides.
+func (r *Repository) SetWithdrawalLimits(ctx context.Context, userID int, req models.WithdrawalLimitsRequest) (*models.WithdrawalLimits, error) {
+	var exists int
+	err := r.db.QueryRowContext(ctx, "SELECT 1 FROM withdrawal_limit_tiers WHERE name = $1", req.Tier).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrUnknownLimitTier
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO withdrawal_limits (user_id, tier, per_transaction, daily_amount, monthly_amount, daily_count, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id) DO UPDATE SET
+			tier = EXCLUDED.tier,
+			per_transaction = EXCLUDED.per_transaction,
+			daily_amount = EXCLUDED.daily_amount,
+			monthly_amount = EXCLUDED.monthly_amount,
+			daily_count = EXCLUDED.daily_count,
+			updated_at = EXCLUDED.updated_at
+	`, userID, req.Tier, req.PerTransaction, req.DailyAmount, req.MonthlyAmount, req.DailyCount, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return withdrawalLimits(r.db, userID)
+}
+
+// checkWithdrawalLimits returns the first limit a withdrawal of amount would
+// break. The caller must hold the user's lock, so concurrent withdrawals
+// cannot both fit under the same remaining limit.
+func checkWithdrawalLimits(tx *sql.Tx, userID int, amount int64) error {
+	limits, err := withdrawalLimits(tx, userID)
+	if err != nil {
+		return err
+	}
+	if limits.PerTransaction != nil && amount > *limits.PerTransaction {
+		return models.ErrPerTransactionLimit
+	}
+	if limits.DailyAmount == nil && limits.MonthlyAmount == nil && limits.DailyCount == nil {
+		return nil
+	}
+
+	usage, err := withdrawalUsage(tx, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if limits.DailyAmount != nil && usage.DailyAmount+amount > *limits.DailyAmount {
+		return models.ErrDailyLimit
+	}
+	if limits.MonthlyAmount != nil && usage.MonthlyAmount+amount > *limits.MonthlyAmount {
+		return models.ErrMonthlyLimit
+	}
+	if limits.DailyCount != nil && usage.DailyCount+1 > *limits.DailyCount {
+		return models.ErrDailyCountLimit
+	}
+	return nil
+}
+
+// withdrawalLimits resolves a user's tier and overrides. Users without a
+// withdrawal_limits row are on the default tier.
+func withdrawalLimits(q queryRower, userID int) (*models.WithdrawalLimits, error) {
+	limits := models.WithdrawalLimits{UserID: userID}
+	var perTransaction, dailyAmount, monthlyAmount, dailyCount sql.NullInt64
+	err := q.QueryRow(`
+		SELECT t.name,
+			COALESCE(l.per_transaction, t.per_transaction),
+			COALESCE(l.daily_amount, t.daily_amount),
+			COALESCE(l.monthly_amount, t.monthly_amount),
+			COALESCE(l.daily_count, t.daily_count)
+		FROM withdrawal_limit_tiers t
+		LEFT JOIN withdrawal_limits l ON l.user_id = $1
+		WHERE t.name = COALESCE(l.tier, $2)
+	`, userID, models.DefaultLimitTier).Scan(&limits.Tier, &perTransaction, &dailyAmount, &monthlyAmount, &dailyCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		// No tier configured: nothing to enforce.
+		return &limits, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if perTransaction.Valid {
+		limits.PerTransaction = &perTransaction.Int64
+	}
+	if dailyAmount.Valid {
+		limits.DailyAmount = &dailyAmount.Int64
+	}
+	if monthlyAmount.Valid {
+		limits.MonthlyAmount = &monthlyAmount.Int64
+	}
+	if dailyCount.Valid {
+		n := int(dailyCount.Int64)
+		limits.DailyCount = &n
+	}
+	return &limits, nil
+}
+
+func withdrawalUsage(q queryRower, userID int, now time.Time) (*models.WithdrawalUsage, error) {
+	dayStart := now.Add(-24 * time.Hour)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	since := monthStart
+	if dayStart.Before(since) {
+		since = dayStart
+	}
+
+	var usage models.WithdrawalUsage
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(-amount) FILTER (WHERE created_at > $2), 0),
+			COUNT(*) FILTER (WHERE created_at > $2),
+			COALESCE(SUM(-amount) FILTER (WHERE created_at >= $3), 0)
+		FROM transactions
+		WHERE user_id = $1 AND type = 'withdraw' AND created_at >= $4 AND status NOT IN ($5, $6, $7)
+	`, userID, dayStart, monthStart, since, models.StatusFailed, models.StatusCancelled, models.StatusReversed).
+		Scan(&usage.DailyAmount, &usage.DailyCount, &usage.MonthlyAmount)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
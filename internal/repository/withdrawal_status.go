@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"wallet-simulator/internal/ledger"
+	"wallet-simulator/internal/models"
+)
+
+// withdrawalTransitions is the withdrawal state machine. A withdrawal
+// reserves funds from the moment it is accepted; failed, reversed and
+// cancelled are terminal and give the funds back through a compensating
+// reversal entry. Only withdrawals not yet sent to the bank can be
+// cancelled.
+var withdrawalTransitions = map[string][]string{
+	models.StatusScheduled:    {models.StatusPending, models.StatusCancelled},
+	models.StatusPending:      {models.StatusProcessing, models.StatusFailed, models.StatusManualReview, models.StatusCancelled},
+	models.StatusProcessing:   {models.StatusCompleted, models.StatusFailed},
+	models.StatusManualReview: {models.StatusPending, models.StatusFailed, models.StatusCancelled},
+	models.StatusCompleted:    {models.StatusReversed},
+}
+
+func CanTransitionWithdrawal(from, to string) bool {
+	for _, allowed := range withdrawalTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateWithdrawalStatus moves a withdrawal through the state machine under a
+// row lock, rejecting illegal transitions such as a late success after
+// failure. Moving to failed or reversed credits the amount back to the user.
+func (r *Repository) UpdateWithdrawalStatus(idempotencyKey string, status string, userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var amount int64
+	var current string
+	err = tx.QueryRow(`
+		SELECT id, amount, status FROM transactions
+		WHERE idempotency_key = $1 AND user_id = $2 AND type = 'withdraw' FOR UPDATE
+	`, idempotencyKey, userID).Scan(&id, &amount, &current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrTransactionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := r.transitionWithdrawal(tx, id, userID, -amount, idempotencyKey, current, status); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("✅ Updated withdrawal %s status to %s", idempotencyKey, status)
+	return nil
+}
+
+// transitionWithdrawal moves a withdrawal the caller has locked from current
+// to status and books what the new status implies: a completed payout is
+// settled out of clearing, and a failed, reversed or cancelled one gives the
+// funds back. Every status change goes through here so none of them can skip
+// the state machine or its side effects.
+func (r *Repository) transitionWithdrawal(tx *sql.Tx, id, userID int, amount int64, idempotencyKey, current, status string) error {
+	if !CanTransitionWithdrawal(current, status) {
+		return fmt.Errorf("%w: %s -> %s", models.ErrInvalidStatusTransition, current, status)
+	}
+
+	// updated_at also restarts the review deadline of a requeued withdrawal.
+	if _, err := tx.Exec(`UPDATE transactions SET status = $1, updated_at = NOW() WHERE id = $2`, status, id); err != nil {
+		return err
+	}
+
+	switch status {
+	case models.StatusCompleted:
+		if _, err := ledger.Post(tx, ledger.PayoutSettled(id, amount)); err != nil {
+			return err
+		}
+	case models.StatusFailed, models.StatusReversed, models.StatusCancelled:
+		return r.refundWithdrawal(tx, id, userID, amount, idempotencyKey, status)
+	}
+	return nil
+}
+
+// CancelWithdrawal cancels a scheduled or pending withdrawal, or one parked
+// in manual_review, and gives its funds back. Its job is closed in the same
+// transaction so no worker claims it afterwards; a worker that already
+// claimed it finds the withdrawal cancelled and skips it.
+func (r *Repository) CancelWithdrawal(ctx context.Context, idempotencyKey string, userID int) (*models.TransactionDetail, error) {
+	err := r.runTx(ctx, nil, func(tx *sql.Tx) error {
+		var id int
+		var amount int64
+		var current string
+		err := tx.QueryRowContext(ctx, `
+			SELECT id, amount, status FROM transactions
+			WHERE idempotency_key = $1 AND user_id = $2 AND type = 'withdraw' FOR UPDATE
+		`, idempotencyKey, userID).Scan(&id, &amount, &current)
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrTransactionNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if current == models.StatusCancelled {
+			return models.ErrWithdrawalCancelled
+		}
+		if !CanTransitionWithdrawal(current, models.StatusCancelled) {
+			return models.ErrPayoutAlreadySent
+		}
+
+		if err := r.transitionWithdrawal(tx, id, userID, -amount, idempotencyKey, current, models.StatusCancelled); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE withdrawal_jobs SET status = $1, locked_until = NULL, updated_at = NOW() WHERE transaction_id = $2
+		`, models.JobStatusCancelled, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("🚫 Cancelled withdrawal %s", idempotencyKey)
+	return r.GetWithdrawal(idempotencyKey, userID)
+}
+
+// ResolveManualReview settles a withdrawal parked in manual_review. Requeue
+// moves it back to pending and reopens its job, which the worker that parked
+// it closed, so it is paid out under the same payout key; fail and cancel
+// give the funds and the fee back and close the job.
+func (r *Repository) ResolveManualReview(ctx context.Context, id int, action string) (*models.TransactionDetail, error) {
+	var userID int
+	err := r.runTx(ctx, nil, func(tx *sql.Tx) error {
+		var amount int64
+		var current, idempotencyKey string
+		err := tx.QueryRowContext(ctx, `
+			SELECT user_id, amount, status, idempotency_key FROM transactions
+			WHERE id = $1 AND type = 'withdraw' FOR UPDATE
+		`, id).Scan(&userID, &amount, &current, &idempotencyKey)
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrTransactionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if current != models.StatusManualReview {
+			return models.ErrNotInManualReview
+		}
+
+		status, jobStatus := models.StatusPending, models.JobStatusQueued
+		switch action {
+		case models.ResolveFail:
+			status, jobStatus = models.StatusFailed, models.JobStatusFailed
+		case models.ResolveCancel:
+			status, jobStatus = models.StatusCancelled, models.JobStatusCancelled
+		}
+
+		if err := r.transitionWithdrawal(tx, id, userID, -amount, idempotencyKey, current, status); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO withdrawal_jobs (transaction_id, user_id, amount, idempotency_key, status)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (transaction_id) DO UPDATE
+			SET status = EXCLUDED.status, run_at = NOW(), locked_until = NULL, updated_at = NOW()
+		`, id, userID, -amount, idempotencyKey, jobStatus)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("🩺 Resolved withdrawal %d in manual review: %s", id, action)
+	return r.GetTransaction(id, userID)
+}
+
+// GetManualReviewWithdrawals lists withdrawals waiting in manual_review,
+// oldest first.
+func (r *Repository) GetManualReviewWithdrawals(limit int) ([]models.TransactionDetail, error) {
+	rows, err := r.db.Query(transactionDetailQuery+` WHERE t.type = 'withdraw' AND t.status = $1 ORDER BY t.created_at, t.id LIMIT $2`,
+		models.StatusManualReview, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	withdrawals := []models.TransactionDetail{}
+	for rows.Next() {
+		d, err := scanTransactionDetail(rows)
+		if err != nil {
+			return nil, err
+		}
+		withdrawals = append(withdrawals, *d)
+	}
+	return withdrawals, rows.Err()
+}
+
+// refundWithdrawal gives a failed, reversed or cancelled withdrawal's funds
+// back to the user.
+func (r *Repository) refundWithdrawal(tx *sql.Tx, withdrawalID, userID int, amount int64, idempotencyKey, status string) error {
+	reversalID, err := r.CreateReversal(tx, withdrawalID, userID, amount, idempotencyKey)
+	if err != nil {
+		return err
+	}
+
+	// A failed or cancelled payout never left clearing; a reversed one comes
+	// back from the bank.
+	journal := ledger.PayoutFailed(reversalID, userID, amount)
+	switch status {
+	case models.StatusReversed:
+		journal = ledger.PayoutReversed(reversalID, userID, amount)
+	case models.StatusCancelled:
+		journal = ledger.PayoutCancelled(reversalID, userID, amount)
+	}
+	if _, err := ledger.Post(tx, journal); err != nil {
+		return err
+	}
+	log.Printf("💸 Refunded %d to user %d for %s withdrawal %s", amount, userID, status, idempotencyKey)
+
+	// The fee is only kept once the payout has reached the bank.
+	if status != models.StatusReversed {
+		_, err := refundFee(tx, withdrawalID, userID)
+		return err
+	}
+	return nil
+}
+
+// CreateReversal books the compensating credit for a withdrawal. Its key is
+// derived from the withdrawal's, so a withdrawal can only be reversed once.
+func (r *Repository) CreateReversal(tx *sql.Tx, withdrawalID, userID int, amount int64, idempotencyKey string) (int, error) {
+	var id int
+	err := tx.QueryRow(`
+		INSERT INTO transactions (user_id, amount, type, status, created_at, release_at, idempotency_key, reference_id)
+		VALUES ($1, $2, 'reversal', $3, NOW(), NULL, $4, $5) RETURNING id
+	`, userID, amount, models.StatusCompleted, idempotencyKey+":reversal", withdrawalID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if err := applyBalance(tx, userID, amount, amount); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
@@ -0,0 +1,90 @@
+package split
+
+import (
+	"errors"
+	"time"
+)
+
+// MaxInstallments bounds equal-installment schedules.
+const MaxInstallments = 120
+
+// Installment intervals
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+var (
+	ErrEmptySchedule       = errors.New("release schedule needs at least one tranche")
+	ErrInvalidInstallments = errors.New("installments must be between 1 and 120")
+	ErrInvalidInterval     = errors.New("interval must be day, week or month")
+)
+
+// Tranche releases Bps of a charge at At; a zero At releases it
+// immediately.
+type Tranche struct {
+	Bps int
+	At  time.Time
+}
+
+// Release is one planned release of part of a charge.
+type Release struct {
+	Amount int64
+	At     time.Time
+}
+
+// Schedule allocates total over tranches with Allocate, so the releases add
+// up to total and rounding is the same for the same schedule. Tranches
+// without a time are released at now.
+func Schedule(total int64, tranches []Tranche, now time.Time) ([]Release, error) {
+	if len(tranches) == 0 {
+		return nil, ErrEmptySchedule
+	}
+	shares := make([]Share, len(tranches))
+	for i, t := range tranches {
+		shares[i] = Share{Bps: t.Bps}
+	}
+	amounts, err := Allocate(total, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := make([]Release, len(tranches))
+	for i, t := range tranches {
+		releases[i] = Release{Amount: amounts[i], At: t.At}
+		if t.At.IsZero() {
+			releases[i].At = now
+		}
+	}
+	return releases, nil
+}
+
+// Installments divides total into n equal releases, the first at start and
+// each later one an interval after the previous. Leftover units go to the
+// earliest releases.
+func Installments(total int64, n int, interval string, start time.Time) ([]Release, error) {
+	if n < 1 || n > MaxInstallments {
+		return nil, ErrInvalidInstallments
+	}
+	next := map[string]func(i int) time.Time{
+		IntervalDay:   func(i int) time.Time { return start.AddDate(0, 0, i) },
+		IntervalWeek:  func(i int) time.Time { return start.AddDate(0, 0, 7*i) },
+		IntervalMonth: func(i int) time.Time { return start.AddDate(0, i, 0) },
+	}[interval]
+	if next == nil {
+		return nil, ErrInvalidInterval
+	}
+	if total < int64(n) {
+		return nil, ErrZeroLeg
+	}
+
+	releases := make([]Release, n)
+	for i := range releases {
+		releases[i] = Release{Amount: total / int64(n), At: next(i)}
+		if int64(i) < total%int64(n) {
+			releases[i].Amount++
+		}
+	}
+	return releases, nil
+}
@@ -0,0 +1,98 @@
+// Package split divides a charge among recipients by fixed amounts and
+// basis-point shares, and over time into release schedules.
+package split
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// TotalBps is a whole charge in basis points.
+const TotalBps = 10000
+
+var (
+	ErrInvalidShare      = errors.New("each recipient needs either a positive amount or a share_bps between 1 and 10000")
+	ErrNoShares          = errors.New("split needs at least one recipient")
+	ErrFixedExceedsTotal = errors.New("fixed amounts exceed the charge amount")
+	ErrSharesIncomplete  = errors.New("shares must cover the whole charge: share_bps must sum to 10000, or fixed amounts to the charge amount")
+	ErrZeroLeg           = errors.New("a share rounds down to zero")
+	ErrAmountTooLarge    = errors.New("amount too large to split")
+)
+
+// Share is one recipient's cut: either a fixed Amount or Bps of whatever is
+// left after all fixed amounts.
+type Share struct {
+	Amount int64
+	Bps    int
+}
+
+// Allocate returns the amount of each share, in order. Fixed amounts are
+// taken first; the rest is divided by basis points using the largest
+// remainder method, so the legs always add up to total and the leftover
+// units go to the shares with the largest fractional parts, earlier shares
+// winning ties.
+func Allocate(total int64, shares []Share) ([]int64, error) {
+	if len(shares) == 0 {
+		return nil, ErrNoShares
+	}
+
+	amounts := make([]int64, len(shares))
+	remaining := total
+	bpsSum := 0
+	for i, s := range shares {
+		switch {
+		case s.Amount > 0 && s.Bps == 0:
+			amounts[i] = s.Amount
+			remaining -= s.Amount
+		case s.Amount == 0 && s.Bps > 0 && s.Bps <= TotalBps:
+			bpsSum += s.Bps
+		default:
+			return nil, ErrInvalidShare
+		}
+	}
+	if remaining < 0 {
+		return nil, ErrFixedExceedsTotal
+	}
+	if bpsSum == 0 {
+		if remaining != 0 {
+			return nil, ErrSharesIncomplete
+		}
+		return amounts, nil
+	}
+	if bpsSum != TotalBps {
+		return nil, ErrSharesIncomplete
+	}
+	if remaining > math.MaxInt64/TotalBps {
+		return nil, ErrAmountTooLarge
+	}
+
+	type fraction struct {
+		index     int
+		remainder int64
+	}
+	var fractions []fraction
+	leftover := remaining
+	for i, s := range shares {
+		if s.Bps == 0 {
+			continue
+		}
+		amounts[i] = remaining * int64(s.Bps) / TotalBps
+		leftover -= amounts[i]
+		fractions = append(fractions, fraction{index: i, remainder: remaining * int64(s.Bps) % TotalBps})
+	}
+
+	sort.SliceStable(fractions, func(a, b int) bool {
+		return fractions[a].remainder > fractions[b].remainder
+	})
+	for i := int64(0); i < leftover; i++ {
+		amounts[fractions[i].index]++
+	}
+
+	for _, a := range amounts {
+		if a == 0 {
+			return nil, ErrZeroLeg
+		}
+	}
+	return amounts, nil
+}
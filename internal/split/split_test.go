@@ -0,0 +1,113 @@
+package split_test
+
+import (
+	"testing"
+	"time"
+	"wallet-simulator/internal/split"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		total  int64
+		shares []split.Share
+		want   []int64
+	}{
+		{
+			name:   "fixed amounts",
+			total:  1000,
+			shares: []split.Share{{Amount: 700}, {Amount: 300}},
+			want:   []int64{700, 300},
+		},
+		{
+			name:   "fixed fee then shares of the rest",
+			total:  1050,
+			shares: []split.Share{{Amount: 50}, {Bps: 8000}, {Bps: 2000}},
+			want:   []int64{50, 800, 200},
+		},
+		{
+			name:   "remainder goes to largest fractions",
+			total:  100,
+			shares: []split.Share{{Bps: 3333}, {Bps: 3333}, {Bps: 3334}},
+			want:   []int64{33, 33, 34},
+		},
+		{
+			name:   "ties go to earlier shares",
+			total:  10,
+			shares: []split.Share{{Bps: 5000}, {Bps: 5000}, {Amount: 1}},
+			want:   []int64{5, 4, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := split.Allocate(tt.total, tt.shares)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			var sum int64
+			for _, a := range got {
+				sum += a
+			}
+			assert.Equal(t, tt.total, sum)
+		})
+	}
+}
+
+func TestAllocateErrors(t *testing.T) {
+	_, err := split.Allocate(100, nil)
+	assert.Equal(t, split.ErrNoShares, err)
+
+	_, err = split.Allocate(100, []split.Share{{Amount: 50, Bps: 5000}})
+	assert.Equal(t, split.ErrInvalidShare, err)
+
+	_, err = split.Allocate(100, []split.Share{{Amount: 150}})
+	assert.Equal(t, split.ErrFixedExceedsTotal, err)
+
+	_, err = split.Allocate(100, []split.Share{{Amount: 60}, {Amount: 30}})
+	assert.Equal(t, split.ErrSharesIncomplete, err)
+
+	_, err = split.Allocate(100, []split.Share{{Bps: 6000}, {Bps: 3000}})
+	assert.Equal(t, split.ErrSharesIncomplete, err)
+
+	_, err = split.Allocate(1, []split.Share{{Bps: 5000}, {Bps: 5000}})
+	assert.Equal(t, split.ErrZeroLeg, err)
+}
+
+func TestSchedule(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := now.AddDate(0, 0, 7)
+
+	got, err := split.Schedule(1001, []split.Tranche{{Bps: 3000}, {Bps: 7000, At: later}}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []split.Release{{Amount: 300, At: now}, {Amount: 701, At: later}}, got)
+
+	_, err = split.Schedule(1000, nil, now)
+	assert.Equal(t, split.ErrEmptySchedule, err)
+
+	_, err = split.Schedule(1000, []split.Tranche{{Bps: 3000}}, now)
+	assert.Equal(t, split.ErrSharesIncomplete, err)
+}
+
+func TestInstallments(t *testing.T) {
+	start := time.Date(2030, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	got, err := split.Installments(1000, 3, split.IntervalMonth, start)
+	assert.NoError(t, err)
+	assert.Equal(t, []split.Release{
+		{Amount: 334, At: start},
+		{Amount: 333, At: start.AddDate(0, 1, 0)},
+		{Amount: 333, At: start.AddDate(0, 2, 0)},
+	}, got)
+
+	_, err = split.Installments(1000, 0, split.IntervalDay, start)
+	assert.Equal(t, split.ErrInvalidInstallments, err)
+
+	_, err = split.Installments(1000, 3, "year", start)
+	assert.Equal(t, split.ErrInvalidInterval, err)
+
+	_, err = split.Installments(2, 3, split.IntervalDay, start)
+	assert.Equal(t, split.ErrZeroLeg, err)
+}
@@ -2,69 +2,231 @@ package tasks
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"math/rand"
 	"time"
+	"wallet-simulator/internal/bank"
 	"wallet-simulator/internal/models"
 	"wallet-simulator/internal/repository"
+	"wallet-simulator/internal/worker"
 )
 
 type BankWithdrawalTask struct {
 	repo           *repository.Repository
+	gateway        bank.BankGateway
+	policy         worker.RetryPolicy
+	job            models.WithdrawalJob
+	maxClaims      int
+	jobID          int
 	userID         int
 	amount         int64
 	idempotencyKey string
+	payoutKey      string
 }
 
-func NewBankWithdrawalTask(repo *repository.Repository, userID int, amount int64, idempotencyKey string) *BankWithdrawalTask {
+// NewBankWithdrawalTask builds the payout task for a claimed job. When the
+// policy has no retryable predicate, the gateway's error classification is
+// used. A job claimed more than maxClaims times is given up on; zero means
+// no cap.
+func NewBankWithdrawalTask(repo *repository.Repository, gateway bank.BankGateway, policy worker.RetryPolicy, job models.WithdrawalJob, maxClaims int) *BankWithdrawalTask {
+	if policy.Retryable == nil {
+		policy.Retryable = bank.IsRetryable
+	}
 	return &BankWithdrawalTask{
 		repo:           repo,
-		userID:         userID,
-		amount:         amount,
-		idempotencyKey: idempotencyKey,
+		gateway:        gateway,
+		policy:         policy,
+		job:            job,
+		maxClaims:      maxClaims,
+		jobID:          job.ID,
+		userID:         job.UserID,
+		amount:         job.Amount,
+		idempotencyKey: job.IdempotencyKey,
+		payoutKey:      PayoutKey(job),
 	}
 }
 
+// PayoutKey is the idempotency key a withdrawal's payout is sent to the bank
+// under. Client keys are only unique per user, so the bank gets the
+// withdrawal's transaction ID instead, which stays the same across retries
+// and re-claims of the job.
+func PayoutKey(job models.WithdrawalJob) string {
+	return fmt.Sprintf("withdrawal:%d", job.TransactionID)
+}
+
 func (t *BankWithdrawalTask) Execute(ctx context.Context) error {
-	return t.withdrawWithRetries(ctx, 3)
+	// A job can be claimed again after a crash or an expired lease. Pending
+	// withdrawals are moved to processing; processing ones were interrupted
+	// mid-payout and are resumed under the same idempotency key; anything
+	// else is already settled or cancelled.
+	status, err := t.repo.GetWithdrawalStatus(ctx, t.idempotencyKey, t.userID)
+	if err != nil {
+		return err
+	}
+	if t.maxClaims > 0 && t.job.Attempts > t.maxClaims &&
+		(status == models.StatusPending || status == models.StatusProcessing) {
+		return t.abandon(ctx)
+	}
+	switch status {
+	case models.StatusPending:
+		err := t.repo.UpdateWithdrawalStatus(t.idempotencyKey, models.StatusProcessing, t.userID)
+		if errors.Is(err, models.ErrInvalidStatusTransition) {
+			// Cancelled, or otherwise moved on, since the status was read.
+			if status, err = t.repo.GetWithdrawalStatus(ctx, t.idempotencyKey, t.userID); err != nil {
+				return err
+			}
+			return t.skip(ctx, status)
+		}
+		if err != nil {
+			return err
+		}
+	case models.StatusProcessing:
+		log.Printf("🔁 Resuming withdrawal %s (job %d, claim %d)", t.idempotencyKey, t.jobID, t.job.Attempts)
+	default:
+		return t.skip(ctx, status)
+	}
+
+	result, err := t.withdrawWithRetries(ctx)
+	if ctx.Err() != nil {
+		// Leave the job leased; it is picked up again once the lease expires.
+		return err
+	}
+
+	jobStatus := models.JobStatusDone
+	if err != nil {
+		jobStatus = models.JobStatusFailed
+	}
+	if finishErr := t.repo.FinishWithdrawalJob(ctx, t.jobID, jobStatus, result.ReferenceID, err); finishErr != nil {
+		log.Printf("⚠️ Failed to finish withdrawal job %d: %v", t.jobID, finishErr)
+	}
+	return err
+}
+
+// skip closes the job of a withdrawal that needs no payout.
+func (t *BankWithdrawalTask) skip(ctx context.Context, status string) error {
+	log.Printf("⏭️ Withdrawal %s already %s, skipping job %d", t.idempotencyKey, status, t.jobID)
+	jobStatus := models.JobStatusDone
+	if status == models.StatusCancelled {
+		jobStatus = models.JobStatusCancelled
+	}
+	return t.repo.FinishWithdrawalJob(ctx, t.jobID, jobStatus, "", nil)
+}
+
+// abandon fails a withdrawal whose job keeps being re-claimed without
+// finishing, e.g. because every run is killed by the task timeout, and
+// dead-letters it for an operator. A payout may already have reached the
+// bank, so the dead letter is the place to reconcile it.
+func (t *BankWithdrawalTask) abandon(ctx context.Context) error {
+	err := fmt.Errorf("%w: claimed %d times", models.ErrTooManyClaims, t.job.Attempts)
+	log.Printf("🛑 Giving up on withdrawal %s (job %d): %v", t.idempotencyKey, t.jobID, err)
+
+	if updateErr := t.repo.UpdateWithdrawalStatus(t.idempotencyKey, models.StatusFailed, t.userID); updateErr != nil {
+		return updateErr
+	}
+	t.deadLetter(ctx, err)
+	if finishErr := t.repo.FinishWithdrawalJob(ctx, t.jobID, models.JobStatusFailed, "", err); finishErr != nil {
+		log.Printf("⚠️ Failed to finish withdrawal job %d: %v", t.jobID, finishErr)
+	}
+	return fmt.Errorf("%w: %w", models.ErrBankFailed, err)
 }
 
-func (t *BankWithdrawalTask) withdrawWithRetries(ctx context.Context, maxRetries int) error {
-	var lastErr error
+func (t *BankWithdrawalTask) withdrawWithRetries(ctx context.Context) (bank.PayoutResult, error) {
+	var result bank.PayoutResult
 
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		select {
-		case <-ctx.Done():
-			log.Printf("⏱️ Withdrawal cancelled for user %d", t.userID)
-			return ctx.Err()
-		default:
+	err := t.policy.Do(ctx, func(ctx context.Context, attempt int) error {
+		var err error
+		result, err = t.gateway.Payout(ctx, bank.PayoutRequest{
+			IdempotencyKey: t.payoutKey,
+			UserID:         t.userID,
+			Amount:         t.amount,
+			Attempt:        attempt,
+		})
+		if err != nil {
+			log.Printf("⏳ Bank withdrawal attempt %d/%d failed for user %d: %v", attempt, t.policy.MaxAttempts, t.userID, err)
+		}
+		if recordErr := t.repo.RecordPayoutAttempt(ctx, t.jobID, err); recordErr != nil {
+			log.Printf("⚠️ Failed to record payout attempt for job %d: %v", t.jobID, recordErr)
 		}
+		return err
+	})
+	if ctx.Err() != nil {
+		log.Printf("⏱️ Withdrawal cancelled for user %d", t.userID)
+		return bank.PayoutResult{}, ctx.Err()
+	}
 
-		if rand.Float64() > 0.3 {
-			log.Printf("✅ Bank withdrawal successful for user %d (attempt %d)", t.userID, attempt)
-			if err := t.repo.UpdateWithdrawalStatus(t.idempotencyKey, "completed", t.userID); err != nil {
-				log.Printf("⚠️ Failed to update withdrawal status: %v", err)
-				return err
-			}
-			return nil
+	if err == nil {
+		log.Printf("✅ Bank withdrawal successful for user %d (ref %s)", t.userID, result.ReferenceID)
+		if err := t.repo.UpdateWithdrawalStatus(t.idempotencyKey, models.StatusCompleted, t.userID); err != nil {
+			// The bank has paid but the ledger refused the transition; this
+			// needs reconciliation against the bank reference.
+			log.Printf("🚨 Withdrawal %s paid out (ref %s) but status update failed: %v", t.idempotencyKey, result.ReferenceID, err)
+			return result, err
 		}
+		return result, nil
+	}
 
-		lastErr = models.ErrBankFailed
-		if attempt < maxRetries {
-			backoff := time.Duration(1<<uint(attempt-1)) * time.Second
-			log.Printf("⏳ Retry attempt %d/%d for user %d after %v", attempt, maxRetries, t.userID, backoff)
+	log.Printf("❌ Bank withdrawal failed for user %d: %v", t.userID, err)
+	if err := t.repo.UpdateWithdrawalStatus(t.idempotencyKey, models.StatusFailed, t.userID); err != nil {
+		log.Printf("⚠️ Failed to update withdrawal status: %v", err)
+	}
+	t.deadLetter(ctx, err)
+	return bank.PayoutResult{}, fmt.Errorf("%w: %w", models.ErrBankFailed, err)
+}
 
-			select {
-			case <-time.After(backoff):
-			case <-ctx.Done():
-				return ctx.Err()
-			}
+// deadLetter records the exhausted withdrawal so operators can replay or
+// discard it later.
+func (t *BankWithdrawalTask) deadLetter(ctx context.Context, err error) {
+	var history []models.DeadLetterError
+	var retryErr *worker.RetryError
+	if errors.As(err, &retryErr) {
+		for i, attemptErr := range retryErr.Errors {
+			history = append(history, models.DeadLetterError{Attempt: i + 1, Error: attemptErr.Error()})
 		}
+	} else {
+		history = append(history, models.DeadLetterError{Attempt: 1, Error: err.Error()})
 	}
 
-	log.Printf("❌ Bank withdrawal failed after %d retries for user %d", maxRetries, t.userID)
-	if err := t.repo.UpdateWithdrawalStatus(t.idempotencyKey, "failed", t.userID); err != nil {
-		log.Printf("⚠️ Failed to update withdrawal status: %v", err)
+	payload, marshalErr := json.Marshal(t.job)
+	if marshalErr != nil {
+		log.Printf("⚠️ Failed to encode dead letter payload: %v", marshalErr)
+		return
+	}
+
+	id, dlErr := t.repo.CreateDeadLetter(ctx, models.DeadLetter{
+		TaskType:     models.TaskTypeBankWithdrawal,
+		TaskKey:      t.idempotencyKey,
+		UserID:       t.userID,
+		Payload:      payload,
+		ErrorHistory: history,
+		Attempts:     len(history),
+	})
+	if dlErr != nil {
+		log.Printf("⚠️ Failed to record dead letter for withdrawal %s: %v", t.idempotencyKey, dlErr)
+		return
+	}
+	log.Printf("📮 Withdrawal %s moved to dead letter %d", t.idempotencyKey, id)
+}
+
+// WithdrawalJobSource feeds the worker pool from the durable withdrawal_jobs
+// table.
+type WithdrawalJobSource struct {
+	repo      *repository.Repository
+	gateway   bank.BankGateway
+	policy    worker.RetryPolicy
+	lease     time.Duration
+	maxClaims int
+}
+
+func NewWithdrawalJobSource(repo *repository.Repository, gateway bank.BankGateway, policy worker.RetryPolicy, lease time.Duration, maxClaims int) *WithdrawalJobSource {
+	return &WithdrawalJobSource{repo: repo, gateway: gateway, policy: policy, lease: lease, maxClaims: maxClaims}
+}
+
+func (s *WithdrawalJobSource) Next(ctx context.Context) (worker.Task, error) {
+	job, err := s.repo.ClaimWithdrawalJob(ctx, s.lease)
+	if err != nil || job == nil {
+		return nil, err
 	}
-	return lastErr
+	return NewBankWithdrawalTask(s.repo, s.gateway, s.policy, *job, s.maxClaims), nil
 }
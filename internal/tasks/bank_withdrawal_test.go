@@ -0,0 +1,301 @@
+package tasks_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"wallet-simulator/internal/bank"
+	"wallet-simulator/internal/models"
+	"wallet-simulator/internal/repository"
+	"wallet-simulator/internal/tasks"
+	"wallet-simulator/internal/worker"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var policy = worker.RetryPolicy{MaxAttempts: 3}
+
+const maxClaims = 5
+
+var job = models.WithdrawalJob{ID: 3, TransactionID: 7, UserID: 1, Amount: 300, IdempotencyKey: "withdraw-key-790", Attempts: 1}
+
+func expectPayoutAttempt(mock sqlmock.Sqlmock) {
+	expectJobPayoutAttempt(mock, job)
+}
+
+func expectJobPayoutAttempt(mock sqlmock.Sqlmock, job models.WithdrawalJob) {
+	mock.ExpectExec("UPDATE withdrawal_jobs SET payout_attempts").
+		WithArgs(sqlmock.AnyArg(), job.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func expectJournal(mock sqlmock.Sqlmock, kind string, transactionID int) {
+	mock.ExpectQuery("INSERT INTO journal_entries").
+		WithArgs(kind, transactionID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO postings").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+}
+
+// expectTransition mocks a successful UpdateWithdrawalStatus call.
+func expectTransition(mock sqlmock.Sqlmock, from, to string) {
+	expectJobTransition(mock, job, from, to)
+}
+
+func expectJobTransition(mock sqlmock.Sqlmock, job models.WithdrawalJob, from, to string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, amount, status FROM transactions").
+		WithArgs(job.IdempotencyKey, job.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "status"}).AddRow(job.TransactionID, -job.Amount, from))
+	mock.ExpectExec("UPDATE transactions SET status").
+		WithArgs(to, job.TransactionID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if to == models.StatusFailed {
+		mock.ExpectQuery("INSERT INTO transactions .*'reversal'").
+			WithArgs(job.UserID, job.Amount, models.StatusCompleted, job.IdempotencyKey+":reversal", job.TransactionID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(99))
+		mock.ExpectExec("INSERT INTO accounts").
+			WithArgs(job.UserID, job.Amount, job.Amount, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectJournal(mock, "payout_failed", 99)
+		mock.ExpectQuery("SELECT id, -amount, idempotency_key FROM transactions WHERE type = 'fee'").
+			WithArgs(job.TransactionID).
+			WillReturnError(sql.ErrNoRows)
+	}
+	if to == models.StatusCompleted {
+		expectJournal(mock, "payout_settled", job.TransactionID)
+	}
+	mock.ExpectCommit()
+}
+
+func TestBankWithdrawalTask_Completes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	gateway := bank.NewScriptedGateway(bank.ScriptStep{Result: bank.PayoutResult{ReferenceID: "BANK-1"}})
+	task := tasks.NewBankWithdrawalTask(repository.NewRepository(db), gateway, policy, job, maxClaims)
+
+	mock.ExpectQuery("SELECT status FROM transactions").
+		WithArgs(job.IdempotencyKey, job.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.StatusPending))
+	expectTransition(mock, models.StatusPending, models.StatusProcessing)
+	expectPayoutAttempt(mock)
+	expectTransition(mock, models.StatusProcessing, models.StatusCompleted)
+	mock.ExpectExec("UPDATE withdrawal_jobs").
+		WithArgs(models.JobStatusDone, sqlmock.AnyArg(), "BANK-1", job.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, task.Execute(context.Background()))
+	assert.Len(t, gateway.Calls(), 1)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBankWithdrawalTask_PermanentFailureIsNotRetried(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	gateway := bank.NewScriptedGateway(bank.ScriptStep{Err: bank.Permanent("declined", bank.ErrPayoutDeclined)})
+	task := tasks.NewBankWithdrawalTask(repository.NewRepository(db), gateway, policy, job, maxClaims)
+
+	mock.ExpectQuery("SELECT status FROM transactions").
+		WithArgs(job.IdempotencyKey, job.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.StatusPending))
+	expectTransition(mock, models.StatusPending, models.StatusProcessing)
+	expectPayoutAttempt(mock)
+	expectTransition(mock, models.StatusProcessing, models.StatusFailed)
+	mock.ExpectQuery("INSERT INTO dead_letters").
+		WithArgs(models.TaskTypeBankWithdrawal, job.IdempotencyKey, job.UserID, sqlmock.AnyArg(), sqlmock.AnyArg(), 1, models.DeadLetterOpen).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("UPDATE withdrawal_jobs").
+		WithArgs(models.JobStatusFailed, sqlmock.AnyArg(), "", job.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = task.Execute(context.Background())
+	assert.ErrorIs(t, err, models.ErrBankFailed)
+	assert.ErrorIs(t, err, bank.ErrPayoutDeclined)
+	assert.Len(t, gateway.Calls(), 1)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBankWithdrawalTask_SkipsSettledWithdrawal(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	gateway := bank.NewScriptedGateway()
+	task := tasks.NewBankWithdrawalTask(repository.NewRepository(db), gateway, policy, job, maxClaims)
+
+	mock.ExpectQuery("SELECT status FROM transactions").
+		WithArgs(job.IdempotencyKey, job.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("completed"))
+	mock.ExpectExec("UPDATE withdrawal_jobs").
+		WithArgs(models.JobStatusDone, sqlmock.AnyArg(), "", job.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, task.Execute(context.Background()))
+	assert.Empty(t, gateway.Calls())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBankWithdrawalTask_RetriesRetryableFailures(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	gateway := bank.NewScriptedGateway(
+		bank.ScriptStep{Err: bank.Retryable("unavailable", bank.ErrBankUnavailable)},
+		bank.ScriptStep{Result: bank.PayoutResult{ReferenceID: "BANK-2"}},
+	)
+	task := tasks.NewBankWithdrawalTask(repository.NewRepository(db), gateway, policy, job, maxClaims)
+
+	mock.ExpectQuery("SELECT status FROM transactions").
+		WithArgs(job.IdempotencyKey, job.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.StatusPending))
+	expectTransition(mock, models.StatusPending, models.StatusProcessing)
+	expectPayoutAttempt(mock)
+	expectPayoutAttempt(mock)
+	expectTransition(mock, models.StatusProcessing, models.StatusCompleted)
+	mock.ExpectExec("UPDATE withdrawal_jobs").
+		WithArgs(models.JobStatusDone, sqlmock.AnyArg(), "BANK-2", job.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, task.Execute(context.Background()))
+
+	calls := gateway.Calls()
+	assert.Len(t, calls, 2)
+	assert.Equal(t, 2, calls[1].Attempt)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBankWithdrawalTask_SkipsWithdrawalCancelledWhileClaiming(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	gateway := bank.NewScriptedGateway()
+	task := tasks.NewBankWithdrawalTask(repository.NewRepository(db), gateway, policy, job, maxClaims)
+
+	mock.ExpectQuery("SELECT status FROM transactions").
+		WithArgs(job.IdempotencyKey, job.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.StatusPending))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, amount, status FROM transactions").
+		WithArgs(job.IdempotencyKey, job.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "status"}).AddRow(job.TransactionID, -job.Amount, models.StatusCancelled))
+	mock.ExpectRollback()
+	mock.ExpectQuery("SELECT status FROM transactions").
+		WithArgs(job.IdempotencyKey, job.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.StatusCancelled))
+	mock.ExpectExec("UPDATE withdrawal_jobs").
+		WithArgs(models.JobStatusCancelled, sqlmock.AnyArg(), "", job.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, task.Execute(context.Background()))
+	assert.Empty(t, gateway.Calls())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBankWithdrawalTask_GivesUpAfterMaxClaims(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	// Every earlier run was killed before the job was finished.
+	reclaimed := job
+	reclaimed.Attempts = maxClaims + 1
+
+	gateway := bank.NewScriptedGateway()
+	task := tasks.NewBankWithdrawalTask(repository.NewRepository(db), gateway, policy, reclaimed, maxClaims)
+
+	mock.ExpectQuery("SELECT status FROM transactions").
+		WithArgs(job.IdempotencyKey, job.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.StatusProcessing))
+	expectTransition(mock, models.StatusProcessing, models.StatusFailed)
+	mock.ExpectQuery("INSERT INTO dead_letters").
+		WithArgs(models.TaskTypeBankWithdrawal, job.IdempotencyKey, job.UserID, sqlmock.AnyArg(), sqlmock.AnyArg(), 1, models.DeadLetterOpen).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("UPDATE withdrawal_jobs").
+		WithArgs(models.JobStatusFailed, sqlmock.AnyArg(), "", job.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = task.Execute(context.Background())
+	assert.ErrorIs(t, err, models.ErrTooManyClaims)
+	assert.Empty(t, gateway.Calls())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBankWithdrawalTask_PayoutKeyIsUniqueAcrossUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db: %v", err)
+	}
+	defer db.Close()
+
+	// Two users withdrawing with the same client key.
+	jobs := []models.WithdrawalJob{
+		{ID: 3, TransactionID: 7, UserID: 1, Amount: 300, IdempotencyKey: "w-1", Attempts: 1},
+		{ID: 4, TransactionID: 8, UserID: 2, Amount: 500, IdempotencyKey: "w-1", Attempts: 1},
+	}
+	gateway := bank.NewScriptedGateway(
+		bank.ScriptStep{Result: bank.PayoutResult{ReferenceID: "BANK-1"}},
+		bank.ScriptStep{Result: bank.PayoutResult{ReferenceID: "BANK-2"}},
+	)
+	repo := repository.NewRepository(db)
+
+	for i, j := range jobs {
+		mock.ExpectQuery("SELECT status FROM transactions").
+			WithArgs(j.IdempotencyKey, j.UserID).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.StatusPending))
+		expectJobTransition(mock, j, models.StatusPending, models.StatusProcessing)
+		expectJobPayoutAttempt(mock, j)
+		expectJobTransition(mock, j, models.StatusProcessing, models.StatusCompleted)
+		mock.ExpectExec("UPDATE withdrawal_jobs").
+			WithArgs(models.JobStatusDone, sqlmock.AnyArg(), fmt.Sprintf("BANK-%d", i+1), j.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, tasks.NewBankWithdrawalTask(repo, gateway, policy, j, maxClaims).Execute(context.Background()))
+	}
+
+	calls := gateway.Calls()
+	assert.Len(t, calls, 2)
+	assert.Equal(t, "withdrawal:7", calls[0].IdempotencyKey)
+	assert.Equal(t, "withdrawal:8", calls[1].IdempotencyKey)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
@@ -11,24 +11,34 @@ type Task interface {
 	Execute(ctx context.Context) error
 }
 
+// TaskSource hands out durable tasks to idle workers. Next returns a nil
+// task when there is currently nothing to run.
+type TaskSource interface {
+	Next(ctx context.Context) (Task, error)
+}
+
 type WorkerPool struct {
 	workers     int
 	taskQueue   chan Task
+	wake        chan struct{}
 	wg          sync.WaitGroup
 	ctx         context.Context
 	cancel      context.CancelFunc
 	taskTimeout time.Duration
 }
 
-func NewWorkerPool(workers int) *WorkerPool {
+// NewWorkerPool starts a pool of workers. Every task and scheduled run gets
+// taskTimeout to finish before its context is cancelled.
+func NewWorkerPool(workers int, taskTimeout time.Duration) *WorkerPool {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	p := &WorkerPool{
 		workers:     workers,
 		taskQueue:   make(chan Task, workers*4),
+		wake:        make(chan struct{}, 1),
 		ctx:         ctx,
 		cancel:      cancel,
-		taskTimeout: 30 * time.Second,
+		taskTimeout: taskTimeout,
 	}
 
 	for i := 0; i < workers; i++ {
@@ -54,14 +64,86 @@ func (p *WorkerPool) runWorker(id int) {
 				return
 			}
 
-			ctx, cancel := context.WithTimeout(p.ctx, p.taskTimeout)
-			err := task.Execute(ctx)
-			cancel()
+			p.execute(id, task)
+		}
+	}
+}
+
+// Consume starts one polling worker per pool slot that claims tasks from
+// source until the pool is shut down. Idle workers poll every pollInterval,
+// or sooner when Wake is called.
+func (p *WorkerPool) Consume(source TaskSource, pollInterval time.Duration) {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.runConsumer(i, source, pollInterval)
+	}
+
+	log.Printf("Worker Pool consuming durable tasks with %d workers", p.workers)
+}
+
+func (p *WorkerPool) runConsumer(id int, source TaskSource, pollInterval time.Duration) {
+	defer p.wg.Done()
+
+	for {
+		if p.ctx.Err() != nil {
+			log.Printf("Consumer %d shutting down", id)
+			return
+		}
+
+		task, err := source.Next(p.ctx)
+		if err != nil && p.ctx.Err() == nil {
+			log.Printf("Consumer %d claim error: %v", id, err)
+		}
+		if task != nil {
+			p.execute(id, task)
+			continue
+		}
 
-			if err != nil {
-				log.Printf("Worker %d error: %v", id, err)
+		select {
+		case <-p.ctx.Done():
+		case <-p.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Schedule runs fn every interval until the pool is shut down, for periodic
+// housekeeping such as purging expired records. Runs never overlap.
+func (p *WorkerPool) Schedule(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.ctx.Done():
+				log.Printf("Scheduled job %s shutting down", name)
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(p.ctx, p.taskTimeout)
+				err := fn(ctx)
+				cancel()
+
+				if err != nil && p.ctx.Err() == nil {
+					log.Printf("Scheduled job %s error: %v", name, err)
+				}
 			}
 		}
+	}()
+
+	log.Printf("Worker Pool scheduled %s every %v", name, interval)
+}
+
+func (p *WorkerPool) execute(id int, task Task) {
+	ctx, cancel := context.WithTimeout(p.ctx, p.taskTimeout)
+	err := task.Execute(ctx)
+	cancel()
+
+	if err != nil {
+		log.Printf("Worker %d error: %v", id, err)
 	}
 }
 
@@ -76,6 +158,15 @@ func (p *WorkerPool) Submit(task Task) error {
 	}
 }
 
+// Wake nudges an idle consumer to poll its source immediately, e.g. right
+// after a new durable task has been committed.
+func (p *WorkerPool) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
 func (p *WorkerPool) Shutdown(timeout time.Duration) error {
 	log.Println("Worker pool shutting down...")
 	p.cancel()
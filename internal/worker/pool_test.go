@@ -0,0 +1,83 @@
+package worker_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+	"wallet-simulator/internal/worker"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingTask struct {
+	done func()
+}
+
+func (t *countingTask) Execute(ctx context.Context) error {
+	t.done()
+	return nil
+}
+
+// sliceSource hands out a fixed number of tasks, then reports an empty queue.
+type sliceSource struct {
+	mu    sync.Mutex
+	tasks []worker.Task
+}
+
+func (s *sliceSource) Next(ctx context.Context) (worker.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.tasks) == 0 {
+		return nil, nil
+	}
+	task := s.tasks[0]
+	s.tasks = s.tasks[1:]
+	return task, nil
+}
+
+func TestWorkerPoolConsume(t *testing.T) {
+	var wg sync.WaitGroup
+	source := &sliceSource{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		source.tasks = append(source.tasks, &countingTask{done: wg.Done})
+	}
+
+	pool := worker.NewWorkerPool(3, 30*time.Second)
+	pool.Consume(source, 10*time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("tasks from source were not consumed")
+	}
+
+	assert.NoError(t, pool.Shutdown(time.Second))
+}
+
+func TestWorkerPoolSchedule(t *testing.T) {
+	runs := make(chan struct{}, 10)
+
+	pool := worker.NewWorkerPool(1, 30*time.Second)
+	pool.Schedule("test", 10*time.Millisecond, func(ctx context.Context) error {
+		runs <- struct{}{}
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-runs:
+		case <-time.After(2 * time.Second):
+			t.Fatal("scheduled job did not run")
+		}
+	}
+
+	assert.NoError(t, pool.Shutdown(time.Second))
+}
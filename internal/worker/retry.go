@@ -0,0 +1,131 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy retries a task step with exponential backoff and full jitter:
+// the wait after attempt n is uniform in [0, min(MaxDelay, BaseDelay*2^(n-1))].
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxElapsed  time.Duration // 0 means no limit
+
+	// Retryable decides whether an error is worth another attempt. When nil,
+	// every error not wrapped with Permanent is retried.
+	Retryable func(error) bool
+}
+
+// RetryError is returned once a policy gives up. It keeps the error of
+// every failed attempt, oldest first, and unwraps to the last one.
+type RetryError struct {
+	Attempts int
+	Errors   []error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("gave up after %d attempt(s): %v", e.Attempts, e.Unwrap())
+}
+
+func (e *RetryError) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors[len(e.Errors)-1]
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not retryable regardless of the policy predicate.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Backoff returns the jittered delay to wait after the given failed attempt.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+	ceiling := p.BaseDelay
+	for i := 1; i < attempt && ceiling < math.MaxInt64/2 && (p.MaxDelay <= 0 || ceiling < p.MaxDelay); i++ {
+		ceiling *= 2
+	}
+	if p.MaxDelay > 0 && ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Do calls fn until it succeeds, returns a non-retryable error, runs out of
+// attempts or the next wait would exceed MaxElapsed. Context cancellation is
+// returned as-is.
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context, attempt int) error) error {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	start := time.Now()
+	retryErr := &RetryError{}
+
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := fn(ctx, attempt)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		retryErr.Attempts = attempt
+		retryErr.Errors = append(retryErr.Errors, err)
+
+		if attempt >= maxAttempts || !p.shouldRetry(err) {
+			return retryErr
+		}
+
+		delay := p.Backoff(attempt)
+		if p.MaxElapsed > 0 && time.Since(start)+delay > p.MaxElapsed {
+			return retryErr
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p RetryPolicy) shouldRetry(err error) bool {
+	if IsPermanent(err) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return true
+}
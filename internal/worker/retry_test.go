@@ -0,0 +1,94 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"wallet-simulator/internal/worker"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errFlaky = errors.New("flaky")
+
+func TestRetryPolicyBackoffIsCapped(t *testing.T) {
+	policy := worker.RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt := 1; attempt <= 20; attempt++ {
+		delay := policy.Backoff(attempt)
+		assert.GreaterOrEqual(t, delay, time.Duration(0))
+		assert.LessOrEqual(t, delay, time.Second)
+	}
+	assert.LessOrEqual(t, policy.Backoff(1), 100*time.Millisecond)
+}
+
+func TestRetryPolicyRetriesUntilSuccess(t *testing.T) {
+	policy := worker.RetryPolicy{MaxAttempts: 5}
+
+	calls := 0
+	err := policy.Do(context.Background(), func(ctx context.Context, attempt int) error {
+		calls++
+		if attempt < 3 {
+			return errFlaky
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryPolicyGivesUpAfterMaxAttempts(t *testing.T) {
+	policy := worker.RetryPolicy{MaxAttempts: 3}
+
+	err := policy.Do(context.Background(), func(ctx context.Context, attempt int) error {
+		return errFlaky
+	})
+
+	var retryErr *worker.RetryError
+	assert.True(t, errors.As(err, &retryErr))
+	assert.Equal(t, 3, retryErr.Attempts)
+	assert.Len(t, retryErr.Errors, 3)
+	assert.ErrorIs(t, err, errFlaky)
+}
+
+func TestRetryPolicyStopsOnNonRetryableError(t *testing.T) {
+	policy := worker.RetryPolicy{
+		MaxAttempts: 5,
+		Retryable:   func(err error) bool { return !errors.Is(err, errFlaky) },
+	}
+
+	calls := 0
+	err := policy.Do(context.Background(), func(ctx context.Context, attempt int) error {
+		calls++
+		return errFlaky
+	})
+	assert.ErrorIs(t, err, errFlaky)
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	err = worker.RetryPolicy{MaxAttempts: 5}.Do(context.Background(), func(ctx context.Context, attempt int) error {
+		calls++
+		return worker.Permanent(errFlaky)
+	})
+	assert.ErrorIs(t, err, errFlaky)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicyRespectsMaxElapsed(t *testing.T) {
+	policy := worker.RetryPolicy{
+		MaxAttempts: 100,
+		BaseDelay:   time.Hour,
+		MaxElapsed:  time.Millisecond,
+	}
+
+	calls := 0
+	err := policy.Do(context.Background(), func(ctx context.Context, attempt int) error {
+		calls++
+		// Make sure elapsed time is non-zero so the jittered wait cannot fit.
+		time.Sleep(2 * time.Millisecond)
+		return errFlaky
+	})
+	assert.ErrorIs(t, err, errFlaky)
+	assert.Equal(t, 1, calls)
+}